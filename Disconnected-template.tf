@@ -183,5 +183,6 @@ output "ec2_private_hostname" {
 }
 
 output "random_token" {
-  value = random_string.key_suffix.result
+  value     = random_string.key_suffix.result
+  sensitive = true
 }
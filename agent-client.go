@@ -15,18 +15,19 @@ import (
 	"log"
 	"math/big"
 	"net/http"
+	neturl "net/url"
 	"os"
-	"os/exec"
 	"strings"
 	"time"
-
-	"gopkg.in/yaml.v3"
 )
 
 var agentStatus *InfraState
 var infraDetailsStatus *InfraDetails
 var agentAction *DeployDestroy
 
+// The port the agent listens to on the registry host.
+var agentPort = "8090"
+
 func init() {
 	agentStatus = &InfraState{}
 	infraDetailsStatus = &InfraDetails{}
@@ -43,8 +44,30 @@ type DeployDestroy struct {
 }
 
 type InfraState struct {
-	RegistryHealth string
-	ClusterStatus  string
+	RegistryHealth  string
+	ClusterStatus   string
+	BootstrapStatus string
+	RegistryChecks  []RegistryCheck  `json:",omitempty"`
+	Progress        *InstallProgress `json:",omitempty"`
+}
+
+// The outcome of a check the agent runs against the mirror registry. The registry is Healthy when every critical check passed.
+// The agent sends the field names capitalized, which decode into these tags too.
+type RegistryCheck struct {
+	Name      string    `json:"name"`
+	Critical  bool      `json:"critical"`
+	Passed    bool      `json:"passed"`
+	Message   string    `json:"message"`
+	CheckedAt time.Time `json:"checkedAt"`
+}
+
+// The agent replied to a request with a status code other than 200.
+type agentResponseError struct {
+	StatusCode int
+}
+
+func (e *agentResponseError) Error() string {
+	return fmt.Sprintf("the agent responded with error code %v", e.StatusCode)
 }
 
 // Function to get the client status using HTTP. It expects a reply from the agent container running on the registry host.
@@ -58,53 +81,31 @@ func ClientGetStatus(url string) bool {
 
 	fmt.Println("Status Get Request...")
 
-	// Create a new GET request
-	req, err := http.NewRequest("GET", "https://"+url+":8090/status", nil)
-	if err != nil {
-		log.Println("Error creating GET request:", err)
-		fmt.Println("")
-		agentIsDown(err)
-		os.Exit(2)
-	}
-
-	// Set the X-Auth-Token header with the desired value
-	req.Header.Set("X-Auth-Token", infraDetailsStatus.Token)
-
-	//fmt.Printf("The Token is %v\n", infraDetailsStatus.Token)
-
-	// Send the request using an HTTP client
-	resp, err := client.Do(req)
-	if err != nil {
-		log.Println("Error making GET request:", err)
+	if err := fetchAgentStatus(client, url); err != nil {
+		// If the response from the server is not a 200 print the respose code.
+		if respErr, ok := err.(*agentResponseError); ok {
+			fmt.Printf("The agent responded with error code %v\n", respErr.StatusCode)
+			fmt.Println("Response code of 403 means that the request was not authorized. The action cannot be completed.")
+			os.Exit(2)
+		}
+		log.Println(err)
 		fmt.Println("")
 		agentIsDown(err)
 		os.Exit(2)
 	}
-	defer resp.Body.Close()
 
-	// If the response from the server is not a 200 print the respose code.
-	if resp.StatusCode != http.StatusOK {
-		fmt.Printf("The agent responded with error code %v\n", resp.StatusCode)
-		fmt.Println("Response code of 403 means that the request was not authorized. The action cannot be completed.")
-		os.Exit(2)
-	}
+	return printAgentStatus()
+}
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		log.Println("Error reading response body:", err)
-		time.Sleep(5 * time.Second)
-		fmt.Println("")
-		agentIsDown(err)
-		os.Exit(2)
+// Prints the status the agent last replied with. Returns true if the agent reported a known registry state.
+func printAgentStatus() bool {
+	if agentStatus.BootstrapStatus == "NotReady" {
+		fmt.Println("The registry host is still initializing. The READY marker is not present yet")
 	}
-
-	if err := json.Unmarshal(body, agentStatus); err != nil {
-		fmt.Printf("Error unmarshaling JSON: %v\n", err)
-		time.Sleep(5 * time.Second)
-		fmt.Println("")
-		agentIsDown(err)
-		os.Exit(2)
+	if agentStatus.Progress != nil {
+		fmt.Printf("Cluster installation: %s\n", agentStatus.Progress.summary(time.Now()))
 	}
+	printRegistryChecks(agentStatus.RegistryChecks)
 
 	// Check the status of the deployment. Registry health and cluster existence
 	if agentStatus.RegistryHealth == "Healthy" && agentStatus.ClusterStatus == "DontExist" {
@@ -121,6 +122,46 @@ func ClientGetStatus(url string) bool {
 	return false
 }
 
+// Prints a line per registry check. The checks that do not decide the health are marked as warnings when they fail.
+func printRegistryChecks(checks []RegistryCheck) {
+	if len(checks) == 0 {
+		return
+	}
+	fmt.Println("Registry checks:")
+	for _, check := range checks {
+		result := "PASS"
+		if !check.Passed && check.Critical {
+			result = "FAIL"
+		} else if !check.Passed {
+			result = "WARN"
+		}
+		fmt.Printf("  %-4s %-22s %s\n", result, check.Name, check.Message)
+	}
+}
+
+// Requests the status from the agent and stores the reply in agentStatus. Nothing is printed so it can be used for machine-readable output.
+func fetchAgentStatus(client *http.Client, url string) error {
+	resp, err := agentRequest(client, "GET", url, "/status", nil)
+	if err != nil {
+		return fmt.Errorf("error making GET request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return &agentResponseError{StatusCode: resp.StatusCode}
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("error reading response body: %v", err)
+	}
+
+	if err := json.Unmarshal(body, agentStatus); err != nil {
+		return fmt.Errorf("error unmarshaling JSON: %v", err)
+	}
+	return nil
+}
+
 // If agent is down we need to provide some information to the user on some next steps.
 func agentIsDown(clientError error) {
 
@@ -137,98 +178,125 @@ func agentIsDown(clientError error) {
 	}
 }
 
-// This is the client POST request to send the install-config.yaml file to the agent
-func sendInstallConfigToAgent(installconfig string, url string) {
+// A problem the agent found in the install-config.
+type InstallConfigFieldError struct {
+	Field   string
+	Message string
+}
+
+// This is the client POST request to send the install-config.yaml file to the agent.
+// The agent checks the install-config against the cluster version and the lab and replies 422 with the problems it found.
+func sendInstallConfigToAgent(installconfig string, clusterVersion string, url string) error {
 
 	// Create the Client using the CAcert.pem file so can verify the agent TLS cert.
 	client, err := createHTTPClientWithCACert(CAcert)
 	if err != nil {
-		fmt.Printf("Error creating HTTP client: %v\n", err)
-		return
+		return fmt.Errorf("error creating HTTP client: %v", err)
 	}
-	// Convert the installconfig string to a byte buffer
-	requestBody := bytes.NewBuffer([]byte(installconfig))
-
-	// Create a new POST request
-	req, err := http.NewRequest("POST", "https://"+url+":8090/data", requestBody)
+	// Send the install-config with a POST request
+	resp, err := agentRequest(client, "POST", url, "/data?clusterVersion="+neturl.QueryEscape(clusterVersion), []byte(installconfig))
 	if err != nil {
-		log.Fatalf("Error creating POST request: %v", err)
+		return fmt.Errorf("error sending POST request: %v", err)
 	}
+	defer resp.Body.Close()
 
-	// Set the content type and X-Auth-Token headers
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("X-Auth-Token", infraDetailsStatus.Token)
-
-	fmt.Printf("The Token is %v\n", infraDetailsStatus.Token)
-
-	// Send the request using an HTTP client
-	resp, err := client.Do(req)
+	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		log.Fatalf("Error sending POST request: %v", err)
+		return fmt.Errorf("error reading response body: %v", err)
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		fmt.Printf("The agent responded with error code %v\n", resp.StatusCode)
+	if resp.StatusCode == http.StatusUnprocessableEntity {
+		var rejected struct{ Errors []InstallConfigFieldError }
+		if err := json.Unmarshal(body, &rejected); err != nil {
+			return fmt.Errorf("the agent rejected the install-config: %s", strings.TrimSpace(string(body)))
+		}
+		problems := make([]string, 0, len(rejected.Errors))
+		for _, fieldError := range rejected.Errors {
+			problems = append(problems, fmt.Sprintf("%s: %s", fieldError.Field, fieldError.Message))
+		}
+		return fmt.Errorf("the agent rejected the install-config:\n  - %s", strings.Join(problems, "\n  - "))
+	} else if resp.StatusCode != http.StatusOK {
 		fmt.Println("Response code of 403 means that the request was not authorized. The action cannot be completed.")
-		os.Exit(2)
+		return fmt.Errorf("the agent responded with error code %v", resp.StatusCode)
 	}
 
-	// Print response from server
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		log.Fatalf("Error reading response body: %v", err)
-	}
-	log.Println("Response from server:", string(body))
+	// The body is not printed, only the status code.
+	log.Println("Response from server:", resp.Status)
+	return nil
 }
 
 // This is the POST request from the client to tell the agent what to do install/destroy cluster and the cluster version in case of installing.
-func sendActionAndVersionToAgent(url string) {
+// The agent runs the action as a job and replies with it. Agents older than the jobs API get the action on /action and no job is returned.
+func sendActionAndVersionToAgent(url string) (*AgentJob, error) {
 
 	// Create the Client using the CAcert.pem file so can verify the agent TLS cert.
 	client, err := createHTTPClientWithCACert(CAcert)
 	if err != nil {
-		fmt.Printf("Error creating HTTP client: %v\n", err)
-		return
+		return nil, fmt.Errorf("error creating HTTP client: %v", err)
 	}
 	actionForAgent, err := json.Marshal(agentAction)
 	if err != nil {
-		// If there is an error in marshaling print the error
-		fmt.Println("Error marshaling actionForAgent to JSON:", err)
-		return
+		return nil, fmt.Errorf("error marshaling actionForAgent to JSON: %v", err)
 	}
+
 	// Send the JSON data to the server
 	fmt.Println("Sending actionForAgent using Post request")
-	req, err := http.NewRequest("POST", "https://"+url+":8090/action", bytes.NewBuffer(actionForAgent))
+	resp, err := agentRequest(client, "POST", url, "/v1/jobs", actionForAgent)
 	if err != nil {
-		fmt.Println("Error creating actionForAgent request:", err)
-		return
+		return nil, fmt.Errorf("error sending request actionForAgent: %v", err)
 	}
+	defer resp.Body.Close()
 
-	req.Header.Set("X-Auth-Token", infraDetailsStatus.Token)
-
-	fmt.Printf("The Token is %v\n", infraDetailsStatus.Token)
+	switch resp.StatusCode {
+	case http.StatusCreated:
+		job := &AgentJob{}
+		if err := json.NewDecoder(resp.Body).Decode(job); err != nil {
+			return nil, fmt.Errorf("error unmarshaling the job: %v", err)
+		}
+		fmt.Printf("The agent started job %s to %s the cluster. Follow its output with 'ocpd logs --job %s --follow'\n", job.ID, strings.ToLower(job.Action), job.ID)
+		return job, nil
+	case http.StatusConflict:
+		running := &AgentJob{}
+		if err := json.NewDecoder(resp.Body).Decode(running); err != nil {
+			return nil, fmt.Errorf("the agent is already running another job")
+		}
+		return nil, fmt.Errorf("the agent is already running job %s (%s) started at %v. Wait for it with 'ocpd jobs %s --follow'", running.ID, running.Action, running.StartedAt.Format(time.RFC3339), running.ID)
+	case http.StatusNotFound:
+		return nil, sendLegacyActionToAgent(client, url, actionForAgent)
+	default:
+		return nil, &agentResponseError{StatusCode: resp.StatusCode}
+	}
+}
 
-	// Send the request
-	resp, err := client.Do(req)
+// Sends the action to the /action path of agents that do not have the jobs API.
+func sendLegacyActionToAgent(client *http.Client, url string, actionForAgent []byte) error {
+	resp, err := agentRequest(client, "POST", url, "/action", actionForAgent)
 	if err != nil {
-		fmt.Println("Error sending request actionForAgent:", err)
-		return
+		return fmt.Errorf("error sending request actionForAgent: %v", err)
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != 200 {
-		fmt.Printf("The agent responded with error code %v\n", resp.StatusCode)
-		fmt.Println("Responce code of 403 means that the request was not authorized. The action cannot be completed.")
-		os.Exit(2)
+	if resp.StatusCode != http.StatusOK {
+		return &agentResponseError{StatusCode: resp.StatusCode}
 	}
+	fmt.Println("ActionForAgent Data sent successfully!")
+	return nil
+}
 
-	// Check the response
-	if resp.StatusCode == http.StatusOK {
-		fmt.Println("ActionForAgent Data sent successfully!")
-	} else {
-		fmt.Println("Failed to send data actionForAgent. Status code:", resp.StatusCode)
+// Sends a request to the agent. The client certificate authenticates the CLI. The token is sent too when the lab has one,
+// since agents older than the client certificates accept only the token.
+func agentRequest(client *http.Client, method string, url string, path string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequest(method, "https://"+url+":"+agentPort+path, bytes.NewBuffer(body))
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
 	}
+	if len(infraDetailsStatus.Token) > 0 {
+		req.Header.Set("X-Auth-Token", infraDetailsStatus.Token)
+	}
+	return client.Do(req)
 }
 
 // Here we use this function to set the required variables into the struck.
@@ -251,104 +319,59 @@ func populateActionAndVersion(action bool, version string) {
 //The below is the deployment of the Terraform part of the infrastructure.
 //======================================================================================
 
-func applyTerraformConfig() {
+func applyTerraformConfig() error {
 
-	// Read the contents of the Terraform template file
 	fmt.Println("Updating .tfvars file with cluster flag")
-	templateContent, err := os.ReadFile("terraform.tfvars")
-	if err != nil {
-		fmt.Println("Cannot read template file")
-		return
+	if err := SetClusterFlagTerraform(true); err != nil {
+		return err
 	}
 
-	// Set the cluster flag to true and create the terraform.tfvars file
-	replacedClusterFlag := strings.ReplaceAll(string(templateContent), "false", "true")
-	err = os.WriteFile("terraform.tfvars", []byte(replacedClusterFlag), 0644)
-	if err != nil {
-		fmt.Println("Cannot write the Terraform config file")
-		return
+	if err := terraformExecutor.Apply("-target=module.Cluster_Dependencies"); err != nil {
+		return fmt.Errorf("terraform apply failed with: %v", err)
 	}
-
-	cmd := exec.Command("terraform", "apply", "-target=module.Cluster_Dependencies", "-auto-approve")
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-
-	error := cmd.Run()
-	if error != nil {
-		fmt.Printf("Terraform apply failed with: %v", error)
-	}
-
-	// Wait some seconds for terraform to get applied
-	time.Sleep(5 * time.Second)
-
+	return nil
 }
 
-// Add some values to the install.config.yaml according the user entries/flags used.
-func populateInstallConfigValues(sdnFlag bool, installConfigFlag bool) string {
+// Builds the install-config from the lab details and the user flags. With the CustomInstallConfig option the
+// install-config.yaml under the OCPD cloned directory is applied over the default one, the infrastructure details are
+// filled in afterwards.
+func populateInstallConfigValues(options ClusterOptions) string {
 
 	fmt.Println("Populating the install-config.yaml with the required infrastructure details")
-	var installConfig string
-	var cni string
 
-	// If the installConfig flag is appended then read the custom-install-config.yaml file provided by the user and populate this instead the default.
-	if installConfigFlag {
-		fmt.Println("Custom install-config.yaml detected. Populating it with the required infrastructure details")
-		customInstallconfig, err := os.ReadFile("./install-config.yaml")
-		if err != nil {
-			println("Cannot read the install-config.yaml")
-		}
+	suffix, err := randomClusterSuffix()
+	if err != nil {
+		fmt.Printf("Error generating random value: %v\n", err)
+		return ""
+	}
+	clusterName, baseDomain := clusterNaming(options, suffix)
+	installConfig := defaultInstallConfig(options, clusterName, baseDomain)
+	networkType := installConfig.Networking.NetworkType
 
-		var data map[string]interface{}
-		err = yaml.Unmarshal(customInstallconfig, &data)
+	if options.CustomInstallConfig {
+		fmt.Println("Custom install-config.yaml detected. Applying it over the default install-config")
+		overlay, err := os.ReadFile(customInstallConfigFile)
 		if err != nil {
-			log.Fatalf("Error unmarshaling YAML: %v", err)
+			log.Fatalf("Cannot read the install-config.yaml: %v", err)
 		}
-
-		// Marshal the map into JSON
-		installConfigJson, err := json.Marshal(data)
+		installConfig, err = applyInstallConfigOverlay(installConfig, overlay)
 		if err != nil {
-			log.Fatalf("Error marshaling to JSON: %v", err)
+			log.Fatalf("Cannot apply the install-config.yaml: %v", err)
 		}
-
-		// Step 4: Convert JSON bytes to string
-		installConfig = string(installConfigJson)
-
-	} else if !installConfigFlag {
-		installConfig = `{"apiVersion":"v1","baseDomain":"emea.aws.cee.support","credentialsMode":"Passthrough","compute":[{"architecture":"amd64","hyperthreading":"Enabled","name":"worker","platform":{},"replicas":0}],"controlPlane":{"architecture":"amd64","hyperthreading":"Enabled","name":"master","platform":{},"replicas":1},"metadata":{"name":"disconnected-$RANDOM_VALUE"},"networking":{"clusterNetwork":[{"cidr":"10.128.0.0/14","hostPrefix":23}],"machineNetwork":[{"cidr":"10.0.0.0/23"}],"networkType":"$CNI","serviceNetwork":["172.30.0.0/16"]},"platform":{"aws":{"region":"${region}","subnets":["${private_subnet_1}","${private_subnet_2}","${private_subnet_3}"]}},"publish":"Internal","imageContentSources":[{"mirrors":["$hostname:8443/openshift/release"],"source":"quay.io/openshift-release-dev/ocp-v4.0-art-dev"},{"mirrors":["$hostname:8443/openshift/release-images"],"source":"quay.io/openshift-release-dev/ocp-release"}]}`
+		installConfig.expandLegacyPlaceholders(suffix, networkType)
 	}
 
-	// Calculate the range
-	rangeValue := big.NewInt(int64(99999 - 10000 + 1))
-
-	// Generate a random number in [0, rangeValue)
-	randomBigInt, err := rand.Int(rand.Reader, rangeValue)
+	installConfig.setClusterOptions(options)
+	installConfig.setInfraDetails(infraDetailsStatus)
+	installConfigJson, err := installConfig.toJSON()
 	if err != nil {
-		fmt.Printf("Error generating random value: %v\n", err)
-		return ""
-	}
-
-	// Add the minimum value to the result to get the random value in [min, max]
-	randomValue := int(randomBigInt.Int64()) + 10000
-
-	randomValueStr := fmt.Sprintf("%d", randomValue)
-
-	if sdnFlag {
-		cni = "OpenShiftSDN"
-	} else {
-		cni = "OVNKubernetes"
+		log.Fatalf("Error marshaling the install-config to JSON: %v", err)
 	}
 
-	randomName := strings.ReplaceAll(installConfig, "$RANDOM_VALUE", randomValueStr)
-	region := strings.ReplaceAll(randomName, "${region}", infraDetailsStatus.AWSRegion)
-	subnet_1 := strings.ReplaceAll(region, "${private_subnet_1}", infraDetailsStatus.PrivateSubnet1)
-	subnet_2 := strings.ReplaceAll(subnet_1, "${private_subnet_2}", infraDetailsStatus.PrivateSubnet2)
-	subnet_3 := strings.ReplaceAll(subnet_2, "${private_subnet_3}", infraDetailsStatus.PrivateSubnet3)
-	changeCNI := strings.ReplaceAll(subnet_3, "$CNI", cni)
-	changePrivateHostname := strings.ReplaceAll(changeCNI, "$hostname", infraDetailsStatus.PrivateDNS)
+	fmt.Printf("Install-config populated. The cluster is %s.%s with %s control plane nodes and %s workers\n", installConfig.Metadata.Name, installConfig.BaseDomain,
+		replicasString(installConfig.ControlPlane.Replicas), replicasString(installConfig.workerReplicas()))
 
-	fmt.Println("Install-config populated")
-
-	return changePrivateHostname
+	return installConfigJson
 
 }
 
@@ -415,10 +438,63 @@ func createCertificateAuthority() (string, string, error) {
 		fmt.Println("If there is no CAcert.pem file then probably there is no infrastructure present.")
 
 	}
+
+	// The CLI authenticates to the agent with a client certificate issued from the same CA.
+	caCert, err := x509.ParseCertificate(caCertBytes)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to parse CA certificate: %v", err)
+	}
+	if err := createClientCertificate(caCert, caPrivateKey); err != nil {
+		return "", "", err
+	}
+
 	// Return the CA and key in strings to be injected in the EC2 instance.
 	return certPem, keyPem, nil
 }
 
+// Issues the client certificate of the CLI from the CA and saves it with its key next to CAcert.pem.
+// The key is readable only by the user since it is the credential of the CLI.
+func createClientCertificate(caCert *x509.Certificate, caPrivateKey *ecdsa.PrivateKey) error {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return fmt.Errorf("failed to generate client private key: %v", err)
+	}
+
+	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return fmt.Errorf("failed to generate client certificate serial number: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: serialNumber,
+		Subject: pkix.Name{
+			Organization:       []string{"Example CA"},
+			OrganizationalUnit: []string{"operator"},
+			CommonName:         "ocpd-client",
+		},
+		NotBefore:   time.Now(),
+		NotAfter:    caCert.NotAfter,
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+
+	certBytes, err := x509.CreateCertificate(rand.Reader, template, caCert, &privateKey.PublicKey, caPrivateKey)
+	if err != nil {
+		return fmt.Errorf("failed to create client certificate: %v", err)
+	}
+	privBytes, err := x509.MarshalECPrivateKey(privateKey)
+	if err != nil {
+		return fmt.Errorf("failed to marshal client private key: %v", err)
+	}
+
+	if err := os.WriteFile(clientCert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certBytes}), 0644); err != nil {
+		return fmt.Errorf("failed to create file %s: %v", clientCert, err)
+	}
+	if err := os.WriteFile(clientKey, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: privBytes}), 0600); err != nil {
+		return fmt.Errorf("failed to create file %s: %v", clientKey, err)
+	}
+	return nil
+}
+
 // Here we create an HTTP client object to be used by the client fuctions that make the HTTP requests. We use the CA cert for this.
 func createHTTPClientWithCACert(caCertPath string) (*http.Client, error) {
 	// Load CA cert
@@ -436,6 +512,14 @@ func createHTTPClientWithCACert(caCertPath string) (*http.Client, error) {
 		RootCAs: caCertPool,
 	}
 
+	// Present the client certificate so the agent can verify the CLI. Labs installed by older versions do not have one.
+	certificate, err := tls.LoadX509KeyPair(clientCert, clientKey)
+	if err == nil {
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("error loading the client certificate: %v", err)
+	}
+
 	// Create a transport that uses the TLS config
 	transport := &http.Transport{
 		TLSClientConfig: tlsConfig,
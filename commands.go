@@ -0,0 +1,391 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A subcommand of the tool. Each subcommand declares its own flags and validates them before running,
+// so adding a flag to one subcommand does not affect any other.
+type subcommand struct {
+	name    string
+	usage   string
+	summary string
+	run     func(args []string)
+}
+
+var subcommands []*subcommand
+
+func init() {
+	subcommands = []*subcommand{
+		{name: "install", usage: "install --region <region> [--cluster-version <version>] [--sdn] [--custom-install-config] [--base-domain <domain>] [--cluster-name <name>] [--topology sno|compact|ha] [--masters <n>] [--workers <n>] [--master-instance-type <type>] [--worker-instance-type <type>] [--dry-run] [--resume] [--timeout <duration>] [--env <name>]", summary: "Install the mirror registry and optionally a disconnected cluster", run: installCommand},
+		{name: "destroy", usage: "destroy [--force] [--timeout <duration>] [--env <name>]", summary: "Destroy the cluster if present and the mirror registry infrastructure", run: destroyCommand},
+		{name: "cluster", usage: "cluster add|destroy|cancel [flags]", summary: "Add, destroy or cancel the install of a cluster while keeping the existing mirror registry", run: clusterCommand},
+		{name: "add-cluster", usage: "add-cluster --cluster-version <version> [--sdn] [--custom-install-config] [--base-domain <domain>] [--cluster-name <name>] [--topology sno|compact|ha] [--masters <n>] [--workers <n>] [--master-instance-type <type>] [--worker-instance-type <type>] [--dry-run] [--env <name>]", summary: "Same as 'cluster add'", run: clusterAddCommand},
+		{name: "destroy-cluster", usage: "destroy-cluster [--env <name>]", summary: "Same as 'cluster destroy'", run: clusterDestroyCommand},
+		{name: "status", usage: "status [--output text|json] [--env <name>]", summary: "Status of the registry and the cluster. Agent must be healthy", run: statusCommand},
+		{name: "jobs", usage: "jobs [<id>] [--follow] [--timeout <duration>] [--env <name>]", summary: "List the cluster install and destroy jobs of the agent or show one of them", run: jobsCommand},
+		{name: "logs", usage: "logs [--job <id>] [--installer] [--follow] [--env <name>]", summary: "Print the output of an agent job, the latest one by default", run: logsCommand},
+		{name: "credentials", usage: "credentials list|mint <name> [--valid-for <duration>]|revoke <id> [--env <name>]", summary: "Mint, list and revoke read-only credentials so teammates can follow a shared lab", run: credentialsCommand},
+		{name: "env", usage: "env list|create <name>|delete <name>", summary: "Manage named environments so several labs can be kept side by side", run: envCommand},
+		{name: "init", usage: "init", summary: "Save the pull-secret and public-key paths for ease of use", run: initCommand},
+		{name: "version", usage: "version", summary: "Print the OCPD release version", run: versionCommand},
+		{name: "help", usage: "help", summary: "Print this help", run: helpCommand},
+	}
+}
+
+// Returns the subcommand with the given name or nil if there is none.
+func findSubcommand(name string) *subcommand {
+	for _, cmd := range subcommands {
+		if cmd.name == name {
+			return cmd
+		}
+	}
+	return nil
+}
+
+// Prints every available subcommand with a short description.
+func printUsage() {
+	fmt.Println("Usage: ocpd <command> [flags]")
+	fmt.Println("")
+	fmt.Println("Commands:")
+	for _, cmd := range subcommands {
+		fmt.Printf("  %-18s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Println("")
+	fmt.Println("Run 'ocpd <command> --help' to see the flags of each command.")
+}
+
+// Creates the flag set of a subcommand. Its usage output is the usage line of the subcommand followed by its flags.
+func newFlagSet(cmd *subcommand) *flag.FlagSet {
+	fs := flag.NewFlagSet(cmd.name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: ocpd %s\n\n%s\n", cmd.usage, cmd.summary)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// Parses the arguments of a subcommand. Positional arguments are not accepted by any subcommand.
+func parseFlags(fs *flag.FlagSet, args []string) {
+	fs.Parse(args)
+	if fs.NArg() > 0 {
+		usageError(fs, fmt.Sprintf("Unexpected argument %q", fs.Arg(0)))
+	}
+}
+
+// Prints the validation error along with the usage of the subcommand and exits.
+func usageError(fs *flag.FlagSet, message string) {
+	fmt.Fprintln(fs.Output(), message)
+	fs.Usage()
+	os.Exit(1)
+}
+
+// The cluster name is a DNS label and the base domain a DNS domain, openshift-install refuses anything else.
+var (
+	clusterNamePattern  = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]{0,61}[a-z0-9])?$`)
+	baseDomainPattern   = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?(\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)+$`)
+	instanceTypePattern = regexp.MustCompile(`^[a-z][a-z0-9-]*\.[a-z0-9]+$`)
+)
+
+// An integer flag that stays nil when it is not given, so a topology preset can tell it apart from 0.
+type optionalIntFlag struct {
+	value **int
+}
+
+func (f optionalIntFlag) String() string {
+	if f.value == nil || *f.value == nil {
+		return ""
+	}
+	return strconv.Itoa(**f.value)
+}
+
+func (f optionalIntFlag) Set(s string) error {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return fmt.Errorf("%q is not a number", s)
+	}
+	*f.value = &n
+	return nil
+}
+
+// Registers the flags that shape the cluster on the flag set of a subcommand that installs one.
+func clusterOptionsFlags(fs *flag.FlagSet) *ClusterOptions {
+	options := &ClusterOptions{}
+	fs.BoolVar(&options.SDN, "sdn", false, "Use SDN CNI for the cluster instead. OVN is the default (Only for v4.14 installations and lower)")
+	fs.BoolVar(&options.CustomInstallConfig, "custom-install-config", false, "Apply the install-config.yaml under the OCPD cloned directory over the default install-config")
+	fs.StringVar(&options.BaseDomain, "base-domain", "", "Base domain of the cluster. The BaseDomain of the init file or "+defaultBaseDomain+" if not set")
+	fs.StringVar(&options.ClusterName, "cluster-name", "", "Name of the cluster. The ClusterName of the init file or disconnected-<random number> if not set")
+	fs.StringVar(&options.Topology, "topology", "", "Topology of the cluster. One of: sno (default), compact, ha")
+	fs.Var(optionalIntFlag{&options.Masters}, "masters", "Number of control plane nodes of an ha cluster: 3 (default), 4 or 5")
+	fs.Var(optionalIntFlag{&options.Workers}, "workers", "Number of workers of an ha cluster, at least 2 (default 3)")
+	fs.StringVar(&options.MasterInstanceType, "master-instance-type", "", "EC2 instance type of the control plane nodes. The installer default if not set")
+	fs.StringVar(&options.WorkerInstanceType, "worker-instance-type", "", "EC2 instance type of the workers. The installer default if not set")
+	return options
+}
+
+// Checks the cluster options given on the command line. Those of the init file and the overlay are checked by the agent.
+func checkClusterOptions(fs *flag.FlagSet, options *ClusterOptions, clusterVersion string) {
+	if problems := options.validateTopology(clusterVersion); len(problems) > 0 {
+		usageError(fs, strings.Join(problems, "\n"))
+	}
+	if len(options.ClusterName) > 0 && !clusterNamePattern.MatchString(options.ClusterName) {
+		usageError(fs, fmt.Sprintf("The cluster name %q must be at most 63 lower case letters, digits and '-', starting and ending with a letter or a digit", options.ClusterName))
+	}
+	if len(options.BaseDomain) > 0 && !baseDomainPattern.MatchString(options.BaseDomain) {
+		usageError(fs, fmt.Sprintf("The base domain %q is not a valid DNS domain (e.g example.com)", options.BaseDomain))
+	}
+}
+
+func installCommand(args []string) {
+	fs := newFlagSet(findSubcommand("install"))
+	region := fs.String("region", "", "Set the AWS region")
+	clusterVersion := fs.String("cluster-version", "", "Install also a cluster of this version (e.g 4.12.13)")
+	options := clusterOptionsFlags(fs)
+	dryRun := fs.Bool("dry-run", false, "Render every generated file into a scratch directory and run terraform plan instead of apply")
+	resume := fs.Bool("resume", false, "Continue an interrupted install from the phase it stopped at, with the options it was started with")
+	timeout := timeoutFlag(fs)
+	env := envFlag(fs)
+	parseFlags(fs, args)
+	readinessTimeout = *timeout
+
+	if *resume {
+		if len(*region) > 0 || len(*clusterVersion) > 0 || *options != (ClusterOptions{}) || *dryRun {
+			usageError(fs, "The --resume flag continues the install with the options it was started with. Only --timeout and --env can be used along with it")
+		}
+		useEnvironment(*env)
+		resumeInstall()
+		return
+	}
+
+	if len(*region) == 0 {
+		usageError(fs, "Please provide a region for the installation using --region flag")
+	}
+	checkRegionString(regions, *region)
+	if len(*clusterVersion) > 0 {
+		checkClusterVersionString(*clusterVersion)
+		checkClusterOptions(fs, options, *clusterVersion)
+	} else if *options != (ClusterOptions{SDN: options.SDN}) {
+		usageError(fs, "The --custom-install-config, --base-domain, --cluster-name, --topology, --masters, --workers and instance type flags must be used along with the --cluster-version flag")
+	}
+
+	useEnvironment(*env)
+	if *dryRun {
+		dryRunInstall(*region, *clusterVersion, *options)
+		return
+	}
+	runInstall(*region, *clusterVersion, *options)
+}
+
+func destroyCommand(args []string) {
+	fs := newFlagSet(findSubcommand("destroy"))
+	force := fs.Bool("force", false, "Force destroy the infrastructure if agent is unavailable. (Terraform destroy)")
+	timeout := timeoutFlag(fs)
+	env := envFlag(fs)
+	parseFlags(fs, args)
+	readinessTimeout = *timeout
+
+	useEnvironment(*env)
+	runDestroy(*force)
+}
+
+func clusterCommand(args []string) {
+	fs := newFlagSet(findSubcommand("cluster"))
+	if len(args) == 0 {
+		usageError(fs, "Please provide the cluster action. One of: add, destroy, cancel")
+	}
+
+	switch args[0] {
+	case "add":
+		clusterAddCommand(args[1:])
+	case "destroy":
+		clusterDestroyCommand(args[1:])
+	case "cancel":
+		clusterCancelCommand(args[1:])
+	default:
+		usageError(fs, fmt.Sprintf("Unknown cluster action %q. One of: add, destroy, cancel", args[0]))
+	}
+}
+
+func clusterAddCommand(args []string) {
+	fs := newFlagSet(findSubcommand("add-cluster"))
+	clusterVersion := fs.String("cluster-version", "", "The version of the cluster (e.g 4.12.13)")
+	options := clusterOptionsFlags(fs)
+	dryRun := fs.Bool("dry-run", false, "Render the install-config into a scratch directory and run terraform plan for the cluster dependencies instead of apply")
+	env := envFlag(fs)
+	parseFlags(fs, args)
+
+	if len(*clusterVersion) == 0 {
+		usageError(fs, "Please provide the version of the cluster using --cluster-version flag")
+	}
+	checkClusterVersionString(*clusterVersion)
+	checkClusterOptions(fs, options, *clusterVersion)
+
+	useEnvironment(*env)
+	if *dryRun {
+		dryRunAddCluster(*clusterVersion, *options)
+		return
+	}
+	runAddCluster(*clusterVersion, *options)
+}
+
+func clusterDestroyCommand(args []string) {
+	fs := newFlagSet(findSubcommand("destroy-cluster"))
+	env := envFlag(fs)
+	parseFlags(fs, args)
+
+	useEnvironment(*env)
+	runDestroyCluster()
+}
+
+func clusterCancelCommand(args []string) {
+	fs := newFlagSet(&subcommand{name: "cluster cancel", usage: "cluster cancel [--timeout <duration>] [--env <name>]", summary: "Cancel the cluster install or destroy the agent is running"})
+	timeout := timeoutFlag(fs)
+	env := envFlag(fs)
+	parseFlags(fs, args)
+	readinessTimeout = *timeout
+
+	useEnvironment(*env)
+	runCancelCluster()
+}
+
+func statusCommand(args []string) {
+	fs := newFlagSet(findSubcommand("status"))
+	output := fs.String("output", "text", "Output format. One of: text, json")
+	env := envFlag(fs)
+	parseFlags(fs, args)
+
+	useEnvironment(*env)
+	switch *output {
+	case "json":
+		printStatusJSON()
+		return
+	case "text":
+	default:
+		usageError(fs, fmt.Sprintf("Unknown output format %q. One of: text, json", *output))
+	}
+
+	printStatusText()
+}
+
+func jobsCommand(args []string) {
+	fs := newFlagSet(findSubcommand("jobs"))
+	follow := fs.Bool("follow", false, "Wait until the job finishes, printing every change of its phase")
+	timeout := timeoutFlag(fs)
+	env := envFlag(fs)
+
+	// The job id comes before the flags.
+	id := ""
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		id, args = args[0], args[1:]
+	}
+	parseFlags(fs, args)
+	readinessTimeout = *timeout
+
+	if *follow && len(id) == 0 {
+		usageError(fs, "The --follow flag needs the id of the job to follow")
+	}
+
+	useEnvironment(*env)
+	runJobs(id, *follow)
+}
+
+func logsCommand(args []string) {
+	fs := newFlagSet(findSubcommand("logs"))
+	job := fs.String("job", "", "The id of the job. The latest job of the agent is used if not set")
+	installer := fs.Bool("installer", false, "Print the .openshift_install.log of the registry host instead of the output of the job")
+	follow := fs.Bool("follow", false, "Keep printing new lines until the job finishes")
+	env := envFlag(fs)
+	parseFlags(fs, args)
+
+	useEnvironment(*env)
+	runLogs(*job, *installer, *follow)
+}
+
+func credentialsCommand(args []string) {
+	fs := newFlagSet(findSubcommand("credentials"))
+	validFor := fs.Duration("valid-for", 30*24*time.Hour, "How long a minted credential is valid (e.g 72h)")
+	env := envFlag(fs)
+	if len(args) == 0 {
+		usageError(fs, "Please provide the credentials action. One of: list, mint, revoke")
+	}
+
+	// The action and its argument come before the flags.
+	action, operands := args[0], []string{}
+	args = args[1:]
+	if (action == "mint" || action == "revoke") && len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		operands, args = args[:1], args[1:]
+	}
+	parseFlags(fs, args)
+
+	useEnvironment(*env)
+	var err error
+	switch {
+	case action == "list":
+		err = printCredentials()
+	case action == "mint" && len(operands) == 1:
+		err = mintCredential(operands[0], *validFor)
+	case action == "revoke" && len(operands) == 1:
+		err = revokeCredential(operands[0])
+	default:
+		usageError(fs, fmt.Sprintf("Invalid credentials command %q", strings.Join(append([]string{action}, operands...), " ")))
+	}
+
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func envCommand(args []string) {
+	fs := newFlagSet(findSubcommand("env"))
+	if len(args) == 0 {
+		usageError(fs, "Please provide the env action. One of: list, create, delete")
+	}
+
+	var err error
+	switch {
+	case args[0] == "list" && len(args) == 1:
+		printEnvironments()
+	case args[0] == "create" && len(args) == 2:
+		err = createEnvironment(args[1])
+		if err == nil {
+			fmt.Printf("Environment %q created. Use it with --env %s\n", args[1], args[1])
+		}
+	case args[0] == "delete" && len(args) == 2:
+		err = deleteEnvironment(args[1])
+		if err == nil {
+			fmt.Printf("Environment %q deleted\n", args[1])
+		}
+	default:
+		usageError(fs, fmt.Sprintf("Invalid env command %q", strings.Join(args, " ")))
+	}
+
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func initCommand(args []string) {
+	fs := newFlagSet(findSubcommand("init"))
+	parseFlags(fs, args)
+
+	initialization(initFileName)
+}
+
+func versionCommand(args []string) {
+	fs := newFlagSet(findSubcommand("version"))
+	parseFlags(fs, args)
+
+	fmt.Printf("The OCPD release version is %v\n", releaseVersion)
+}
+
+func helpCommand(args []string) {
+	printUsage()
+}
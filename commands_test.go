@@ -0,0 +1,178 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Runs the tool instead of the tests when the test binary is started by runOcpd.
+func TestMain(m *testing.M) {
+	if args := os.Getenv("OCPD_TEST_ARGS"); args != "" {
+		os.Args = append([]string{"ocpd"}, strings.Split(args, "\n")...)
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// Runs the tool with the given arguments in a child process, so the validations that exit can be tested.
+// It returns the exit code and everything the tool printed.
+func runOcpd(t *testing.T, args ...string) (int, string) {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], "-test.run=^$")
+	cmd.Dir = t.TempDir()
+	cmd.Env = append(os.Environ(), "OCPD_TEST_ARGS="+strings.Join(args, "\n"), "HOME="+t.TempDir())
+	output, err := cmd.CombinedOutput()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode(), string(output)
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	return 0, string(output)
+}
+
+func TestResolveCommand(t *testing.T) {
+	tests := []struct {
+		args     []string
+		wantName string
+		wantArgs []string
+		wantErr  bool
+	}{
+		{args: nil, wantName: "help"},
+		{args: []string{"install", "--region", "eu-west-1"}, wantName: "install", wantArgs: []string{"--region", "eu-west-1"}},
+		{args: []string{"cluster", "add", "--cluster-version", "4.14.10"}, wantName: "cluster", wantArgs: []string{"add", "--cluster-version", "4.14.10"}},
+		{args: []string{"status", "--output", "json"}, wantName: "status", wantArgs: []string{"--output", "json"}},
+		{args: []string{"version"}, wantName: "version", wantArgs: []string{}},
+		{args: []string{"--install", "--region", "eu-west-1"}, wantName: "install", wantArgs: []string{"--region=eu-west-1"}},
+		{args: []string{"deploy"}, wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(strings.Join(test.args, " "), func(t *testing.T) {
+			cmd, args, err := resolveCommand(test.args)
+			if test.wantErr {
+				if err == nil {
+					t.Errorf("resolveCommand() = %s, want an error", cmd.name)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("resolveCommand() error = %v", err)
+			}
+			if cmd.name != test.wantName || !reflect.DeepEqual(args, test.wantArgs) {
+				t.Errorf("resolveCommand() = %s %q, want %s %q", cmd.name, args, test.wantName, test.wantArgs)
+			}
+		})
+	}
+}
+
+func TestTranslateLegacyFlags(t *testing.T) {
+	tests := []struct {
+		args     []string
+		wantName string
+		wantArgs []string
+		wantErr  bool
+	}{
+		{
+			args:     []string{"--install", "--region", "eu-west-1", "--cluster-version", "4.14.10", "--sdn"},
+			wantName: "install",
+			wantArgs: []string{"--cluster-version=4.14.10", "--region=eu-west-1", "--sdn=true"},
+		},
+		{
+			args:     []string{"--add-cluster", "--cluster-version", "4.14.10", "--custom-install-config"},
+			wantName: "add-cluster",
+			wantArgs: []string{"--cluster-version=4.14.10", "--custom-install-config=true"},
+		},
+		{args: []string{"--destroy", "--force"}, wantName: "destroy", wantArgs: []string{"--force=true"}},
+		{args: []string{"--destroy-cluster"}, wantName: "destroy-cluster"},
+		{args: []string{"--status", "--output", "json", "--env", "lab1"}, wantName: "status", wantArgs: []string{"--env=lab1", "--output=json"}},
+		{args: []string{"--init"}, wantName: "init"},
+		{args: []string{"--help"}, wantName: "help"},
+		{args: []string{"--install=false", "--status"}, wantName: "status"},
+		// Version wins over any other action and nothing else runs.
+		{args: []string{"--version", "--install", "--region", "eu-west-1"}, wantName: "version"},
+		// Without an action the usage is printed.
+		{args: []string{"--region", "eu-west-1"}, wantName: "help"},
+		{args: []string{"--install", "--destroy"}, wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(strings.Join(test.args, " "), func(t *testing.T) {
+			name, args, err := translateLegacyFlags(test.args)
+			if test.wantErr {
+				if err == nil {
+					t.Errorf("translateLegacyFlags() = %s, want an error", name)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("translateLegacyFlags() error = %v", err)
+			}
+			if name != test.wantName || !reflect.DeepEqual(args, test.wantArgs) {
+				t.Errorf("translateLegacyFlags() = %s %q, want %s %q", name, args, test.wantName, test.wantArgs)
+			}
+			if findSubcommand(name) == nil {
+				t.Errorf("there is no %s subcommand", name)
+			}
+		})
+	}
+}
+
+func TestClusterVersionPattern(t *testing.T) {
+	tests := map[string]bool{
+		"4.14.10":   true,
+		"4.9.0":     true,
+		"4.25.60":   true,
+		"4.26.0":    false,
+		"4.14.61":   false,
+		"5.1.1":     false,
+		"4.14":      false,
+		"4.14.1-rc": false,
+	}
+	for version, want := range tests {
+		if got := clusterVersionPattern.MatchString(version); got != want {
+			t.Errorf("clusterVersionPattern.MatchString(%q) = %v, want %v", version, got, want)
+		}
+	}
+}
+
+// The validations run before anything is changed in the lab, so the tool is run with arguments it refuses only.
+func TestCommandValidation(t *testing.T) {
+	tests := []struct {
+		args       []string
+		wantCode   int
+		wantOutput string
+	}{
+		{args: []string{"deploy"}, wantCode: 1, wantOutput: `Unknown command "deploy"`},
+		{args: []string{"--install", "--destroy"}, wantCode: 1, wantOutput: "The --destroy, --install flags cannot be used together"},
+		{args: []string{"install"}, wantCode: 1, wantOutput: "Please provide a region for the installation"},
+		{args: []string{"install", "--region", "mars-1"}, wantCode: 1, wantOutput: "The region: mars-1 you provided is not a valid AWS region"},
+		{args: []string{"install", "--region", "eu-west-1", "--cluster-version", "4.26.1"}, wantCode: 1, wantOutput: "The provided cluster version: 4.26.1 is not valid"},
+		{args: []string{"install", "--region", "eu-west-1", "--sdn", "--topology", "ha"}, wantCode: 1, wantOutput: "must be used along with the --cluster-version flag"},
+		{args: []string{"--install", "--region", "mars-1"}, wantCode: 1, wantOutput: "The region: mars-1 you provided is not a valid AWS region"},
+		{args: []string{"--install", "--output", "json"}, wantCode: 2, wantOutput: "flag provided but not defined: -output"},
+		{args: []string{"cluster"}, wantCode: 1, wantOutput: "Please provide the cluster action"},
+		{args: []string{"cluster", "upgrade"}, wantCode: 1, wantOutput: `Unknown cluster action "upgrade"`},
+		{args: []string{"cluster", "add"}, wantCode: 1, wantOutput: "Please provide the version of the cluster"},
+		{args: []string{"--add-cluster", "--cluster-version", "5.0.0"}, wantCode: 1, wantOutput: "The provided cluster version: 5.0.0 is not valid"},
+		{args: []string{"cluster", "add", "--cluster-version", "4.14.10", "--cluster-name", "Lab_1"}, wantCode: 1, wantOutput: `The cluster name "Lab_1" must be`},
+		{args: []string{"status", "--output", "xml"}, wantCode: 1, wantOutput: `Unknown output format "xml"`},
+		{args: []string{"status", "extra"}, wantCode: 1, wantOutput: `Unexpected argument "extra"`},
+		{args: []string{"jobs", "--follow"}, wantCode: 1, wantOutput: "The --follow flag needs the id of the job"},
+		{args: []string{"--version", "--destroy"}, wantCode: 0, wantOutput: "The OCPD release version is"},
+	}
+	for _, test := range tests {
+		t.Run(strings.Join(test.args, " "), func(t *testing.T) {
+			code, output := runOcpd(t, test.args...)
+			if code != test.wantCode {
+				t.Errorf("exit code = %d, want %d", code, test.wantCode)
+			}
+			if !strings.Contains(output, test.wantOutput) {
+				t.Errorf("output does not contain %q:\n%s", test.wantOutput, output)
+			}
+		})
+	}
+}
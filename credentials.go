@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Minted credentials are saved under this directory, one directory per teammate.
+const credentialsDir = "credentials"
+
+// A viewer credential minted by the agent. The ID is the serial number of its client certificate.
+type Credential struct {
+	ID        string
+	Name      string
+	Scope     string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	RevokedAt *time.Time `json:",omitempty"`
+}
+
+// The reply of the agent to a mint request. The key is sent only once.
+type MintedCredential struct {
+	Credential
+	Certificate   string
+	Key           string
+	CACertificate string
+}
+
+// Asks the agent to mint a viewer credential for a teammate and saves it as a directory that can be handed over.
+func mintCredential(name string, validFor time.Duration) error {
+	if err := checkEnvironmentName(name); err != nil {
+		return fmt.Errorf("the credential name %q is not valid. Use letters, digits, '-' and '_'", name)
+	}
+	dir := filepath.Join(credentialsDir, name)
+	if _, err := os.Stat(dir); err == nil {
+		return fmt.Errorf("%s already exists. Revoke the credential and remove the directory first", dir)
+	}
+
+	client, host, err := credentialsClient()
+	if err != nil {
+		return err
+	}
+	request, err := json.Marshal(map[string]string{"Name": name, "ValidFor": validFor.String()})
+	if err != nil {
+		return err
+	}
+	resp, err := agentRequest(client, "POST", host, "/v1/credentials", request)
+	if err != nil {
+		return fmt.Errorf("error sending the mint request: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return &agentResponseError{StatusCode: resp.StatusCode}
+	}
+
+	minted := &MintedCredential{}
+	if err := json.NewDecoder(resp.Body).Decode(minted); err != nil {
+		return fmt.Errorf("error unmarshaling the credential: %v", err)
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	files := []struct {
+		name    string
+		content string
+		mode    os.FileMode
+	}{
+		{CAcert, minted.CACertificate, 0644},
+		{clientCert, minted.Certificate, 0644},
+		{clientKey, minted.Key, 0600},
+	}
+	for _, file := range files {
+		if err := os.WriteFile(filepath.Join(dir, file.name), []byte(file.content), file.mode); err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("Viewer credential %s minted for %s. It expires at %v\n", minted.ID, minted.Name, minted.ExpiresAt.Format(time.RFC3339))
+	fmt.Printf("Hand over the %s directory. It allows reading the status, the jobs and their logs, e.g:\n", dir)
+	fmt.Printf("  cd %s && curl --cacert %s --cert %s --key %s https://%s:%s/status\n", dir, CAcert, clientCert, clientKey, host, agentPort)
+	fmt.Printf("Revoke it with 'ocpd credentials revoke %s'\n", minted.ID)
+	return nil
+}
+
+// Revokes a credential. The agent refuses its certificate from now on.
+func revokeCredential(id string) error {
+	client, host, err := credentialsClient()
+	if err != nil {
+		return err
+	}
+	resp, err := agentRequest(client, "DELETE", host, "/v1/credentials/"+url.PathEscape(id), nil)
+	if err != nil {
+		return fmt.Errorf("error sending the revoke request: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return &agentResponseError{StatusCode: resp.StatusCode}
+	}
+
+	credential := &Credential{}
+	if err := json.NewDecoder(resp.Body).Decode(credential); err != nil {
+		return fmt.Errorf("error unmarshaling the credential: %v", err)
+	}
+	fmt.Printf("Credential %s of %s revoked\n", credential.ID, credential.Name)
+	return nil
+}
+
+// Prints every credential the agent minted.
+func printCredentials() error {
+	client, host, err := credentialsClient()
+	if err != nil {
+		return err
+	}
+	var credentials []Credential
+	if err := fetchAgentJSON(client, host, "/v1/credentials", &credentials); err != nil {
+		return err
+	}
+
+	if len(credentials) == 0 {
+		fmt.Println("No credentials have been minted")
+		return nil
+	}
+	fmt.Printf("%-34s %-16s %-8s %-26s %s\n", "ID", "NAME", "SCOPE", "EXPIRES", "STATE")
+	for _, credential := range credentials {
+		state := "active"
+		if credential.RevokedAt != nil {
+			state = "revoked"
+		} else if time.Now().After(credential.ExpiresAt) {
+			state = "expired"
+		}
+		fmt.Printf("%-34s %-16s %-8s %-26s %s\n", credential.ID, credential.Name, credential.Scope, credential.ExpiresAt.Format(time.RFC3339), state)
+	}
+	return nil
+}
+
+func credentialsClient() (*http.Client, string, error) {
+	if err := readInfraDetails(); err != nil {
+		return nil, "", err
+	}
+	client, err := createHTTPClientWithCACert(CAcert)
+	if err != nil {
+		return nil, "", err
+	}
+	return client, infraDetailsStatus.InstancePublicDNS, nil
+}
@@ -3,13 +3,10 @@ package main
 import (
 	"bufio"
 	"encoding/json"
-	"flag"
 	"fmt"
 	"log"
 	"os"
-	"os/exec"
 	"strings"
-	"time"
 )
 
 const (
@@ -18,6 +15,8 @@ const (
 	pullSecretTemplate     = "pull-secret.template"
 	initFileName           = "initData.json"
 	CAcert                 = "CAcert.pem"
+	clientCert             = "client.pem"
+	clientKey              = "client-key.pem"
 	releaseVersion         = "v2.3"
 )
 
@@ -48,214 +47,164 @@ var pullSecretPath string
 var publicKeyPath string
 
 func main() {
-
-	// All flags that make this tool.
-	region := flag.String("region", "", "Set the AWS region")
-	installFlag := flag.Bool("install", false, "Install Registry")
-	destroyFlag := flag.Bool("destroy", false, "Destroy Registry")
-	clusterVersion := flag.String("cluster-version", "", "Set the prefered cluster version")
-	initFlag := flag.Bool("init", false, "Saving pull-secret and public-key for ease of use")
-	openshiftCNI := flag.Bool("sdn", false, "Use SDN CNI for the cluster instead. OVN is the default")
-	helpFlag := flag.Bool("help", false, "Help")
-	statusFlag := flag.Bool("status", false, "Status of the deployment")
-	addClusterFlag := flag.Bool("add-cluster", false, "To deploy a cluster but keep the existing registry")
-	destroyClusterFlag := flag.Bool("destroy-cluster", false, "To destroy the cluster but keep the existing registry")
-	installConfigFlag := flag.Bool("custom-install-config", false, "Edit the default install-config.yaml")
-	forceFlag := flag.Bool("force", false, "Force destroy the infrastructure if agent is unavailable. (Terraform destroy)")
-	versionFlag := flag.Bool("version", false, "Show the OCPD relese version")
-
-	flag.Parse()
-
-	//If used with other flags it will return so nothing will happen as its checked first. I won't add this in the Flag policy.
-	if *versionFlag {
-		fmt.Printf("The OCPD release version is %v\n", releaseVersion)
-		return
+	cmd, args, err := resolveCommand(os.Args[1:])
+	if err != nil {
+		fmt.Printf("%v\n\n", err)
+		printUsage()
+		os.Exit(1)
 	}
+	cmd.run(args)
+}
 
-	// This is a function that has policies for all flags to prevent program failure if user provide them incorectly. Check flags.go package for the code.
-	consolidatedFlagCheckFunction(*installFlag, *destroyFlag, *region, *clusterVersion, *initFlag, *helpFlag, *openshiftCNI, *destroyClusterFlag, *addClusterFlag, *installConfigFlag, *forceFlag)
+// Returns the subcommand to run for the command line and its arguments. No arguments at all prints the available subcommands.
+// The flag only form (ocpd --install --region ...) is kept as a deprecated alias of the subcommands. Check flags.go for the translation.
+func resolveCommand(args []string) (*subcommand, []string, error) {
+	if len(args) == 0 {
+		return findSubcommand("help"), nil, nil
+	}
 
-	// Here we handle the case where the user will attempt to add a cluster when a registry host is already provisioned.
-	if *addClusterFlag && len(*clusterVersion) > 0 {
-		GetInfraDetails()
-		agentRegistryStatus := ClientGetStatus(infraDetailsStatus.InstancePublicDNS)
-		if agentRegistryStatus {
-			applyTerraformConfig()
-			GetInfraDetails()
-			installConfig := populateInstallConfigValues(*openshiftCNI, *installConfigFlag)
-			sendInstallConfigToAgent(installConfig, infraDetailsStatus.InstancePublicDNS)
-			populateActionAndVersion(true, *clusterVersion)
-			sendActionAndVersionToAgent(infraDetailsStatus.InstancePublicDNS)
-		} else if agentStatus.ClusterStatus == "Exists" {
-			fmt.Println("There is already an existing cluster installation present and cannot deploy a new one")
-		} else {
-			fmt.Println("Agent or Registry unhealthy")
+	if strings.HasPrefix(args[0], "-") {
+		name, forwarded, err := translateLegacyFlags(args)
+		if err != nil {
+			return nil, nil, err
 		}
-		return
+		return findSubcommand(name), forwarded, nil
 	}
 
-	// Here we hadle the case where the user will attempt to destroy a cluster ONLY. Not the registry host too.
-	if *destroyClusterFlag {
-		GetInfraDetails()
-		agentRegistryStatus := ClientGetStatus(infraDetailsStatus.InstancePublicDNS)
-		if agentRegistryStatus && agentStatus.ClusterStatus == "Exists" {
-			populateActionAndVersion(false, *clusterVersion)
-			sendActionAndVersionToAgent(infraDetailsStatus.InstancePublicDNS)
-		} else if agentStatus.ClusterStatus == "DontExist" {
-			fmt.Println("There is no cluster installation present.")
-		} else {
-			fmt.Println("Agent or Registry unhealthy")
-		}
-		return
+	cmd := findSubcommand(args[0])
+	if cmd == nil {
+		return nil, nil, fmt.Errorf("Unknown command %q", args[0])
 	}
+	return cmd, args[1:], nil
+}
 
-	// Here we can use the --status flag to get information on what we have provisioned and what not. Returns if a cluster is present and if QUAY registry is healhty.
-	if *statusFlag {
-		GetInfraDetails()
-		ClientGetStatus(infraDetailsStatus.InstancePublicDNS)
-		return
+// Installs the mirror registry and if a cluster version is provided also a cluster on top of it.
+func runInstall(region string, clusterVersion string, options ClusterOptions) {
+
+	// An install that did not finish must be resumed or cleaned up first so its infrastructure is not lost.
+	previous, err := readInstallState()
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	} else if previous != nil {
+		fmt.Printf("A previous install in region %s did not finish. Completed phases: %v\n", previous.Region, previous.CompletedPhases)
+		fmt.Println("Run 'ocpd install --resume' to continue it or 'ocpd destroy' to clean it up")
+		os.Exit(1)
+	}
+
+	// Check if there is already installed infrastructure before you redeploy.
+	if _, err := os.Stat("./terraform.tfstate"); os.IsNotExist(err) {
+		fmt.Println("No terraform.tfstate file detected. The tool is probably run for the first time")
+	} else if err == nil {
+		fmt.Println("The terraform.tfstate file is detected. Checking current state.")
+	} else {
+		fmt.Println("Error:", err)
 	}
 
-	// If init flag is used then start interactive prompt to get the paths
-	if *initFlag {
+	// Delete left over templates in case it was not cleaned up properly. Normally this should not be required but adding just in case
+	deleteGeneratedFiles()
+
+	// Check if the credentials are present if not ask for them
+	if _, err := os.Stat(initFileName); os.IsNotExist(err) {
+		fmt.Println("Error: The pull-Secret Path and public-Key Path must be provided. Running init interactive prompt")
 		initialization(initFileName)
-		return
 	}
-	// If the help flag is used display the flag descriptions
-	if *helpFlag {
-		flagsHelp()
+	if _, found := regions[region]; !found {
+		fmt.Println("Invalid or unsupported region:", region)
 		return
 	}
 
-	// If the install flag is used do appropriate actions for installation
-	if *installFlag {
+	state := &InstallState{Region: region, ClusterVersion: clusterVersion, ClusterOptions: options}
+	if err := state.save(); err != nil {
+		fmt.Printf("Cannot save the install state: %v\n", err)
+		os.Exit(1)
+	}
+	finishInstall(state)
+}
 
-		// Check if there is already installed infrastructure before you redeploy.
-		if _, err := os.Stat("./terraform.tfstate"); os.IsNotExist(err) {
-			fmt.Println("No terraform.tfstate file detected. The tool is probably run for the first time")
-		} else if err == nil {
-			fmt.Println("The terraform.tfstate file is detected. Checking current state.")
-		} else {
-			fmt.Println("Error:", err)
-		}
+// Continues an install that was interrupted from the first phase that did not complete.
+func resumeInstall() {
+	state, err := readInstallState()
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	} else if state == nil {
+		fmt.Println("There is no unfinished install to resume")
+		os.Exit(1)
+	}
 
-		// Delete left over templates in case it was not cleaned up properly. Normally this should not be required but adding just in case
-		deleteGeneratedFiles()
+	fmt.Printf("Resuming the install in region %s. Completed phases: %v\n", state.Region, state.CompletedPhases)
+	finishInstall(state)
+}
 
-		// Check if the credentials are present if not ask for them
-		if _, err := os.Stat(initFileName); os.IsNotExist(err) {
-			fmt.Println("Error: The pull-Secret Path and public-Key Path must be provided. Running init interactive prompt")
-			initialization(initFileName)
-		}
-		amiID, found := regions[*region]
-		if !found {
-			fmt.Println("Invalid or unsupported region:", *region)
-			return
-		}
-		pullSecretPath, publicKeyPath = readPathsFromFile(initFileName)
-		CAcertString, CAkeyString, err := createCertificateAuthority()
-		if err != nil {
-			fmt.Printf("Couldn't generate the CA cert and key with error: %v\n", err)
+func finishInstall(state *InstallState) {
+	if err := runInstallPhases(state); err != nil {
+		fmt.Printf("The install stopped: %v\n", err)
+		fmt.Println("Fix the problem and run 'ocpd install --resume' to continue from this phase")
+		os.Exit(1)
+	}
+}
+
+// Here we handle the case where the user will attempt to add a cluster when a registry host is already provisioned.
+func runAddCluster(clusterVersion string, options ClusterOptions) {
+	GetInfraDetails()
+	agentRegistryStatus := ClientGetStatus(infraDetailsStatus.InstancePublicDNS)
+	if agentRegistryStatus && agentStatus.ClusterStatus == "Exists" {
+		fmt.Println("There is already an existing cluster installation present and cannot deploy a new one")
+	} else if agentRegistryStatus {
+		if err := applyTerraformConfig(); err != nil {
+			fmt.Println(err)
 			return
 		}
-		if len(*clusterVersion) > 0 {
-			clusterFlag := true
-			installRegistry(clusterFlag, pullSecretPath, publicKeyPath, *region, amiID, *clusterVersion, *openshiftCNI, *installConfigFlag, CAcertString, CAkeyString)
-			return
-		} else {
-			clusterFlag := false
-			installRegistry(clusterFlag, pullSecretPath, publicKeyPath, *region, amiID, *clusterVersion, *openshiftCNI, *installConfigFlag, CAcertString, CAkeyString)
-			return
+		GetInfraDetails()
+		installConfig := populateInstallConfigValues(options)
+		if err := sendInstallConfigToAgent(installConfig, clusterVersion, infraDetailsStatus.InstancePublicDNS); err != nil {
+			fmt.Println(err)
+			os.Exit(2)
 		}
-
-		// If destroy flag is used destroy all
-	} else if *destroyFlag && !*forceFlag {
-		destroyRegistry()
-		return
-		// If agent is down --force flag will simply destroy the mirror-registry host using raw terraform destroy command.
-	} else if *destroyFlag && *forceFlag {
-		fmt.Println("Destroying the infrastructure by running Terraform destroy command")
-		mode := "destroy"
-		terraformErr := runTerraform(mode)
-		if terraformErr != nil {
-			log.Fatalf("Failed to execute terraform destroy: %v", terraformErr)
-			return
+		populateActionAndVersion(true, clusterVersion)
+		if _, err := sendActionAndVersionToAgent(infraDetailsStatus.InstancePublicDNS); err != nil {
+			fmt.Println(err)
+			os.Exit(2)
 		}
-		deleteGeneratedFiles()
-		return
+	} else {
+		fmt.Println("Agent or Registry unhealthy")
 	}
-
-	flagsHelp()
 }
 
-// This function executes the terraform command, Can be either apply or destroy.
-func runTerraform(mode string) error {
-	cmd := exec.Command("terraform", mode, "-auto-approve")
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-
-	err := cmd.Run()
-	if err != nil {
-		return err
+// Here we hadle the case where the user will attempt to destroy a cluster ONLY. Not the registry host too.
+func runDestroyCluster() {
+	GetInfraDetails()
+	agentRegistryStatus := ClientGetStatus(infraDetailsStatus.InstancePublicDNS)
+	if agentRegistryStatus && agentStatus.ClusterStatus == "Exists" {
+		populateActionAndVersion(false, "")
+		if _, err := sendActionAndVersionToAgent(infraDetailsStatus.InstancePublicDNS); err != nil {
+			fmt.Println(err)
+			os.Exit(2)
+		}
+	} else if agentStatus.ClusterStatus == "DontExist" {
+		fmt.Println("There is no cluster installation present.")
+	} else {
+		fmt.Println("Agent or Registry unhealthy")
 	}
-
-	return nil
 }
 
-// This is the main function that is being used to install the infrastructure requested by the user. Could be ONLY registry or also a cluster
-func installRegistry(clusterFlag bool, pullSecretPath string, publicKeyPath string, region string, region_ami string, clusterVersion string, sdnCNI bool, installConfigFlag bool, CAcertString string, CAkeyString string) {
-
-	// Create new PullSecretTemplate
-	createPullSecretTemplate(pullSecretPath)
-	// Update bash script with Pull Secret and Certs for the agent
-	updateRegistryScriptFile(pullSecretTemplate, CAcertString, CAkeyString)
-	// Replace the appropriate values in registry template terraform file
-	UpdateCreateTfFileRegistry(publicKeyPath, region, region_ami)
-
-	cmd := exec.Command("terraform", "init")
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-
-	cmd.Run()
+// Destroys the whole infrastructure. With force the agent is not contacted and terraform destroy is run directly.
+func runDestroy(force bool) {
+	if !force {
+		destroyRegistry()
+		return
+	}
 
-	mode := "apply"
-	//Run the terraform apply command
-	err := runTerraform(mode)
-	if err != nil {
-		log.Fatalf("Failed to execute terraform apply: %v", err)
-	}
-
-	// If there is a --cluster-version flag defined here we start the cluster installation. We contact the agent and agent is installing the cluster from the registry.
-	if clusterFlag {
-		fmt.Println("Sleeping for 5 minutes while waiting for the Registry and Agent to come up")
-		time.Sleep(5 * time.Minute)
-		applyTerraformConfig()
-		for i := 1; i <= 10; i++ {
-			GetInfraDetails()
-			agentRegistryStatus := ClientGetStatus(infraDetailsStatus.InstancePublicDNS)
-			if agentRegistryStatus && agentStatus.ClusterStatus == "DontExist" {
-				installConfig := populateInstallConfigValues(sdnCNI, installConfigFlag)
-				sendInstallConfigToAgent(installConfig, infraDetailsStatus.InstancePublicDNS)
-				populateActionAndVersion(true, clusterVersion)
-				// We need to let the mirror-registry to initialize properly before we run the installation script.
-				fmt.Println("Waiting for 5 minutes to make sure everything initialized normally")
-				time.Sleep(5 * time.Minute)
-				sendActionAndVersionToAgent(infraDetailsStatus.InstancePublicDNS)
-				break
-			} else if agentRegistryStatus && agentStatus.ClusterStatus == "Exists" {
-				fmt.Println("There is already a cluster deployed.")
-				break
-			} else if !agentRegistryStatus {
-				fmt.Printf("Try No %v ... Registry is not yet ready. Retrying in 20 seconds", i)
-			}
-			if i == 10 {
-				fmt.Println("The Registry is not up after 10 retries ( 8 minutes). There might be something wrong. Stop retrying")
-			}
-			time.Sleep(10 * time.Second)
-		}
-	} else {
-		fmt.Println("No cluster version specified. Deploying only the registy.")
+	// If agent is down --force will simply destroy the mirror-registry host using raw terraform destroy command.
+	printResourcesToDestroy()
+	fmt.Println("Destroying the infrastructure by running Terraform destroy command")
+	terraformErr := terraformExecutor.Destroy()
+	if terraformErr != nil {
+		log.Fatalf("Failed to execute terraform destroy: %v", terraformErr)
 	}
+	deleteGeneratedFiles()
+	deleteLabFiles()
 }
 
 // This fuction is to destroy the infrastructure. If agent is active first checks if there is a cluster there so to destroy this also.
@@ -264,35 +213,36 @@ func destroyRegistry() {
 	agentRegistryStatus := ClientGetStatus(infraDetailsStatus.InstancePublicDNS)
 	if agentStatus.ClusterStatus == "Exists" {
 		populateActionAndVersion(false, "")
-		sendActionAndVersionToAgent(infraDetailsStatus.InstancePublicDNS)
+		if _, err := sendActionAndVersionToAgent(infraDetailsStatus.InstancePublicDNS); err != nil {
+			fmt.Println(err)
+			fmt.Println("The registry is not destroyed so the cluster is not left orphan. Check the cluster on the registry host")
+			return
+		}
 	} else if agentRegistryStatus && agentStatus.ClusterStatus == "DontExist" {
 		fmt.Println("Cluster does not exist. Destroying only the registry")
 	}
-	for i := 1; i <= 10; i++ {
-		agentRegistryStatus := ClientGetStatus(infraDetailsStatus.InstancePublicDNS)
-		if agentRegistryStatus {
-			if agentStatus.ClusterStatus == "DontExist" {
-				// Run the terraform destroy command
-				fmt.Println("Destroying the infrastructure by running Terraform destroy command")
-				mode := "destroy"
-				terraformErr := runTerraform(mode)
-				if terraformErr != nil {
-					log.Fatalf("Failed to execute terraform destroy: %v", terraformErr)
-					return
-				}
-				deleteGeneratedFiles()
-				break
-			} else if agentStatus.ClusterStatus == "Exists" {
-				fmt.Printf("Try No %v... Cluster is still in destroying state, Re-checking in 2 minutes\n", i)
-			}
-			if i == 10 {
-				fmt.Println("The Registry is not up after 10 retries ( 8 minutes). There might be something wrong. Stop retrying")
-				break
-			}
-			time.Sleep(2 * time.Minute)
+
+	err := waitFor("the cluster to be destroyed", readinessTimeout, func() (bool, error) {
+		if err := refreshAgentStatus(); err != nil {
+			return false, err
 		}
+		return agentStatus.ClusterStatus == "DontExist", nil
+	})
+	if err != nil {
+		fmt.Println(err)
+		fmt.Println("The registry is not destroyed so the cluster is not left orphan. Check the cluster on the registry host")
+		return
+	}
 
+	// Run the terraform destroy command
+	printResourcesToDestroy()
+	fmt.Println("Destroying the infrastructure by running Terraform destroy command")
+	terraformErr := terraformExecutor.Destroy()
+	if terraformErr != nil {
+		log.Fatalf("Failed to execute terraform destroy: %v", terraformErr)
 	}
+	deleteGeneratedFiles()
+	deleteLabFiles()
 	fmt.Println("The infrastructure destroyed successfully")
 }
 
@@ -323,7 +273,8 @@ func updateRegistryScriptFile(pullSecretTemp string, CAcert string, CAkey string
 func deleteGeneratedFiles() {
 	Script := os.Remove(registryScript)
 	PullSecretTemp := os.Remove(pullSecretTemplate)
-	os.Remove("terraform.tfvars")
+	os.Remove(legacyTfvarsFile)
+	os.Remove(tfvarsFile)
 	os.Remove("infra_details.json")
 	os.Remove(CAcert)
 
@@ -332,6 +283,14 @@ func deleteGeneratedFiles() {
 	}
 }
 
+// Removes the files that belong to a lab once it is destroyed: the client credentials issued by its CA and the state of an install that did not finish.
+// Running init or a new install must keep them or the lab could no longer be reached or resumed.
+func deleteLabFiles() {
+	os.Remove(clientCert)
+	os.Remove(clientKey)
+	os.Remove(installStateFile)
+}
+
 // It creates the pull Secret Template from the pull-secret.json provided by the user
 func createPullSecretTemplate(pullSecret string) {
 
@@ -388,62 +347,24 @@ func createPullSecretTemplate(pullSecret string) {
 
 // Here we populate the tfvars file with the infrastructure details before it is being used by Terraform.
 func UpdateCreateTfFileRegistry(publicKey string, region string, amiID string) {
-
-	// Read the contents of the Terraform template file
 	fmt.Println("Updating and creating the Registry terraform file")
-	templateContent, err := os.ReadFile("terraform.tfvars.temp")
-	if err != nil {
-		fmt.Println("Cannot read template file")
-		return
-	}
-
-	availZoneA := (region + "a")
-	availZoneB := (region + "b")
-	availZoneC := (region + "c")
-
-	// Replace the placeholder string with the generated public key path
-	replacedPublicKey := strings.ReplaceAll(string(templateContent), "PUBLIC_KEY", publicKey)
-	replacedRegion := strings.ReplaceAll(string(replacedPublicKey), "AWS_REGION", region)
-	replacedAvailabilityZoneA := strings.ReplaceAll(string(replacedRegion), "AVAILABILITY_ZONE_A", availZoneA)
-	replacedAvailabilityZoneB := strings.ReplaceAll(string(replacedAvailabilityZoneA), "AVAILABILITY_ZONE_B", availZoneB)
-	replacedAvailabilityZoneC := strings.ReplaceAll(string(replacedAvailabilityZoneB), "AVAILABILITY_ZONE_C", availZoneC)
-	updatedFile := strings.ReplaceAll(string(replacedAvailabilityZoneC), "AMI_ID", amiID)
-	err = os.WriteFile("terraform.tfvars", []byte(updatedFile), 0644)
-	if err != nil {
-		fmt.Println("Cannot write the Terraform config file")
-		return
+	if err := newTerraformVars(publicKey, region, amiID).write(); err != nil {
+		fmt.Printf("Cannot write the Terraform config file: %v\n", err)
 	}
 }
 
 // Here we set the flag to the tfvars file in case there is a cluster installation required so we can provision all the cluster required resources.
-func SetClusterFlagTerraform(flag bool) {
-	// Read the contents of the Terraform template file
-	fmt.Println("Creating the .tfvars file")
-	templateContent, err := os.ReadFile("terraform.tfvars.temp")
+// Only the cluster flag is changed. Every other variable keeps its value.
+func SetClusterFlagTerraform(flag bool) error {
+	vars, err := readTerraformVars()
 	if err != nil {
-		fmt.Println("Cannot read template file")
-		return
+		return fmt.Errorf("cannot read the terraform variables: %v", err)
 	}
-	if flag {
-		flag_string := "true"
-		// Set the cluster flag to true and create the terraform.tfvars file
-		replacedClusterFlag := strings.ReplaceAll(string(templateContent), "false", flag_string)
-		err = os.WriteFile("terraform.tfvars", []byte(replacedClusterFlag), 0644)
-		if err != nil {
-			fmt.Println("Cannot write the Terraform config file")
-			return
-		}
-
-	} else if !flag {
-		flag_string := "false"
-		// Set the cluster flag to false and create the terraform.tfvars file
-		replacedClusterFlag := strings.ReplaceAll(string(templateContent), "false", flag_string)
-		err = os.WriteFile("terraform.tfvars", []byte(replacedClusterFlag), 0644)
-		if err != nil {
-			fmt.Println("Cannot write the Terraform config file")
-			return
-		}
+	vars.CreateCluster = flag
+	if err := vars.write(); err != nil {
+		return fmt.Errorf("cannot write the terraform variables: %v", err)
 	}
+	return nil
 }
 
 // Ask the user using shell prompt whatever is in question variable and return the input string.
@@ -501,10 +422,23 @@ func readPathsFromFile(filename string) (pullSecret string, publickey string) {
 	return pullSecretPathCurrent, publicKeyPathCurrent
 }
 
+// Reads every setting of the init file, the paths and the optional BaseDomain and ClusterName. A missing file has no settings.
+func readInitSettings(filename string) map[string]string {
+	settings := map[string]string{}
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return settings
+	}
+	if err := json.Unmarshal(data, &settings); err != nil {
+		fmt.Printf("Cannot read the settings of the init file: %v\n", err)
+	}
+	return settings
+}
+
 // The whole process of getting the data and writing in the initData.json file
 func getInitData(filepath string) {
-	// Create a Map to store the paths provided
-	pathMap := make(map[string]string)
+	// Keep the other settings of the file like BaseDomain and ClusterName
+	pathMap := readInitSettings(filepath)
 
 	// Get the paths using interactive CLI
 	pullSecretPathTemp := interactiveCLIFunction("Provide the absolute path of the pull-secret")
@@ -540,65 +474,37 @@ func initialization(initFile string) {
 	fmt.Printf("Using public-key from file: %v\n", publicKeyPath)
 }
 
-// Its being used as an additional way to check the provisioned infrastructure in case the agent is down. Its checking specific objects existence in the tfstate file.
+// Its being used as an additional way to check the provisioned infrastructure in case the agent is down. It prints every resource
+// terraform tracks in the tfstate file and reports if the registry and the cluster dependencies are among them.
 func checkDeploymentState() (registyStatus bool, clusterStatus bool) {
-	// Read the JSON file
-	jsonData, err := os.ReadFile("./terraform.tfstate")
+	inventory, err := readInventory()
 	if err != nil {
 		fmt.Println("Probably there is no infrastructure provisioned or terraform.tfstate file is deleted/corrupted.")
 		fmt.Println("Check if there is registry-mirror-script-terraform.tpl file under OCPD dir. If yes there might be orphan resources left to AWS")
-		log.Fatal(err)
-
-	}
-
-	// Unmarshal the JSON into an empty interface (map[string]interface{})
-	var data map[string]interface{}
-	if err := json.Unmarshal(jsonData, &data); err != nil {
-		fmt.Println("Probably there is no infrastructure provisioned or terraform.tfstate file is deleted/corrupted.")
-		fmt.Println("Check if there is registry-mirror-script-terraform.tpl file under OCPD dir. If yes there might be orphan resources left to AWS")
-		log.Fatal(err)
-
-	}
-
-	// Check if "resources" key exists
-	resources, resourcesExist := data["resources"].([]interface{})
-	if !resourcesExist {
-		log.Fatal("Error: 'resources' key is missing or not an array")
-	}
-
-	// Search for "aws_instance" type
-	awsInstanceExists := false
-	for _, resource := range resources {
-		if res, ok := resource.(map[string]interface{}); ok {
-			if resType, typeExist := res["type"].(string); typeExist && resType == "aws_instance" {
-				awsInstanceExists = true
-				break
-			}
-		}
-	}
-
-	// Search for "aws_iam_user" type
-	enpointsExists := false
-	for _, resource := range resources {
-		if res, ok := resource.(map[string]interface{}); ok {
-			if resType, typeExist := res["type"].(string); typeExist && resType == "aws_vpc_endpoint" {
-				enpointsExists = true
-				break
-			}
-		}
+		fmt.Printf("Cannot read the terraform state: %v\n", err)
+		return false, false
 	}
 
 	// Check what resources exist.
-	if awsInstanceExists && enpointsExists {
+	registryExists, clusterExists := inventory.RegistryExists(), inventory.ClusterExists()
+	if registryExists && clusterExists {
 		fmt.Println("There is infrastructure present.Already installed mirror registry and cluster")
-		return true, true
-	} else if awsInstanceExists {
+	} else if registryExists {
 		fmt.Println("There is infrastructure present. Already installed mirror registry")
-		return true, false
-	} else {
-		fmt.Println("There is no infrastructure provisioned")
-		return false, false
 	}
+	inventory.print()
+	return registryExists, clusterExists
+}
+
+// Prints the resources terraform destroy is about to remove from AWS.
+func printResourcesToDestroy() {
+	inventory, err := readInventory()
+	if err != nil {
+		fmt.Printf("Cannot read the terraform state: %v\n", err)
+		return
+	}
+	fmt.Println("The following resources will be destroyed")
+	inventory.print()
 }
 
 // Here we define the struct that will hold the infrastructure details.
@@ -613,61 +519,69 @@ type InfraDetails struct {
 	Token             string
 }
 
-// This functions gets the infrastructure ids from terraform and adds them in the struct InfraDetails for later use from the program
-func GetInfraDetails() {
-
-	initString := "terraform output --raw "
+// A single output as printed by "terraform output -json". All outputs of the configuration are strings.
+type TerraformOutput struct {
+	Value string `json:"value"`
+}
 
-	region, err := GetTerraformOutputs(initString + "region")
-	if err != nil {
-		log.Fatalf("Failed to get region: %s\n", err)
-	}
+// The outputs of the terraform configuration we use. Outputs that are not present in the state are left empty.
+type TerraformOutputs struct {
+	Region            TerraformOutput `json:"region"`
+	InstancePublicDNS TerraformOutput `json:"ec2_instance_public_dns"`
+	PrivateSubnet1    TerraformOutput `json:"private_subnet_1_id"`
+	PrivateSubnet2    TerraformOutput `json:"private_subnet_2_id"`
+	PrivateSubnet3    TerraformOutput `json:"private_subnet_3_id"`
+	PrivateDNS        TerraformOutput `json:"ec2_private_hostname"`
+	RandomToken       TerraformOutput `json:"random_token"`
+}
 
-	instanceDNS, err := GetTerraformOutputs(initString + "ec2_instance_public_dns")
-	if err != nil {
-		log.Fatalf("Failed to get instanceId: %s\n", err)
+// This functions gets the infrastructure ids from terraform and adds them in the struct InfraDetails for later use from the program
+func GetInfraDetails() {
+	if err := readInfraDetails(); err != nil {
+		log.Fatalf("Failed to get the infrastructure details: %s\n", err)
 	}
+}
 
-	subnet1ID, err := GetTerraformOutputs(initString + "private_subnet_1_id")
+// Same as GetInfraDetails but returns the error instead of exiting. Only the outputs without which the agent cannot be contacted are required.
+// The private subnets are "N/A" while there is no cluster and are stored as empty values.
+func readInfraDetails() error {
+	outputs, err := GetTerraformOutputs()
 	if err != nil {
-		log.Fatalf("Failed to get private subnet 1: %s\n", err)
+		return err
 	}
 
-	subnet2ID, err := GetTerraformOutputs(initString + "private_subnet_2_id")
-	if err != nil {
-		log.Fatalf("Failed to get private subnet 2: %s\n", err)
-	}
+	infraDetailsStatus.AWSRegion = outputs.Region.Value
+	infraDetailsStatus.InstancePublicDNS = outputs.InstancePublicDNS.Value
+	infraDetailsStatus.PrivateSubnet1 = optionalOutput(outputs.PrivateSubnet1)
+	infraDetailsStatus.PrivateSubnet2 = optionalOutput(outputs.PrivateSubnet2)
+	infraDetailsStatus.PrivateSubnet3 = optionalOutput(outputs.PrivateSubnet3)
+	infraDetailsStatus.PrivateDNS = outputs.PrivateDNS.Value
+	infraDetailsStatus.Token = outputs.RandomToken.Value
 
-	subnet3ID, err := GetTerraformOutputs(initString + "private_subnet_3_id")
-	if err != nil {
-		log.Fatalf("Failed to get private subnet 3: %s\n", err)
+	if len(infraDetailsStatus.InstancePublicDNS) == 0 {
+		return fmt.Errorf("the registry instance is not present in the terraform outputs")
 	}
+	return nil
+}
 
-	ec2PrivateDNS, err := GetTerraformOutputs(initString + "ec2_private_hostname")
-	if err != nil {
-		log.Fatalf("Failed to get private DNS: %s\n", err)
+// Outputs that exist only when a cluster is requested are "N/A" otherwise.
+func optionalOutput(output TerraformOutput) string {
+	if output.Value == "N/A" {
+		return ""
 	}
+	return output.Value
+}
 
-	randomToken, err := GetTerraformOutputs(initString + "random_token")
+// Thats a helper for executing the terraform output command once and decoding every output.
+func GetTerraformOutputs() (*TerraformOutputs, error) {
+	output, err := terraformExecutor.Output()
 	if err != nil {
-		log.Fatalf("Failed to get private DNS: %s\n", err)
+		return nil, fmt.Errorf("terraform output failed: %v", err)
 	}
 
-	infraDetailsStatus.AWSRegion = region
-	infraDetailsStatus.InstancePublicDNS = instanceDNS
-	infraDetailsStatus.PrivateSubnet1 = subnet1ID
-	infraDetailsStatus.PrivateSubnet2 = subnet2ID
-	infraDetailsStatus.PrivateSubnet3 = subnet3ID
-	infraDetailsStatus.PrivateDNS = ec2PrivateDNS
-	infraDetailsStatus.Token = randomToken
-}
-
-// Thats a helper for executing the terraform output commands
-func GetTerraformOutputs(Cmd string) (string, error) {
-	cmd := exec.Command("bash", "-c", Cmd)
-	output, err := cmd.Output()
-	if err != nil {
-		return "", err
+	outputs := &TerraformOutputs{}
+	if err := json.Unmarshal(output, outputs); err != nil {
+		return nil, fmt.Errorf("cannot decode the terraform outputs: %v", err)
 	}
-	return strings.TrimSpace(string(output)), nil
+	return outputs, nil
 }
@@ -0,0 +1,1026 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Creates a lab directory with the templates and the credentials the flows need and makes it the working directory.
+func setupLab(t *testing.T) string {
+	t.Helper()
+
+	repoDir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err := copyFile(filepath.Join(repoDir, registryScriptTemplate), filepath.Join(dir, registryScriptTemplate)); err != nil {
+		t.Fatal(err)
+	}
+
+	pullSecret := filepath.Join(dir, "pull-secret.json")
+	publicKey := filepath.Join(dir, "id_rsa.pub")
+	writeFile(t, pullSecret, `{"auths":{"cloud.openshift.com":{"auth":"a"},"quay.io":{"auth":"b"}}}`)
+	writeFile(t, publicKey, "ssh-rsa AAAA test")
+	if err := writePathsToFile(filepath.Join(dir, initFileName), map[string]string{"PullSecretPath": pullSecret, "PublicKeyPath": publicKey}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(repoDir) })
+
+	agentStatus = &InfraState{}
+	infraDetailsStatus = &InfraDetails{}
+	agentAction = &DeployDestroy{}
+	return dir
+}
+
+// Makes the waits of the flows check again almost immediately.
+func useShortWaits(t *testing.T, timeout time.Duration) {
+	t.Helper()
+	previousTimeout, previousInitial, previousMax := readinessTimeout, pollInitialDelay, pollMaxDelay
+	readinessTimeout, pollInitialDelay, pollMaxDelay = timeout, 10*time.Millisecond, 20*time.Millisecond
+	t.Cleanup(func() {
+		readinessTimeout, pollInitialDelay, pollMaxDelay = previousTimeout, previousInitial, previousMax
+	})
+}
+
+// Replaces the terraform CLI with a fake for the duration of the test.
+func useFakeTerraform(t *testing.T, outputs map[string]string) *fakeTerraform {
+	t.Helper()
+	fake := newFakeTerraform(outputs)
+	previous := terraformExecutor
+	terraformExecutor = fake
+	t.Cleanup(func() { terraformExecutor = previous })
+	return fake
+}
+
+// A fake agent that replies with the given state and records what the client sends.
+// Jobs finish as soon as they are created. With legacy set it has no jobs API, like agents older than it.
+type fakeAgent struct {
+	sync.Mutex
+	status        InfraState
+	installConfig string
+	// The version sent with the install-config and the problems the fake agent finds in it.
+	installConfigVersion string
+	installConfigErrors  []InstallConfigFieldError
+	actions              []DeployDestroy
+	jobs                 []AgentJob
+	running              *AgentJob
+	legacy               bool
+	clientNames          []string
+	credentials          []Credential
+}
+
+// Records the action and applies it to the status of the fake agent.
+func (agent *fakeAgent) run(action DeployDestroy) {
+	agent.actions = append(agent.actions, action)
+	// Installing and destroying are instant for the fake agent.
+	if action.Deploy == "Destroy" {
+		agent.status.ClusterStatus = "DontExist"
+	} else if action.Deploy == "Install" {
+		agent.status.ClusterStatus = "Exists"
+	}
+}
+
+// Starts the fake agent, saves its certificate as the CA of the lab and points the client to it.
+// If the lab already has a CA, client certificates issued from it are verified and the token becomes optional like on the
+// real agent. Otherwise the token is required like on older agents.
+// The returned outputs are the terraform outputs of a lab whose registry is the fake agent.
+func startFakeAgent(t *testing.T, status InfraState) (*fakeAgent, map[string]string) {
+	t.Helper()
+	agent := &fakeAgent{status: status}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		agent.Lock()
+		defer agent.Unlock()
+		json.NewEncoder(w).Encode(agent.status)
+	})
+	mux.HandleFunc("/data", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		agent.Lock()
+		defer agent.Unlock()
+		agent.installConfigVersion = r.URL.Query().Get("clusterVersion")
+		if len(agent.installConfigErrors) > 0 {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			json.NewEncoder(w).Encode(struct{ Errors []InstallConfigFieldError }{agent.installConfigErrors})
+			return
+		}
+		agent.installConfig = string(body)
+	})
+	mux.HandleFunc("/action", func(w http.ResponseWriter, r *http.Request) {
+		var action DeployDestroy
+		json.NewDecoder(r.Body).Decode(&action)
+		agent.Lock()
+		agent.run(action)
+		agent.Unlock()
+	})
+	mux.HandleFunc("/v1/jobs", func(w http.ResponseWriter, r *http.Request) {
+		agent.Lock()
+		defer agent.Unlock()
+		if agent.legacy {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Method == "GET" {
+			json.NewEncoder(w).Encode(agent.jobs)
+			return
+		}
+		if agent.running != nil {
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(agent.running)
+			return
+		}
+
+		var action DeployDestroy
+		json.NewDecoder(r.Body).Decode(&action)
+		agent.run(action)
+		now, exitStatus := time.Now(), 0
+		job := AgentJob{ID: fmt.Sprintf("job%d", len(agent.jobs)+1), Action: action.Deploy, ClusterVersion: action.ClusterVersion, Phase: "Succeeded", StartedAt: now, EndedAt: &now, ExitStatus: &exitStatus}
+		agent.jobs = append(agent.jobs, job)
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(job)
+	})
+	mux.HandleFunc("/v1/credentials", func(w http.ResponseWriter, r *http.Request) {
+		var request struct{ Name, ValidFor string }
+		json.NewDecoder(r.Body).Decode(&request)
+		validFor, _ := time.ParseDuration(request.ValidFor)
+		agent.Lock()
+		defer agent.Unlock()
+		credential := Credential{ID: fmt.Sprintf("c%d", len(agent.credentials)+1), Name: request.Name, Scope: "viewer", CreatedAt: time.Now(), ExpiresAt: time.Now().Add(validFor)}
+		agent.credentials = append(agent.credentials, credential)
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(MintedCredential{Credential: credential, Certificate: "cert", Key: "key", CACertificate: "ca"})
+	})
+	mux.HandleFunc("/v1/credentials/", func(w http.ResponseWriter, r *http.Request) {
+		agent.Lock()
+		defer agent.Unlock()
+		for i := range agent.credentials {
+			if r.Method == "DELETE" && agent.credentials[i].ID == strings.TrimPrefix(r.URL.Path, "/v1/credentials/") {
+				now := time.Now()
+				agent.credentials[i].RevokedAt = &now
+				json.NewEncoder(w).Encode(agent.credentials[i])
+				return
+			}
+		}
+		http.NotFound(w, r)
+	})
+	mux.HandleFunc("/v1/jobs/", func(w http.ResponseWriter, r *http.Request) {
+		agent.Lock()
+		defer agent.Unlock()
+		id, logs := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/v1/jobs/"), "/logs")
+		id, cancel := strings.CutSuffix(id, "/cancel")
+		for i, job := range agent.jobs {
+			if job.ID != id {
+				continue
+			}
+			if cancel {
+				// Cancelling is instant for the fake agent.
+				if job.finished() {
+					w.WriteHeader(http.StatusConflict)
+					json.NewEncoder(w).Encode(job)
+					return
+				}
+				now, exitStatus := time.Now(), -1
+				agent.jobs[i].Phase, agent.jobs[i].CancelRequestedAt, agent.jobs[i].EndedAt, agent.jobs[i].ExitStatus = "Cancelled", &now, &now, &exitStatus
+				w.WriteHeader(http.StatusAccepted)
+				json.NewEncoder(w).Encode(job)
+			} else if !logs {
+				json.NewEncoder(w).Encode(job)
+			} else if r.URL.Query().Get("source") == "installer" {
+				fmt.Fprintf(w, "level=info msg=Install complete!\n")
+			} else {
+				fmt.Fprintf(w, "%s output of job %s (follow=%s)\n", job.Action, job.ID, r.URL.Query().Get("follow"))
+			}
+			return
+		}
+		http.NotFound(w, r)
+	})
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := r.Header.Get("X-Auth-Token")
+		if len(r.TLS.VerifiedChains) > 0 {
+			agent.Lock()
+			agent.clientNames = append(agent.clientNames, r.TLS.VerifiedChains[0][0].Subject.CommonName)
+			agent.Unlock()
+		} else if len(token) == 0 {
+			token = "missing"
+		}
+		if len(token) > 0 && token != "token" {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	}))
+	if caCert, err := os.ReadFile(CAcert); err == nil {
+		clientCAs := x509.NewCertPool()
+		clientCAs.AppendCertsFromPEM(caCert)
+		server.TLS = &tls.Config{ClientAuth: tls.VerifyClientCertIfGiven, ClientCAs: clientCAs}
+	}
+	server.StartTLS()
+	t.Cleanup(server.Close)
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	writeFile(t, CAcert, string(certPEM))
+
+	serverURL, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	previousPort := agentPort
+	agentPort = serverURL.Port()
+	t.Cleanup(func() { agentPort = previousPort })
+
+	outputs := map[string]string{
+		"region":                  "eu-west-1",
+		"ec2_instance_public_dns": serverURL.Hostname(),
+		"ec2_private_hostname":    "ip-10-0-0-10.eu-west-1.compute.internal",
+		"private_subnet_1_id":     "N/A",
+		"private_subnet_2_id":     "N/A",
+		"private_subnet_3_id":     "N/A",
+		"random_token":            "token",
+	}
+	return agent, outputs
+}
+
+func writeFile(t *testing.T, path string, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(content)
+}
+
+func TestInstallRegistryOnly(t *testing.T) {
+	dir := setupLab(t)
+	fake := useFakeTerraform(t, nil)
+
+	runInstall("eu-west-1", "", ClusterOptions{})
+
+	if want := []string{"init", "apply"}; !reflect.DeepEqual(fake.calls, want) {
+		t.Errorf("terraform calls = %v, want %v", fake.calls, want)
+	}
+
+	vars, err := readTerraformVars()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := newTerraformVars(filepath.Join(dir, "id_rsa.pub"), "eu-west-1", regions["eu-west-1"]); *vars != *want {
+		t.Errorf("terraform variables = %+v, want %+v", vars, want)
+	}
+
+	script := readFile(t, registryScript)
+	if strings.Contains(script, "$CA_CERT$") || strings.Contains(script, "$PULL_SECRET_CONTENT$") {
+		t.Errorf("registry script still has placeholders")
+	}
+	if strings.Contains(script, "cloud.openshift.com") {
+		t.Errorf("registry script pull-secret still has cloud.openshift.com")
+	}
+	if _, err := os.Stat(CAcert); err != nil {
+		t.Errorf("CA cert was not saved: %v", err)
+	}
+}
+
+func TestAddCluster(t *testing.T) {
+	setupLab(t)
+	agent, outputs := startFakeAgent(t, InfraState{RegistryHealth: "Healthy", ClusterStatus: "DontExist"})
+	fake := useFakeTerraform(t, outputs)
+	UpdateCreateTfFileRegistry("/tmp/id_rsa.pub", "eu-west-1", regions["eu-west-1"])
+
+	// Once the cluster dependencies are applied terraform reports the private subnets.
+	outputs["private_subnet_1_id"] = "subnet-1"
+	outputs["private_subnet_2_id"] = "subnet-2"
+	outputs["private_subnet_3_id"] = "subnet-3"
+
+	runAddCluster("4.14.10", ClusterOptions{})
+
+	if want := []string{"output", "apply -target=module.Cluster_Dependencies", "output"}; !reflect.DeepEqual(fake.calls, want) {
+		t.Errorf("terraform calls = %v, want %v", fake.calls, want)
+	}
+	if vars, err := readTerraformVars(); err != nil || !vars.CreateCluster || vars.Region != "eu-west-1" {
+		t.Errorf("terraform variables = %+v, %v. want the cluster flag set", vars, err)
+	}
+
+	var installConfig map[string]interface{}
+	if err := json.Unmarshal([]byte(agent.installConfig), &installConfig); err != nil {
+		t.Fatalf("agent received an invalid install-config: %v", err)
+	}
+	aws := installConfig["platform"].(map[string]interface{})["aws"].(map[string]interface{})
+	if aws["region"] != "eu-west-1" || !reflect.DeepEqual(aws["subnets"], []interface{}{"subnet-1", "subnet-2", "subnet-3"}) {
+		t.Errorf("install-config platform = %v", aws)
+	}
+
+	if want := []DeployDestroy{{ClusterVersion: "4.14.10", Deploy: "Install"}}; !reflect.DeepEqual(agent.actions, want) {
+		t.Errorf("agent actions = %v, want %v", agent.actions, want)
+	}
+}
+
+func TestInstallConfigOverlay(t *testing.T) {
+	setupLab(t)
+	infraDetailsStatus = &InfraDetails{AWSRegion: "eu-west-1", PrivateSubnet1: "subnet-1", PrivateSubnet2: "subnet-2", PrivateSubnet3: "subnet-3", PrivateDNS: "ip-10-0-0-10.eu-west-1.compute.internal"}
+	writeFile(t, customInstallConfigFile, `
+compute:
+  - name: worker
+    replicas: 3
+    platform:
+      aws:
+        type: m6i.xlarge
+controlPlane:
+  replicas: 3
+platform:
+  aws:
+    region: us-east-1
+    userTags:
+      owner: me
+publish: null
+fips: true
+`)
+
+	var installConfig map[string]interface{}
+	if err := json.Unmarshal([]byte(populateInstallConfigValues(ClusterOptions{CustomInstallConfig: true})), &installConfig); err != nil {
+		t.Fatal(err)
+	}
+	compute := installConfig["compute"].([]interface{})[0].(map[string]interface{})
+	if compute["replicas"] != 3.0 || compute["hyperthreading"] != "Enabled" || compute["platform"].(map[string]interface{})["aws"].(map[string]interface{})["type"] != "m6i.xlarge" {
+		t.Errorf("compute = %v, want 3 m6i.xlarge workers merged into the default pool", compute)
+	}
+	if controlPlane := installConfig["controlPlane"].(map[string]interface{}); controlPlane["replicas"] != 3.0 || controlPlane["name"] != "master" {
+		t.Errorf("controlPlane = %v, want 3 masters", controlPlane)
+	}
+	// The lab fields are filled in whatever the overlay says, the other fields of the overlay are kept.
+	aws := installConfig["platform"].(map[string]interface{})["aws"].(map[string]interface{})
+	if aws["region"] != "eu-west-1" || !reflect.DeepEqual(aws["subnets"], []interface{}{"subnet-1", "subnet-2", "subnet-3"}) || aws["userTags"] == nil {
+		t.Errorf("platform.aws = %v", aws)
+	}
+	if _, found := installConfig["publish"]; found || installConfig["fips"] != true {
+		t.Errorf("publish = %v, fips = %v. want publish removed and fips kept", installConfig["publish"], installConfig["fips"])
+	}
+	if sources := installConfig["imageContentSources"].([]interface{}); len(sources) != 2 {
+		t.Errorf("imageContentSources = %v, want the two release repositories", sources)
+	}
+}
+
+func TestInstallConfigOverlayWithPlaceholders(t *testing.T) {
+	setupLab(t)
+	infraDetailsStatus = &InfraDetails{AWSRegion: "eu-west-1", PrivateSubnet1: "subnet-1", PrivateSubnet2: "subnet-2", PrivateSubnet3: "subnet-3", PrivateDNS: "ip-10-0-0-10.eu-west-1.compute.internal"}
+	writeFile(t, customInstallConfigFile, `
+metadata:
+  name: disconnected-$RANDOM_VALUE
+networking:
+  networkType: $CNI
+platform:
+  aws:
+    region: ${region}
+    subnets:
+      - ${private_subnet_1}
+imageContentSources:
+  - mirrors:
+      - $hostname:8443/openshift/release
+    source: quay.io/openshift-release-dev/ocp-v4.0-art-dev
+`)
+
+	installConfig := populateInstallConfigValues(ClusterOptions{SDN: true, CustomInstallConfig: true})
+	if strings.Contains(installConfig, "$") {
+		t.Errorf("install-config = %s, want every placeholder replaced", installConfig)
+	}
+	if !strings.Contains(installConfig, `"networkType":"OpenShiftSDN"`) || !strings.Contains(installConfig, `"ip-10-0-0-10.eu-west-1.compute.internal:8443/openshift/release-images"`) {
+		t.Errorf("install-config = %s, want OpenShiftSDN and both mirrors", installConfig)
+	}
+}
+
+func TestClusterNaming(t *testing.T) {
+	dir := setupLab(t)
+	settings := readInitSettings(filepath.Join(dir, initFileName))
+	settings["BaseDomain"] = "apac.example.com"
+	if err := writePathsToFile(initFileName, settings); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, customInstallConfigFile, "metadata:\n  name: from-overlay\n")
+
+	var installConfig InstallConfig
+	if err := yaml.Unmarshal([]byte(populateInstallConfigValues(ClusterOptions{})), &installConfig); err != nil {
+		t.Fatal(err)
+	}
+	if installConfig.BaseDomain != "apac.example.com" || !strings.HasPrefix(installConfig.Metadata.Name, "disconnected-") {
+		t.Errorf("cluster = %s.%s, want disconnected-<random>.apac.example.com from the init file", installConfig.Metadata.Name, installConfig.BaseDomain)
+	}
+
+	// The overlay wins over the init file and the flags win over both.
+	if err := yaml.Unmarshal([]byte(populateInstallConfigValues(ClusterOptions{CustomInstallConfig: true})), &installConfig); err != nil {
+		t.Fatal(err)
+	}
+	if installConfig.Metadata.Name != "from-overlay" {
+		t.Errorf("cluster name = %s, want from-overlay", installConfig.Metadata.Name)
+	}
+	options := ClusterOptions{CustomInstallConfig: true, ClusterName: "lab1", BaseDomain: "team.example.com"}
+	if err := yaml.Unmarshal([]byte(populateInstallConfigValues(options)), &installConfig); err != nil {
+		t.Fatal(err)
+	}
+	if installConfig.Metadata.Name != "lab1" || installConfig.BaseDomain != "team.example.com" {
+		t.Errorf("cluster = %s.%s, want lab1.team.example.com", installConfig.Metadata.Name, installConfig.BaseDomain)
+	}
+}
+
+func TestAddClusterWhenClusterExists(t *testing.T) {
+	setupLab(t)
+	agent, outputs := startFakeAgent(t, InfraState{RegistryHealth: "Healthy", ClusterStatus: "Exists"})
+	fake := useFakeTerraform(t, outputs)
+
+	runAddCluster("4.14.10", ClusterOptions{})
+
+	if want := []string{"output"}; !reflect.DeepEqual(fake.calls, want) {
+		t.Errorf("terraform calls = %v, want %v", fake.calls, want)
+	}
+	if len(agent.actions) != 0 {
+		t.Errorf("agent actions = %v, want none", agent.actions)
+	}
+}
+
+func TestInstallConfigRejected(t *testing.T) {
+	setupLab(t)
+	agent, outputs := startFakeAgent(t, InfraState{RegistryHealth: "Healthy", ClusterStatus: "DontExist"})
+	agent.installConfigErrors = []InstallConfigFieldError{
+		{Field: "networking.networkType", Message: "OpenShiftSDN cannot be used to install 4.16.1, use OVNKubernetes"},
+		{Field: "platform.aws.region", Message: "must be the region of the lab eu-west-1, not us-east-1"},
+	}
+	useFakeTerraform(t, outputs)
+	GetInfraDetails()
+
+	err := sendInstallConfigToAgent(populateInstallConfigValues(ClusterOptions{SDN: true}), "4.16.1", infraDetailsStatus.InstancePublicDNS)
+	if err == nil || !strings.Contains(err.Error(), "networking.networkType: OpenShiftSDN cannot be used") || !strings.Contains(err.Error(), "platform.aws.region") {
+		t.Errorf("sendInstallConfigToAgent() error = %v, want the field errors", err)
+	}
+	if agent.installConfigVersion != "4.16.1" || agent.installConfig != "" {
+		t.Errorf("agent got version %q and install-config %q, want 4.16.1 and no install-config", agent.installConfigVersion, agent.installConfig)
+	}
+}
+
+func TestDestroyWithoutCluster(t *testing.T) {
+	setupLab(t)
+	if _, _, err := createCertificateAuthority(); err != nil {
+		t.Fatal(err)
+	}
+	agent, outputs := startFakeAgent(t, InfraState{RegistryHealth: "Healthy", ClusterStatus: "DontExist"})
+	fake := useFakeTerraform(t, outputs)
+	UpdateCreateTfFileRegistry("/tmp/id_rsa.pub", "eu-west-1", regions["eu-west-1"])
+
+	runDestroy(false)
+
+	if want := []string{"output", "output", "destroy"}; !reflect.DeepEqual(fake.calls, want) {
+		t.Errorf("terraform calls = %v, want %v", fake.calls, want)
+	}
+	if len(agent.actions) != 0 {
+		t.Errorf("agent actions = %v, want none", agent.actions)
+	}
+	for _, file := range []string{tfvarsFile, clientCert, clientKey} {
+		if _, err := os.Stat(file); !os.IsNotExist(err) {
+			t.Errorf("%s was not cleaned up", file)
+		}
+	}
+}
+
+func TestDestroyWithCluster(t *testing.T) {
+	setupLab(t)
+	agent, outputs := startFakeAgent(t, InfraState{RegistryHealth: "Healthy", ClusterStatus: "Exists"})
+	fake := useFakeTerraform(t, outputs)
+
+	runDestroy(false)
+
+	if want := []DeployDestroy{{ClusterVersion: "N/A", Deploy: "Destroy"}}; !reflect.DeepEqual(agent.actions, want) {
+		t.Errorf("agent actions = %v, want %v", agent.actions, want)
+	}
+	if want := []string{"output", "output", "destroy"}; !reflect.DeepEqual(fake.calls, want) {
+		t.Errorf("terraform calls = %v, want %v", fake.calls, want)
+	}
+}
+
+func TestDestroyWhileJobRunning(t *testing.T) {
+	setupLab(t)
+	agent, outputs := startFakeAgent(t, InfraState{RegistryHealth: "Healthy", ClusterStatus: "Exists"})
+	agent.running = &AgentJob{ID: "job1", Action: "Install", Phase: "Running", StartedAt: time.Now()}
+	fake := useFakeTerraform(t, outputs)
+
+	runDestroy(false)
+
+	// The agent refused the destroy so the registry must be kept.
+	if len(agent.actions) != 0 {
+		t.Errorf("agent actions = %v, want none", agent.actions)
+	}
+	if contains(fake.calls, "destroy") {
+		t.Errorf("terraform calls = %v, want no destroy", fake.calls)
+	}
+	if _, err := os.Stat(CAcert); err != nil {
+		t.Errorf("the CA of the lab was removed: %v", err)
+	}
+}
+
+func TestSendActionToLegacyAgent(t *testing.T) {
+	setupLab(t)
+	agent, outputs := startFakeAgent(t, InfraState{RegistryHealth: "Healthy", ClusterStatus: "Exists"})
+	agent.legacy = true
+	useFakeTerraform(t, outputs)
+	GetInfraDetails()
+
+	populateActionAndVersion(false, "")
+	job, err := sendActionAndVersionToAgent(infraDetailsStatus.InstancePublicDNS)
+	if err != nil || job != nil {
+		t.Fatalf("sendActionAndVersionToAgent() = %v, %v. want no job and no error", job, err)
+	}
+	if want := []DeployDestroy{{ClusterVersion: "N/A", Deploy: "Destroy"}}; !reflect.DeepEqual(agent.actions, want) {
+		t.Errorf("agent actions = %v, want %v", agent.actions, want)
+	}
+}
+
+func TestFollowJob(t *testing.T) {
+	setupLab(t)
+	useShortWaits(t, time.Second)
+	agent, outputs := startFakeAgent(t, InfraState{RegistryHealth: "Healthy", ClusterStatus: "DontExist"})
+	useFakeTerraform(t, outputs)
+	GetInfraDetails()
+
+	populateActionAndVersion(true, "4.14.10")
+	job, err := sendActionAndVersionToAgent(infraDetailsStatus.InstancePublicDNS)
+	if err != nil || job == nil || job.ID != "job1" {
+		t.Fatalf("sendActionAndVersionToAgent() = %v, %v. want job1", job, err)
+	}
+
+	client, err := createHTTPClientWithCACert(CAcert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := followAgentJob(client, infraDetailsStatus.InstancePublicDNS, job.ID); err != nil {
+		t.Errorf("followAgentJob() error = %v", err)
+	}
+
+	agent.Lock()
+	agent.jobs[0].Phase = "Failed"
+	agent.jobs[0].Error = "exit status 3"
+	*agent.jobs[0].ExitStatus = 3
+	agent.Unlock()
+	if err := followAgentJob(client, infraDetailsStatus.InstancePublicDNS, job.ID); err == nil || !strings.Contains(err.Error(), "exit status 3") {
+		t.Errorf("followAgentJob() error = %v, want the job failure", err)
+	}
+}
+
+func TestCancelRunningJob(t *testing.T) {
+	setupLab(t)
+	useShortWaits(t, time.Second)
+	agent, outputs := startFakeAgent(t, InfraState{RegistryHealth: "Healthy", ClusterStatus: "Exists"})
+	useFakeTerraform(t, outputs)
+	GetInfraDetails()
+	client, err := createHTTPClientWithCACert(CAcert)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if job, err := cancelAgentJob(client, infraDetailsStatus.InstancePublicDNS); err != nil || job != nil {
+		t.Fatalf("cancelAgentJob() = %v, %v. want nothing to cancel", job, err)
+	}
+
+	agent.jobs = []AgentJob{{ID: "job1", Action: "Install", Phase: "Running", StartedAt: time.Now()}}
+	job, err := cancelAgentJob(client, infraDetailsStatus.InstancePublicDNS)
+	if err != nil || job == nil || job.Phase != "Cancelled" || job.CancelRequestedAt == nil {
+		t.Fatalf("cancelAgentJob() = %v, %v. want job1 cancelled", job, err)
+	}
+	if err := followAgentJob(client, infraDetailsStatus.InstancePublicDNS, job.ID); err == nil || !strings.Contains(err.Error(), "cancelled") {
+		t.Errorf("followAgentJob() error = %v, want the job cancelled", err)
+	}
+}
+
+func TestStreamJobLog(t *testing.T) {
+	setupLab(t)
+	_, outputs := startFakeAgent(t, InfraState{RegistryHealth: "Healthy", ClusterStatus: "DontExist"})
+	useFakeTerraform(t, outputs)
+	GetInfraDetails()
+
+	populateActionAndVersion(true, "4.14.10")
+	job, err := sendActionAndVersionToAgent(infraDetailsStatus.InstancePublicDNS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := createHTTPClientWithCACert(CAcert)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out strings.Builder
+	if err := streamAgentLog(client, infraDetailsStatus.InstancePublicDNS, job.ID, false, true, &out); err != nil {
+		t.Fatalf("streamAgentLog() error = %v", err)
+	}
+	if want := "Install output of job job1 (follow=true)\n"; out.String() != want {
+		t.Errorf("job log = %q, want %q", out.String(), want)
+	}
+
+	out.Reset()
+	if err := streamAgentLog(client, infraDetailsStatus.InstancePublicDNS, job.ID, true, false, &out); err != nil || !strings.Contains(out.String(), "Install complete!") {
+		t.Errorf("installer log = %q, %v", out.String(), err)
+	}
+
+	if err := streamAgentLog(client, infraDetailsStatus.InstancePublicDNS, "missing", false, false, &out); err == nil {
+		t.Errorf("streamAgentLog() of a missing job succeeded")
+	}
+}
+
+func TestInstallProgressInStatus(t *testing.T) {
+	setupLab(t)
+	started := time.Now().Add(-12 * time.Minute)
+	_, outputs := startFakeAgent(t, InfraState{RegistryHealth: "Healthy", ClusterStatus: "Exists", Progress: &InstallProgress{
+		Stage:     "BootstrapComplete",
+		StartedAt: started,
+		UpdatedAt: started.Add(10 * time.Minute),
+		Stages:    []ProgressStage{{Name: "Mirroring", ReachedAt: started}, {Name: "BootstrapComplete", ReachedAt: started.Add(10 * time.Minute)}},
+	}})
+	useFakeTerraform(t, outputs)
+
+	report := buildStatusReport()
+	if report.Agent.Progress == nil || len(report.Agent.Progress.Stages) != 2 {
+		t.Fatalf("agent progress = %+v, want 2 stages", report.Agent.Progress)
+	}
+	if got, want := report.Agent.Progress.summary(started.Add(12*time.Minute)), "bootstrap complete, 12m in"; got != want {
+		t.Errorf("summary = %q, want %q", got, want)
+	}
+
+	failed := InstallProgress{Stage: "InstallFailed", StartedAt: started, UpdatedAt: started.Add(5 * time.Minute), Error: "exit status 1"}
+	if got, want := failed.summary(time.Now()), "install failed after 5m: exit status 1"; got != want {
+		t.Errorf("summary = %q, want %q", got, want)
+	}
+}
+
+func TestRegistryChecksInStatus(t *testing.T) {
+	// This is how the agent reports its checks in /status.
+	reply := `{"RegistryHealth":"Healthy","ClusterStatus":"DontExist","BootstrapStatus":"Ready","RegistryChecks":[
+		{"Name":"tls","Critical":true,"Passed":true,"Message":"certificate for registry valid until 2027-01-01T00:00:00Z","CheckedAt":"2026-10-18T10:00:00Z"},
+		{"Name":"release-repositories","Critical":false,"Passed":false,"Message":"missing openshift/release (not found)","CheckedAt":"2026-10-18T10:00:00Z"}]}`
+	state := &InfraState{}
+	if err := json.Unmarshal([]byte(reply), state); err != nil {
+		t.Fatal(err)
+	}
+	want := []RegistryCheck{
+		{Name: "tls", Critical: true, Passed: true, Message: "certificate for registry valid until 2027-01-01T00:00:00Z", CheckedAt: time.Date(2026, 10, 18, 10, 0, 0, 0, time.UTC)},
+		{Name: "release-repositories", Message: "missing openshift/release (not found)", CheckedAt: time.Date(2026, 10, 18, 10, 0, 0, 0, time.UTC)},
+	}
+	if !reflect.DeepEqual(state.RegistryChecks, want) {
+		t.Errorf("registry checks = %+v, want %+v", state.RegistryChecks, want)
+	}
+
+	setupLab(t)
+	_, outputs := startFakeAgent(t, *state)
+	useFakeTerraform(t, outputs)
+	report := buildStatusReport()
+	if !reflect.DeepEqual(report.Agent.RegistryChecks, want) {
+		t.Errorf("report registry checks = %+v, want %+v", report.Agent.RegistryChecks, want)
+	}
+}
+
+func TestClientCertificateWithoutToken(t *testing.T) {
+	setupLab(t)
+	if _, _, err := createCertificateAuthority(); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(clientKey); err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("client key = %v, %v. want a file readable only by the user", info, err)
+	}
+	agent, outputs := startFakeAgent(t, InfraState{RegistryHealth: "Healthy", ClusterStatus: "DontExist"})
+	delete(outputs, "random_token")
+	useFakeTerraform(t, outputs)
+
+	if err := refreshAgentStatus(); err != nil {
+		t.Fatalf("refreshAgentStatus() error = %v", err)
+	}
+	if want := []string{"ocpd-client"}; !reflect.DeepEqual(agent.clientNames, want) {
+		t.Errorf("client certificates = %v, want %v", agent.clientNames, want)
+	}
+
+	// A lab without the client certificate must still send the token.
+	os.Remove(clientCert)
+	if err := refreshAgentStatus(); err == nil {
+		t.Errorf("refreshAgentStatus() without client certificate and token succeeded")
+	}
+}
+
+func TestMintAndRevokeCredential(t *testing.T) {
+	setupLab(t)
+	agent, outputs := startFakeAgent(t, InfraState{RegistryHealth: "Healthy", ClusterStatus: "Exists"})
+	useFakeTerraform(t, outputs)
+
+	if err := mintCredential("alice", 72*time.Hour); err != nil {
+		t.Fatalf("mintCredential() error = %v", err)
+	}
+	dir := filepath.Join(credentialsDir, "alice")
+	if got := readFile(t, filepath.Join(dir, clientCert)); got != "cert" {
+		t.Errorf("client certificate = %q", got)
+	}
+	if info, err := os.Stat(filepath.Join(dir, clientKey)); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("client key = %v, %v. want a file readable only by the user", info, err)
+	}
+	if err := mintCredential("alice", time.Hour); err == nil {
+		t.Errorf("minting the same name twice succeeded")
+	}
+
+	if err := revokeCredential("c1"); err != nil {
+		t.Fatalf("revokeCredential() error = %v", err)
+	}
+	if agent.credentials[0].RevokedAt == nil || agent.credentials[0].ExpiresAt.Sub(agent.credentials[0].CreatedAt) < 71*time.Hour {
+		t.Errorf("credential = %+v, want revoked and valid for 72h", agent.credentials[0])
+	}
+	if err := revokeCredential("missing"); err == nil {
+		t.Errorf("revoking a missing credential succeeded")
+	}
+}
+
+func TestDestroyForce(t *testing.T) {
+	setupLab(t)
+	fake := useFakeTerraform(t, nil)
+	for _, file := range []string{CAcert, clientCert, clientKey, installStateFile} {
+		writeFile(t, file, "lab")
+	}
+
+	runDestroy(true)
+
+	if want := []string{"destroy"}; !reflect.DeepEqual(fake.calls, want) {
+		t.Errorf("terraform calls = %v, want %v", fake.calls, want)
+	}
+	for _, file := range []string{CAcert, clientCert, clientKey, installStateFile} {
+		if _, err := os.Stat(file); !os.IsNotExist(err) {
+			t.Errorf("%s was not cleaned up", file)
+		}
+	}
+}
+
+// Init and install clean the generated templates only. The lab stays reachable and its install can still be resumed.
+func TestDeleteGeneratedFilesKeepsLabFiles(t *testing.T) {
+	setupLab(t)
+	for _, file := range []string{registryScript, tfvarsFile, clientCert, clientKey, installStateFile} {
+		writeFile(t, file, "lab")
+	}
+
+	deleteGeneratedFiles()
+
+	if _, err := os.Stat(tfvarsFile); !os.IsNotExist(err) {
+		t.Errorf("%s was not cleaned up", tfvarsFile)
+	}
+	for _, file := range []string{clientCert, clientKey, installStateFile} {
+		if _, err := os.Stat(file); err != nil {
+			t.Errorf("%s was removed: %v", file, err)
+		}
+	}
+}
+
+func TestReadInfraDetailsWithoutCluster(t *testing.T) {
+	setupLab(t)
+	useFakeTerraform(t, map[string]string{
+		"region":                  "eu-west-1",
+		"ec2_instance_public_dns": "ec2.example.com",
+		"private_subnet_1_id":     "N/A",
+		"random_token":            "token",
+	})
+
+	if err := readInfraDetails(); err != nil {
+		t.Fatalf("readInfraDetails() error = %v", err)
+	}
+	if infraDetailsStatus.PrivateSubnet1 != "" || infraDetailsStatus.PrivateSubnet2 != "" {
+		t.Errorf("subnets = %q %q, want empty", infraDetailsStatus.PrivateSubnet1, infraDetailsStatus.PrivateSubnet2)
+	}
+	if infraDetailsStatus.InstancePublicDNS != "ec2.example.com" {
+		t.Errorf("public DNS = %q", infraDetailsStatus.InstancePublicDNS)
+	}
+}
+
+func TestReadInventory(t *testing.T) {
+	setupLab(t)
+	writeFile(t, tfstateFile, `{
+  "version": 4,
+  "outputs": {"region": {"value": "eu-west-1", "type": "string"}},
+  "resources": [
+    {"mode": "data", "type": "aws_ami", "name": "rhel", "instances": [{"attributes": {"id": "ami-1"}}]},
+    {"mode": "managed", "type": "aws_vpc_endpoint", "name": "s3", "module": "module.Cluster_Dependencies[0]",
+     "instances": [{"attributes": {"id": "vpce-1", "arn": "arn:aws:ec2:eu-west-1:123:vpc-endpoint/vpce-1"}}]},
+    {"mode": "managed", "type": "aws_instance", "name": "mirror-registry",
+     "instances": [{"attributes": {"id": "i-1", "availability_zone": "eu-west-1a", "tags": {"Name": "mirror-registry"}}}]},
+    {"mode": "managed", "type": "random_string", "name": "token", "instances": [{"attributes": {"id": "secret"}}]}
+  ]
+}`)
+
+	inventory, err := readInventory()
+	if err != nil {
+		t.Fatalf("readInventory() error = %v", err)
+	}
+	want := []InventoryItem{
+		{Type: "aws_instance", Name: "mirror-registry", ID: "i-1", Tags: map[string]string{"Name": "mirror-registry"}, Region: "eu-west-1"},
+		{Type: "random_string", Name: "token", ID: "secret", Region: "eu-west-1"},
+		{Type: "aws_vpc_endpoint", Name: "s3", Module: "module.Cluster_Dependencies[0]", ID: "vpce-1", Region: "eu-west-1"},
+	}
+	if !reflect.DeepEqual(inventory.Items, want) {
+		t.Errorf("inventory = %+v, want %+v", inventory.Items, want)
+	}
+	if !inventory.RegistryExists() || !inventory.ClusterExists() {
+		t.Errorf("registry exists = %v, cluster exists = %v, want both", inventory.RegistryExists(), inventory.ClusterExists())
+	}
+
+	// Without the agent the status still reports what terraform tracks.
+	useFakeTerraform(t, nil)
+	report := buildStatusReport()
+	if report.Agent.Reachable || len(report.Deployment.Resources) != 3 {
+		t.Errorf("status = %+v, want an unreachable agent and 3 resources", report)
+	}
+}
+
+func TestSetClusterFlagOnLegacyTfvars(t *testing.T) {
+	setupLab(t)
+	// The terraform.tfvars of older versions. The "false" in the key path must survive the cluster flag change.
+	writeFile(t, legacyTfvarsFile, `Region = "eu-west-1"
+Availability_Zone_A = "eu-west-1a"
+Availability_Zone_B = "eu-west-1b"
+Availability_Zone_C = "eu-west-1c"
+Public_Key_Path = "/home/false/id_rsa.pub"
+Ami_Id = "ami-1"
+Create_Cluster = false
+`)
+
+	if err := SetClusterFlagTerraform(true); err != nil {
+		t.Fatal(err)
+	}
+
+	vars, err := readTerraformVars()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := newTerraformVars("/home/false/id_rsa.pub", "eu-west-1", "ami-1")
+	want.CreateCluster = true
+	if *vars != *want {
+		t.Errorf("terraform variables = %+v, want %+v", vars, want)
+	}
+	if _, err := os.Stat(legacyTfvarsFile); !os.IsNotExist(err) {
+		t.Errorf("%s was not removed", legacyTfvarsFile)
+	}
+}
+
+func TestResumeInstallAfterFailedPhase(t *testing.T) {
+	setupLab(t)
+	fake := useFakeTerraform(t, nil)
+	fake.errs["apply"] = errors.New("apply failed")
+
+	state := &InstallState{Region: "eu-west-1"}
+	if err := runInstallPhases(state); err == nil {
+		t.Fatal("runInstallPhases() succeeded, want the apply-registry phase to fail")
+	}
+
+	saved, err := readInstallState()
+	if err != nil || saved == nil {
+		t.Fatalf("readInstallState() = %v, %v. want the saved state", saved, err)
+	}
+	if want := []string{"render", "terraform-init"}; !reflect.DeepEqual(saved.CompletedPhases, want) {
+		t.Errorf("completed phases = %v, want %v", saved.CompletedPhases, want)
+	}
+
+	// The CA of the first run must be kept since the rendered bootstrap script already uses it.
+	caBefore := readFile(t, CAcert)
+	delete(fake.errs, "apply")
+	if err := runInstallPhases(saved); err != nil {
+		t.Fatalf("resumed runInstallPhases() error = %v", err)
+	}
+
+	if want := []string{"init", "apply", "apply"}; !reflect.DeepEqual(fake.calls, want) {
+		t.Errorf("terraform calls = %v, want %v", fake.calls, want)
+	}
+	if readFile(t, CAcert) != caBefore {
+		t.Errorf("the CA was created again on resume")
+	}
+	if _, err := os.Stat(installStateFile); !os.IsNotExist(err) {
+		t.Errorf("%s was not removed after the install finished", installStateFile)
+	}
+}
+
+func TestInstallClusterPhases(t *testing.T) {
+	setupLab(t)
+	useShortWaits(t, time.Second)
+	agent, outputs := startFakeAgent(t, InfraState{RegistryHealth: "Healthy", ClusterStatus: "DontExist", BootstrapStatus: "Ready"})
+	fake := useFakeTerraform(t, outputs)
+	UpdateCreateTfFileRegistry("/tmp/id_rsa.pub", "eu-west-1", regions["eu-west-1"])
+
+	// The registry is already applied so only the cluster phases run.
+	state := &InstallState{Region: "eu-west-1", ClusterVersion: "4.16.3", CompletedPhases: []string{"render", "terraform-init", "apply-registry"}}
+	if err := runInstallPhases(state); err != nil {
+		t.Fatalf("runInstallPhases() error = %v", err)
+	}
+
+	if !contains(fake.calls, "apply -target=module.Cluster_Dependencies") {
+		t.Errorf("terraform calls = %v, want the cluster dependencies applied", fake.calls)
+	}
+	if len(agent.installConfig) == 0 {
+		t.Errorf("the agent did not receive the install-config")
+	}
+	if want := []DeployDestroy{{ClusterVersion: "4.16.3", Deploy: "Install"}}; !reflect.DeepEqual(agent.actions, want) {
+		t.Errorf("agent actions = %v, want %v", agent.actions, want)
+	}
+}
+
+func TestWaitForAgentUntilReadyMarker(t *testing.T) {
+	setupLab(t)
+	useShortWaits(t, 200*time.Millisecond)
+	agent, outputs := startFakeAgent(t, InfraState{RegistryHealth: "Healthy", ClusterStatus: "DontExist", BootstrapStatus: "NotReady"})
+	useFakeTerraform(t, outputs)
+
+	if err := waitForAgentPhase(&InstallState{}); err == nil {
+		t.Fatal("waitForAgentPhase() succeeded before the READY marker was present")
+	}
+
+	agent.Lock()
+	agent.status.BootstrapStatus = "Ready"
+	agent.Unlock()
+	if err := waitForAgentPhase(&InstallState{}); err != nil {
+		t.Fatalf("waitForAgentPhase() error = %v", err)
+	}
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func TestClusterTopology(t *testing.T) {
+	setupLab(t)
+	two, five := 2, 5
+	tests := []struct {
+		options                    ClusterOptions
+		wantMasters, wantWorkers   int64
+		wantMasterType, wantWorker string
+	}{
+		{options: ClusterOptions{}, wantMasters: 1, wantWorkers: 0},
+		{options: ClusterOptions{Topology: "compact"}, wantMasters: 3, wantWorkers: 0},
+		{options: ClusterOptions{Topology: "ha"}, wantMasters: 3, wantWorkers: 3},
+		{options: ClusterOptions{Topology: "ha", Masters: &five, Workers: &two, MasterInstanceType: "m6i.2xlarge", WorkerInstanceType: "m6i.xlarge"},
+			wantMasters: 5, wantWorkers: 2, wantMasterType: "m6i.2xlarge", wantWorker: "m6i.xlarge"},
+	}
+	for _, test := range tests {
+		var installConfig InstallConfig
+		if err := yaml.Unmarshal([]byte(populateInstallConfigValues(test.options)), &installConfig); err != nil {
+			t.Fatal(err)
+		}
+		if got := *installConfig.ControlPlane.Replicas; got != test.wantMasters {
+			t.Errorf("%+v: controlPlane.replicas = %d, want %d", test.options, got, test.wantMasters)
+		}
+		if got := *installConfig.workerReplicas(); got != test.wantWorkers {
+			t.Errorf("%+v: compute replicas = %d, want %d", test.options, got, test.wantWorkers)
+		}
+		masterType, workerType := instanceType(installConfig.ControlPlane), instanceType(installConfig.Compute[0])
+		if masterType != test.wantMasterType || workerType != test.wantWorker {
+			t.Errorf("%+v: instance types = %q/%q, want %q/%q", test.options, masterType, workerType, test.wantMasterType, test.wantWorker)
+		}
+	}
+
+	invalid := map[string]ClusterOptions{
+		"4.10.3":  {},
+		"4.14.10": {Topology: "ha", Masters: &five},
+		"4.16.1":  {Topology: "compact", Workers: &two},
+		"4.18.5":  {Topology: "ha", Workers: &two, WorkerInstanceType: "xlarge"},
+		"4.18.6":  {Topology: "sno", WorkerInstanceType: "m6i.xlarge"},
+		"4.15.2":  {Topology: "large"},
+	}
+	for version, options := range invalid {
+		if problems := options.validateTopology(version); len(problems) == 0 {
+			t.Errorf("validateTopology(%s) of %+v found no problem", version, options)
+		}
+	}
+	if problems := (ClusterOptions{Topology: "ha", Masters: &five, Workers: &two}).validateTopology("4.18.5"); len(problems) > 0 {
+		t.Errorf("validateTopology() = %v, want no problem", problems)
+	}
+}
+
+func instanceType(pool MachinePool) string {
+	aws, _ := pool.Platform["aws"].(map[string]interface{})
+	instanceType, _ := aws["type"].(string)
+	return instanceType
+}
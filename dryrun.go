@@ -0,0 +1,289 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	dryRunPlanFile      = "tfplan"
+	dryRunInstallConfig = "install-config.rendered.yaml"
+	knownAfterApply     = "<known after apply>"
+)
+
+// The parts of the "terraform show -json" output we need to list the planned resources.
+type terraformPlan struct {
+	ResourceChanges []struct {
+		Address string `json:"address"`
+		Change  struct {
+			Actions []string `json:"actions"`
+		} `json:"change"`
+	} `json:"resource_changes"`
+}
+
+// Renders every artifact of a new installation into a scratch directory and runs terraform plan instead of apply.
+// Nothing is created on AWS, the files of the current directory are not touched and the scratch directory is removed at the end.
+func dryRunInstall(region string, clusterVersion string, options ClusterOptions) {
+
+	// Check if the credentials are present if not ask for them
+	if _, err := os.Stat(initFileName); os.IsNotExist(err) {
+		fmt.Println("Error: The pull-Secret Path and public-Key Path must be provided. Running init interactive prompt")
+		initialization(initFileName)
+	}
+	amiID, found := regions[region]
+	if !found {
+		fmt.Println("Invalid or unsupported region:", region)
+		return
+	}
+	pullSecretPath, publicKeyPath = readPathsFromFile(initFileName)
+
+	leaveScratchDir := enterScratchDir(options.CustomInstallConfig, false)
+	defer leaveScratchDir()
+
+	// The CA is created inside the scratch directory so the CAcert.pem of an existing lab is not overwritten.
+	CAcertString, CAkeyString, err := createCertificateAuthority()
+	if err != nil {
+		fmt.Printf("Couldn't generate the CA cert and key with error: %v\n", err)
+		return
+	}
+	createPullSecretTemplate(pullSecretPath)
+	updateRegistryScriptFile(pullSecretTemplate, CAcertString, CAkeyString)
+	UpdateCreateTfFileRegistry(publicKeyPath, region, amiID)
+
+	clusterFlag := len(clusterVersion) > 0
+	if clusterFlag {
+		setDryRunClusterFlag()
+		// There is no infrastructure yet so the values terraform provides after apply are shown as placeholders.
+		infraDetailsStatus.AWSRegion = region
+		infraDetailsStatus.PrivateSubnet1 = knownAfterApply
+		infraDetailsStatus.PrivateSubnet2 = knownAfterApply
+		infraDetailsStatus.PrivateSubnet3 = knownAfterApply
+		infraDetailsStatus.PrivateDNS = knownAfterApply
+		renderDryRunInstallConfig(options)
+	}
+
+	if err := terraformExecutor.Init(); err != nil {
+		fmt.Printf("Terraform init failed with: %v\n", err)
+		return
+	}
+	if err := terraformExecutor.Plan("-out=" + dryRunPlanFile); err != nil {
+		fmt.Printf("Terraform plan failed with: %v\n", err)
+		return
+	}
+
+	printDryRunSummary(clusterFlag)
+}
+
+// Renders the cluster part of an existing lab into a scratch directory and plans only the cluster dependencies.
+// The tfstate is copied so the plan is computed against the real infrastructure without changing it.
+func dryRunAddCluster(clusterVersion string, options ClusterOptions) {
+
+	if _, err := os.Stat("./terraform.tfstate"); err != nil {
+		fmt.Println("No terraform.tfstate file detected. There is no registry to add a cluster to")
+		return
+	}
+
+	leaveScratchDir := enterScratchDir(options.CustomInstallConfig, true)
+	defer leaveScratchDir()
+
+	setDryRunClusterFlag()
+	if err := terraformExecutor.Init(); err != nil {
+		fmt.Printf("Terraform init failed with: %v\n", err)
+		return
+	}
+	GetInfraDetails()
+
+	// The private subnets are created along with the cluster dependencies so they do not exist yet.
+	for _, subnet := range []*string{&infraDetailsStatus.PrivateSubnet1, &infraDetailsStatus.PrivateSubnet2, &infraDetailsStatus.PrivateSubnet3} {
+		if len(*subnet) == 0 {
+			*subnet = knownAfterApply
+		}
+	}
+	renderDryRunInstallConfig(options)
+
+	if err := terraformExecutor.Plan("-target=module.Cluster_Dependencies", "-out="+dryRunPlanFile); err != nil {
+		fmt.Printf("Terraform plan failed with: %v\n", err)
+		return
+	}
+
+	printDryRunSummary(true)
+}
+
+// Creates the scratch directory, copies the terraform configuration in it and changes the working directory to it.
+// The returned function changes the working directory back and removes the scratch directory.
+func enterScratchDir(installConfigFlag bool, existingLab bool) func() {
+	workDir, err := os.Getwd()
+	if err != nil {
+		log.Fatalf("Cannot get the current directory: %v", err)
+	}
+
+	scratchDir, err := os.MkdirTemp("", "ocpd-dry-run-")
+	if err != nil {
+		log.Fatalf("Cannot create the dry-run directory: %v", err)
+	}
+	fmt.Printf("Rendering the deployment into %s\n", scratchDir)
+
+	files, err := filepath.Glob("*.tf")
+	if err != nil {
+		log.Fatalf("Cannot list the terraform files: %v", err)
+	}
+	files = append(files, registryScriptTemplate, ".terraform.lock.hcl")
+	if installConfigFlag {
+		files = append(files, "install-config.yaml")
+	}
+	// The init file may hold the base domain and the cluster name.
+	if _, err := os.Stat(initFileName); err == nil {
+		files = append(files, initFileName)
+	}
+	if existingLab {
+		files = append(files, "terraform.tfstate", tfvarsFile, legacyTfvarsFile)
+	}
+
+	for _, file := range files {
+		if err := copyFile(file, filepath.Join(scratchDir, file)); err != nil {
+			// Only one of the tfvars files exists depending on the OCPD version the lab was installed with.
+			if os.IsNotExist(err) && (file == ".terraform.lock.hcl" || file == tfvarsFile || file == legacyTfvarsFile) {
+				continue
+			}
+			log.Fatalf("Cannot copy %s to the dry-run directory: %v", file, err)
+		}
+	}
+	if err := copyDir("cluster_dependencies", filepath.Join(scratchDir, "cluster_dependencies")); err != nil {
+		log.Fatalf("Cannot copy the cluster_dependencies module to the dry-run directory: %v", err)
+	}
+
+	if err := os.Chdir(scratchDir); err != nil {
+		log.Fatalf("Cannot change to the dry-run directory: %v", err)
+	}
+
+	return func() {
+		if err := os.Chdir(workDir); err != nil {
+			fmt.Printf("Cannot change back to %s: %v\n", workDir, err)
+		}
+		if err := os.RemoveAll(scratchDir); err != nil {
+			fmt.Printf("Cannot remove the dry-run directory %s: %v\n", scratchDir, err)
+		}
+	}
+}
+
+// Sets the cluster flag in the rendered tfvars so the plan also shows the cluster dependencies.
+func setDryRunClusterFlag() {
+	if err := SetClusterFlagTerraform(true); err != nil {
+		log.Fatalf("Cannot set the cluster flag in the rendered tfvars: %v", err)
+	}
+}
+
+// Renders the install-config the agent would receive and saves it as YAML for review.
+func renderDryRunInstallConfig(options ClusterOptions) {
+	installConfig := populateInstallConfigValues(options)
+
+	var data map[string]interface{}
+	if err := json.Unmarshal([]byte(installConfig), &data); err != nil {
+		log.Fatalf("Error unmarshaling the rendered install-config: %v", err)
+	}
+	yamlData, err := yaml.Marshal(data)
+	if err != nil {
+		log.Fatalf("Error marshaling the rendered install-config to YAML: %v", err)
+	}
+	if err := os.WriteFile(dryRunInstallConfig, yamlData, 0644); err != nil {
+		log.Fatalf("Cannot write the rendered install-config: %v", err)
+	}
+}
+
+// Prints the rendered files and the resources terraform would create, change or destroy.
+func printDryRunSummary(clusterFlag bool) {
+	fmt.Println("")
+	fmt.Println("==================== Dry run summary ====================")
+
+	tfvars, err := os.ReadFile(tfvarsFile)
+	if err == nil {
+		fmt.Printf("\n--> %s\n%s\n", tfvarsFile, strings.TrimSpace(string(tfvars)))
+	}
+
+	// The bootstrap script holds the pull-secret and the CA key so it is not printed.
+	if _, err := os.Stat(registryScript); err == nil {
+		fmt.Println("\n--> Registry bootstrap script rendered (not printed, it contains the pull-secret and CA key)")
+	}
+
+	if clusterFlag {
+		installConfig, err := os.ReadFile(dryRunInstallConfig)
+		if err == nil {
+			fmt.Printf("\n--> install-config.yaml\n%s\n", strings.TrimSpace(string(installConfig)))
+		}
+	}
+
+	output, err := terraformExecutor.Show(dryRunPlanFile)
+	if err != nil {
+		fmt.Printf("Cannot read the terraform plan: %v\n", err)
+		return
+	}
+	var plan terraformPlan
+	if err := json.Unmarshal(output, &plan); err != nil {
+		fmt.Printf("Cannot parse the terraform plan: %v\n", err)
+		return
+	}
+
+	fmt.Println("\n--> Planned resources")
+	changes := 0
+	for _, resource := range plan.ResourceChanges {
+		actions := strings.Join(resource.Change.Actions, ",")
+		if actions == "no-op" || actions == "read" {
+			continue
+		}
+		fmt.Printf("  %-16s %s\n", actions, resource.Address)
+		changes++
+	}
+	fmt.Printf("\n%d resource changes planned. Nothing was applied.\n", changes)
+}
+
+// Copies a single file keeping its permissions.
+func copyFile(src string, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, info.Mode())
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	_, err = io.Copy(out, in)
+	return err
+}
+
+// Copies a directory tree. The source can be a link to a directory as it is inside an environment.
+func copyDir(src string, dst string) error {
+	src, err := filepath.EvalSymlinks(src)
+	if err != nil {
+		return err
+	}
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if info.IsDir() {
+			return os.MkdirAll(target, info.Mode())
+		}
+		return copyFile(path, target)
+	})
+}
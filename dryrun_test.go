@@ -0,0 +1,111 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// Returns the content of every file under the directory, to check a flow left them untouched.
+func snapshotDir(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	files := map[string]string{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		content, err := os.ReadFile(path)
+		files[path] = string(content)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+// Prepares a lab for a dry run. The scratch directories are created in a temporary directory the test checks is left empty.
+func setupDryRun(t *testing.T) (string, string, *fakeTerraform) {
+	t.Helper()
+	dir := setupLab(t)
+	_, outputs := startFakeAgent(t, InfraState{RegistryHealth: "Healthy", ClusterStatus: "DontExist"})
+	tmpDir := t.TempDir()
+	t.Setenv("TMPDIR", tmpDir)
+	if err := os.Mkdir("cluster_dependencies", 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join("cluster_dependencies", "main.tf"), "# cluster dependencies\n")
+	writeFile(t, "main.tf", "# registry\n")
+
+	fake := useFakeTerraform(t, outputs)
+	fake.plan = []byte(`{"resource_changes":[{"address":"aws_instance.registry","change":{"actions":["create"]}}]}`)
+	return dir, tmpDir, fake
+}
+
+func TestDryRun(t *testing.T) {
+	tests := []struct {
+		name      string
+		existing  bool
+		run       func()
+		initErr   error
+		wantCalls []string
+	}{
+		{
+			name:      "install",
+			run:       func() { dryRunInstall("eu-west-1", "", ClusterOptions{}) },
+			wantCalls: []string{"init", "plan -out=tfplan", "show tfplan"},
+		},
+		{
+			name:      "install with a cluster",
+			run:       func() { dryRunInstall("eu-west-1", "4.14.10", ClusterOptions{}) },
+			wantCalls: []string{"init", "plan -out=tfplan", "show tfplan"},
+		},
+		{
+			name:      "install when terraform init fails",
+			run:       func() { dryRunInstall("eu-west-1", "", ClusterOptions{}) },
+			initErr:   errors.New("registry.terraform.io is unreachable"),
+			wantCalls: []string{"init"},
+		},
+		{
+			name:      "cluster add",
+			existing:  true,
+			run:       func() { dryRunAddCluster("4.14.10", ClusterOptions{}) },
+			wantCalls: []string{"init", "output", "plan -target=module.Cluster_Dependencies -out=tfplan", "show tfplan"},
+		},
+		{
+			name:      "cluster add when terraform init fails",
+			existing:  true,
+			run:       func() { dryRunAddCluster("4.14.10", ClusterOptions{}) },
+			initErr:   errors.New("registry.terraform.io is unreachable"),
+			wantCalls: []string{"init"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir, tmpDir, fake := setupDryRun(t)
+			fake.errs["init"] = test.initErr
+			if test.existing {
+				UpdateCreateTfFileRegistry("/tmp/id_rsa.pub", "eu-west-1", regions["eu-west-1"])
+				writeFile(t, "terraform.tfstate", `{"version": 4, "resources": []}`)
+			}
+			before := snapshotDir(t, dir)
+
+			test.run()
+
+			if !reflect.DeepEqual(fake.calls, test.wantCalls) {
+				t.Errorf("terraform calls = %v, want %v", fake.calls, test.wantCalls)
+			}
+			if after := snapshotDir(t, dir); !reflect.DeepEqual(after, before) {
+				t.Errorf("the dry run changed the lab files: %v, want %v", after, before)
+			}
+			if wd, err := os.Getwd(); err != nil || wd != dir {
+				t.Errorf("working directory = %s, %v, want %s", wd, err, dir)
+			}
+			if left, err := os.ReadDir(tmpDir); err != nil || len(left) > 0 {
+				t.Errorf("the scratch directory was not removed: %v, %v", left, err)
+			}
+		})
+	}
+}
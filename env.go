@@ -0,0 +1,240 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+)
+
+const (
+	environmentsDir     = "environments"
+	environmentMetaFile = "environment.json"
+)
+
+// The files every environment shares with the OCPD cloned directory. They are linked into the environment directory
+// so terraform and the rest of the program find them next to the tfstate, CA and tfvars of the environment.
+var sharedEnvironmentFiles = []string{
+	registryScriptTemplate,
+	"cluster_dependencies",
+	".terraform.lock.hcl",
+	"install-config.yaml",
+	initFileName,
+}
+
+// Details we keep for each environment besides the terraform files.
+type Environment struct {
+	Name      string
+	CreatedAt time.Time
+}
+
+// Registers the --env flag on the flag set of a subcommand that works against a lab.
+func envFlag(fs *flag.FlagSet) *string {
+	return fs.String("env", "", "Name of the environment (lab) to use. The OCPD cloned directory is used if not set")
+}
+
+// Changes the working directory to the directory of the environment so every file the program reads or writes belongs to it.
+// An empty name keeps the OCPD cloned directory as it always was.
+func useEnvironment(name string) {
+	if len(name) == 0 {
+		return
+	}
+
+	dir := environmentPath(name)
+	if _, err := os.Stat(filepath.Join(dir, environmentMetaFile)); err != nil {
+		fmt.Printf("The environment %q does not exist. Create it with 'ocpd env create %s'\n", name, name)
+		os.Exit(1)
+	}
+
+	// Links are refreshed each time so files added to the OCPD directory after the environment was created are also picked up.
+	if err := linkSharedFiles(dir); err != nil {
+		log.Fatalf("Cannot link the shared files into the environment %s: %v", name, err)
+	}
+
+	if err := os.Chdir(dir); err != nil {
+		log.Fatalf("Cannot change to the environment directory %s: %v", dir, err)
+	}
+	// Stderr so the output of the command, like the JSON of status, is not mixed with it.
+	fmt.Fprintf(os.Stderr, "Using environment %q\n", name)
+}
+
+func environmentPath(name string) string {
+	return filepath.Join(environmentsDir, name)
+}
+
+// Links the terraform configuration and the shared files of the OCPD directory into the environment directory.
+func linkSharedFiles(dir string) error {
+	workDir, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+
+	files, err := filepath.Glob("*.tf")
+	if err != nil {
+		return err
+	}
+	files = append(files, sharedEnvironmentFiles...)
+
+	for _, file := range files {
+		link := filepath.Join(dir, file)
+		if _, err := os.Lstat(link); err == nil {
+			continue
+		}
+		// The init file is linked even before it exists so running init from the environment writes the shared one.
+		if _, err := os.Stat(file); os.IsNotExist(err) && file != initFileName {
+			continue
+		}
+		if err := os.Symlink(filepath.Join(workDir, file), link); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Validates the environment name so it can be used safely as a directory name.
+func checkEnvironmentName(name string) error {
+	if !regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_-]*$`).MatchString(name) {
+		return fmt.Errorf("the environment name %q is not valid. Use letters, digits, '-' and '_'", name)
+	}
+	return nil
+}
+
+// Creates a new empty environment.
+func createEnvironment(name string) error {
+	if err := checkEnvironmentName(name); err != nil {
+		return err
+	}
+
+	dir := environmentPath(name)
+	if _, err := os.Stat(dir); err == nil {
+		return fmt.Errorf("the environment %q already exists", name)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(Environment{Name: name, CreatedAt: time.Now()})
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dir, environmentMetaFile), data, 0644); err != nil {
+		return err
+	}
+	return linkSharedFiles(dir)
+}
+
+// Deletes an environment. It is refused while terraform still tracks infrastructure for it so no AWS resources are orphaned.
+func deleteEnvironment(name string) error {
+	if err := checkEnvironmentName(name); err != nil {
+		return err
+	}
+
+	dir := environmentPath(name)
+	if _, err := os.Stat(filepath.Join(dir, environmentMetaFile)); err != nil {
+		return fmt.Errorf("the environment %q does not exist", name)
+	}
+
+	inventory, err := readInventoryFrom(filepath.Join(dir, tfstateFile))
+	if err == nil && len(inventory.Items) > 0 {
+		return fmt.Errorf("the environment %q still has infrastructure provisioned. Destroy it first with 'ocpd destroy --env %s'", name, name)
+	}
+
+	return os.RemoveAll(dir)
+}
+
+// Reads the metadata of every environment.
+func listEnvironments() ([]Environment, error) {
+	metaFiles, err := filepath.Glob(filepath.Join(environmentsDir, "*", environmentMetaFile))
+	if err != nil {
+		return nil, err
+	}
+
+	var environments []Environment
+	for _, metaFile := range metaFiles {
+		data, err := os.ReadFile(metaFile)
+		if err != nil {
+			return nil, err
+		}
+		var env Environment
+		if err := json.Unmarshal(data, &env); err != nil {
+			return nil, fmt.Errorf("cannot parse %s: %v", metaFile, err)
+		}
+		environments = append(environments, env)
+	}
+	return environments, nil
+}
+
+// Prints every environment with the region, the age and the state of its lab as terraform knows it.
+func printEnvironments() {
+	environments, err := listEnvironments()
+	if err != nil {
+		fmt.Printf("Cannot list the environments: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("%-20s %-16s %-10s %s\n", "NAME", "REGION", "AGE", "STATE")
+	printEnvironmentRow("(default)", ".", time.Time{})
+	for _, env := range environments {
+		printEnvironmentRow(env.Name, environmentPath(env.Name), env.CreatedAt)
+	}
+}
+
+func printEnvironmentRow(name string, dir string, createdAt time.Time) {
+	tfstate := filepath.Join(dir, tfstateFile)
+
+	region := readTfstateOutput(tfstate, "region")
+	if len(region) == 0 {
+		region = "-"
+	}
+
+	age := "-"
+	if !createdAt.IsZero() {
+		age = formatAge(time.Since(createdAt))
+	}
+
+	state := "empty"
+	inventory, err := readInventoryFrom(tfstate)
+	if err != nil {
+		// Without a tfstate file nothing was ever applied in this environment.
+		if !os.IsNotExist(err) {
+			state = "unknown"
+		}
+	} else if inventory.ClusterExists() {
+		state = "registry+cluster"
+	} else if inventory.RegistryExists() {
+		state = "registry"
+	}
+
+	fmt.Printf("%-20s %-16s %-10s %s\n", name, region, age, state)
+}
+
+// Reads a string output from a tfstate file without running terraform. Returns an empty string if it is not there.
+func readTfstateOutput(tfstate string, name string) string {
+	data, err := os.ReadFile(tfstate)
+	if err != nil {
+		return ""
+	}
+	var state Tfstate
+	if err := json.Unmarshal(data, &state); err != nil {
+		return ""
+	}
+	value, _ := state.Outputs[name].Value.(string)
+	return value
+}
+
+// Formats a duration the short way (e.g 3d4h, 5h12m, 7m).
+func formatAge(d time.Duration) string {
+	days := int(d.Hours()) / 24
+	hours := int(d.Hours()) % 24
+	minutes := int(d.Minutes()) % 60
+	if days > 0 {
+		return fmt.Sprintf("%dd%dh", days, hours)
+	} else if hours > 0 {
+		return fmt.Sprintf("%dh%dm", hours, minutes)
+	}
+	return fmt.Sprintf("%dm", minutes)
+}
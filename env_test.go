@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Returns what the function printed on the standard output.
+func captureStdout(t *testing.T, f func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	output := make(chan string)
+	go func() {
+		data, _ := io.ReadAll(r)
+		output <- string(data)
+	}()
+	f()
+	w.Close()
+	return <-output
+}
+
+// A tfstate with the registry instance of a lab in the given region.
+func registryTfstate(region string) string {
+	return `{
+  "version": 4,
+  "outputs": {"region": {"value": "` + region + `", "type": "string"}},
+  "resources": [
+    {"mode": "managed", "type": "aws_instance", "name": "mirror-registry",
+     "instances": [{"attributes": {"id": "i-1", "availability_zone": "` + region + `a", "tags": {"Name": "mirror-registry"}}}]}
+  ]
+}`
+}
+
+func TestEnvironments(t *testing.T) {
+	dir := setupLab(t)
+	writeFile(t, "install-config.yaml", "baseDomain: example.com\n")
+	shared := snapshotDir(t, dir)
+
+	for _, name := range []string{"lab1", "lab2"} {
+		if err := createEnvironment(name); err != nil {
+			t.Fatalf("createEnvironment(%s) error = %v", name, err)
+		}
+	}
+	if err := createEnvironment("lab1"); err == nil {
+		t.Error("creating lab1 twice succeeded")
+	}
+	if err := createEnvironment("../lab3"); err == nil {
+		t.Error("creating an environment outside of the environments directory succeeded")
+	}
+	environments, err := listEnvironments()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(environments) != 2 || environments[0].Name != "lab1" || environments[1].Name != "lab2" {
+		t.Errorf("environments = %+v, want lab1 and lab2", environments)
+	}
+
+	// The shared files are links to those of the OCPD directory, so init run inside an environment writes the shared file.
+	for _, file := range []string{initFileName, "install-config.yaml", registryScriptTemplate} {
+		target, err := os.Readlink(filepath.Join(environmentPath("lab1"), file))
+		if err != nil || target != filepath.Join(dir, file) {
+			t.Errorf("%s links to %q, %v, want %s", file, target, err, filepath.Join(dir, file))
+		}
+	}
+
+	// Each environment keeps its own tfstate and install state.
+	for _, lab := range []struct{ name, region string }{{"lab1", "eu-west-1"}, {"lab2", "us-east-1"}} {
+		if err := os.Chdir(dir); err != nil {
+			t.Fatal(err)
+		}
+		useEnvironment(lab.name)
+		writeFile(t, tfstateFile, registryTfstate(lab.region))
+		if err := (&InstallState{Region: lab.region}).save(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	for _, lab := range []struct{ name, region string }{{"lab1", "eu-west-1"}, {"lab2", "us-east-1"}} {
+		if region := readTfstateOutput(filepath.Join(environmentPath(lab.name), tfstateFile), "region"); region != lab.region {
+			t.Errorf("region in the tfstate of %s = %q, want %s", lab.name, region, lab.region)
+		}
+		if state := readFile(t, filepath.Join(environmentPath(lab.name), installStateFile)); !strings.Contains(state, lab.region) {
+			t.Errorf("install state of %s = %s, want the region %s", lab.name, state, lab.region)
+		}
+	}
+	for _, file := range []string{tfstateFile, installStateFile} {
+		if _, err := os.Stat(file); !os.IsNotExist(err) {
+			t.Errorf("%s was written in the OCPD directory", file)
+		}
+	}
+
+	// An environment is kept while terraform tracks infrastructure for it.
+	if err := deleteEnvironment("lab1"); err == nil {
+		t.Error("deleting lab1 with a registry succeeded")
+	}
+	writeFile(t, filepath.Join(environmentPath("lab2"), tfstateFile), `{"version": 4, "resources": []}`)
+	if err := deleteEnvironment("lab2"); err != nil {
+		t.Fatalf("deleteEnvironment(lab2) error = %v", err)
+	}
+	if _, err := os.Stat(environmentPath("lab2")); !os.IsNotExist(err) {
+		t.Errorf("the directory of lab2 was not removed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(environmentPath("lab1"), tfstateFile)); err != nil {
+		t.Errorf("deleting lab2 removed the tfstate of lab1: %v", err)
+	}
+	for file, content := range shared {
+		if got, err := os.ReadFile(file); err != nil || string(got) != content {
+			t.Errorf("deleting lab2 changed the shared file %s: %q, %v", file, got, err)
+		}
+	}
+}
+
+// The status of an environment printed as JSON is a single JSON document, even with the environment notice.
+func TestStatusJSONOfEnvironment(t *testing.T) {
+	setupLab(t)
+	useFakeTerraform(t, nil)
+	if err := createEnvironment("lab1"); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(environmentPath("lab1"), tfstateFile), registryTfstate("eu-west-1"))
+
+	output := captureStdout(t, func() { statusCommand([]string{"--output", "json", "--env", "lab1"}) })
+
+	var report StatusReport
+	if err := json.Unmarshal([]byte(output), &report); err != nil {
+		t.Fatalf("status output is not JSON: %v\n%s", err, output)
+	}
+	if !report.Deployment.RegistryExists || len(report.Deployment.Resources) != 1 {
+		t.Errorf("deployment = %+v, want the registry of lab1", report.Deployment)
+	}
+}
@@ -0,0 +1,462 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// The overlay the --custom-install-config flag applies over the default install-config.
+const customInstallConfigFile = "./install-config.yaml"
+
+// The base domain of the clusters unless --base-domain or the BaseDomain of the init file says otherwise.
+const defaultBaseDomain = "emea.aws.cee.support"
+
+// The options of the cluster to install. They are saved in the install state so --resume uses them again.
+// An empty base domain or cluster name is taken from the init file, then from the defaults. An empty topology is sno.
+// Masters and Workers are nil unless given on the command line, the topology decides then.
+type ClusterOptions struct {
+	SDN                 bool
+	CustomInstallConfig bool
+	BaseDomain          string `json:",omitempty"`
+	ClusterName         string `json:",omitempty"`
+	Topology            string `json:",omitempty"`
+	Masters             *int   `json:",omitempty"`
+	Workers             *int   `json:",omitempty"`
+	MasterInstanceType  string `json:",omitempty"`
+	WorkerInstanceType  string `json:",omitempty"`
+}
+
+// A cluster topology preset: how many control plane nodes and workers the cluster has by default and the first 4.x minor
+// that can install it on AWS. Only ha can be scaled with --masters and --workers.
+type topology struct {
+	name     string
+	summary  string
+	masters  int
+	workers  int
+	scalable bool
+	minMinor int
+}
+
+var topologies = []topology{
+	{name: "sno", summary: "single node: one control plane node that also runs the workloads", masters: 1, workers: 0, minMinor: 11},
+	{name: "compact", summary: "three control plane nodes that also run the workloads", masters: 3, workers: 0},
+	{name: "ha", summary: "three control plane nodes and three workers by default", masters: 3, workers: 3, scalable: true},
+}
+
+// A control plane of 4 or 5 nodes can be installed from 4.18. An ha cluster needs at least 2 workers for the ingress.
+const (
+	largeControlPlaneMinMinor = 18
+	minHAWorkers              = 2
+)
+
+// Returns the topology preset by name, sno if the name is empty. It returns nil for an unknown name.
+func findTopology(name string) *topology {
+	if len(name) == 0 {
+		name = "sno"
+	}
+	for i := range topologies {
+		if topologies[i].name == name {
+			return &topologies[i]
+		}
+	}
+	return nil
+}
+
+func topologyNames() []string {
+	names := make([]string, 0, len(topologies))
+	for _, t := range topologies {
+		names = append(names, t.name)
+	}
+	return names
+}
+
+// The control plane and worker replicas of the options: those given on the command line, otherwise the topology preset.
+func (o ClusterOptions) replicas() (masters int, workers int) {
+	preset := findTopology(o.Topology)
+	masters, workers = preset.masters, preset.workers
+	if o.Masters != nil {
+		masters = *o.Masters
+	}
+	if o.Workers != nil {
+		workers = *o.Workers
+	}
+	return masters, workers
+}
+
+// Checks the topology, the replicas and the instance types against each other and the cluster version. Every problem is
+// returned, the message names the flag to fix.
+func (o ClusterOptions) validateTopology(clusterVersion string) []string {
+	preset := findTopology(o.Topology)
+	if preset == nil {
+		return []string{fmt.Sprintf("Unknown topology %q. One of: %s", o.Topology, strings.Join(topologyNames(), ", "))}
+	}
+	minor, _ := minorVersion(clusterVersion)
+	masters, workers := o.replicas()
+
+	var problems []string
+	if minor < preset.minMinor {
+		problems = append(problems, fmt.Sprintf("The %s topology can be installed on AWS from 4.%d, not %s", preset.name, preset.minMinor, clusterVersion))
+	}
+	if !preset.scalable && (o.Masters != nil || o.Workers != nil) {
+		problems = append(problems, fmt.Sprintf("The %s topology has %d control plane nodes and %d workers. Use --topology ha to choose --masters and --workers", preset.name, preset.masters, preset.workers))
+	} else if preset.scalable {
+		if masters < 3 || masters > 5 {
+			problems = append(problems, fmt.Sprintf("--masters must be 3, 4 or 5 for the %s topology, not %d", preset.name, masters))
+		} else if masters > 3 && minor < largeControlPlaneMinMinor {
+			problems = append(problems, fmt.Sprintf("--masters %d needs 4.%d or later, not %s", masters, largeControlPlaneMinMinor, clusterVersion))
+		}
+		if workers < minHAWorkers {
+			problems = append(problems, fmt.Sprintf("--workers must be at least %d for the %s topology, not %d. Use --topology compact for a cluster without workers", minHAWorkers, preset.name, workers))
+		}
+	}
+	if len(o.WorkerInstanceType) > 0 && workers == 0 {
+		problems = append(problems, fmt.Sprintf("--worker-instance-type cannot be used with the %s topology, it has no workers", preset.name))
+	}
+	for flag, instanceType := range map[string]string{"--master-instance-type": o.MasterInstanceType, "--worker-instance-type": o.WorkerInstanceType} {
+		if len(instanceType) > 0 && !instanceTypePattern.MatchString(instanceType) {
+			problems = append(problems, fmt.Sprintf("%s %q is not an EC2 instance type (e.g m6i.xlarge)", flag, instanceType))
+		}
+	}
+	return problems
+}
+
+// Returns the minor of a version like 4.14.10.
+func minorVersion(version string) (int, bool) {
+	parts := strings.Split(version, ".")
+	if len(parts) < 2 || parts[0] != "4" {
+		return 0, false
+	}
+	minor, err := strconv.Atoi(parts[1])
+	return minor, err == nil
+}
+
+// The install-config the CLI sends to the agent. The fields ocpd fills in or that are commonly changed are typed, any
+// other field of an overlay is kept in Extra so everything supported for AWS IPI still reaches openshift-install.
+type InstallConfig struct {
+	APIVersion          string                 `yaml:"apiVersion"`
+	BaseDomain          string                 `yaml:"baseDomain"`
+	CredentialsMode     string                 `yaml:"credentialsMode,omitempty"`
+	Compute             []MachinePool          `yaml:"compute"`
+	ControlPlane        MachinePool            `yaml:"controlPlane"`
+	Metadata            InstallConfigMetadata  `yaml:"metadata"`
+	Networking          Networking             `yaml:"networking"`
+	Platform            Platform               `yaml:"platform"`
+	Publish             string                 `yaml:"publish,omitempty"`
+	ImageContentSources []ImageContentSource   `yaml:"imageContentSources"`
+	Extra               map[string]interface{} `yaml:",inline"`
+}
+
+type InstallConfigMetadata struct {
+	Name  string                 `yaml:"name"`
+	Extra map[string]interface{} `yaml:",inline"`
+}
+
+// The machine platform holds the instance type, e.g {"aws": {"type": "m6i.xlarge"}}.
+type MachinePool struct {
+	Architecture   string                 `yaml:"architecture,omitempty"`
+	Hyperthreading string                 `yaml:"hyperthreading,omitempty"`
+	Name           string                 `yaml:"name"`
+	Platform       map[string]interface{} `yaml:"platform"`
+	Replicas       *int64                 `yaml:"replicas,omitempty"`
+	Extra          map[string]interface{} `yaml:",inline"`
+}
+
+type Networking struct {
+	ClusterNetwork []ClusterNetworkEntry  `yaml:"clusterNetwork"`
+	MachineNetwork []MachineNetworkEntry  `yaml:"machineNetwork"`
+	NetworkType    string                 `yaml:"networkType"`
+	ServiceNetwork []string               `yaml:"serviceNetwork"`
+	Extra          map[string]interface{} `yaml:",inline"`
+}
+
+type ClusterNetworkEntry struct {
+	CIDR       string `yaml:"cidr"`
+	HostPrefix int    `yaml:"hostPrefix"`
+}
+
+type MachineNetworkEntry struct {
+	CIDR string `yaml:"cidr"`
+}
+
+type Platform struct {
+	AWS   *AWSPlatform           `yaml:"aws,omitempty"`
+	Extra map[string]interface{} `yaml:",inline"`
+}
+
+type AWSPlatform struct {
+	Region  string                 `yaml:"region"`
+	Subnets []string               `yaml:"subnets"`
+	Extra   map[string]interface{} `yaml:",inline"`
+}
+
+type ImageContentSource struct {
+	Mirrors []string `yaml:"mirrors"`
+	Source  string   `yaml:"source"`
+}
+
+// The release repositories of quay.io mirrored in the registry and the repository of the registry each one is mirrored to.
+var mirroredReleaseRepositories = []struct{ source, mirror string }{
+	{"quay.io/openshift-release-dev/ocp-v4.0-art-dev", "openshift/release"},
+	{"quay.io/openshift-release-dev/ocp-release", "openshift/release-images"},
+}
+
+// The install-config ocpd installs when no overlay is given: a cluster of the topology of the options in the private
+// subnets of the lab.
+func defaultInstallConfig(options ClusterOptions, name string, baseDomain string) *InstallConfig {
+	networkType := "OVNKubernetes"
+	if options.SDN {
+		networkType = "OpenShiftSDN"
+	}
+	masterReplicas, workerReplicas := options.replicas()
+	workers, masters := int64(workerReplicas), int64(masterReplicas)
+	return &InstallConfig{
+		APIVersion:      "v1",
+		BaseDomain:      baseDomain,
+		CredentialsMode: "Passthrough",
+		Compute:         []MachinePool{{Architecture: "amd64", Hyperthreading: "Enabled", Name: "worker", Platform: map[string]interface{}{}, Replicas: &workers}},
+		ControlPlane:    MachinePool{Architecture: "amd64", Hyperthreading: "Enabled", Name: "master", Platform: map[string]interface{}{}, Replicas: &masters},
+		Metadata:        InstallConfigMetadata{Name: name},
+		Networking: Networking{
+			ClusterNetwork: []ClusterNetworkEntry{{CIDR: "10.128.0.0/14", HostPrefix: 23}},
+			MachineNetwork: []MachineNetworkEntry{{CIDR: "10.0.0.0/23"}},
+			NetworkType:    networkType,
+			ServiceNetwork: []string{"172.30.0.0/16"},
+		},
+		Platform: Platform{AWS: &AWSPlatform{}},
+		Publish:  "Internal",
+	}
+}
+
+// Applies the options given on the command line. They are set after the overlay so they win over it.
+func (ic *InstallConfig) setClusterOptions(options ClusterOptions) {
+	if len(options.ClusterName) > 0 {
+		ic.Metadata.Name = options.ClusterName
+	}
+	if len(options.BaseDomain) > 0 {
+		ic.BaseDomain = options.BaseDomain
+	}
+
+	masters, workers := options.replicas()
+	if options.Masters != nil {
+		replicas := int64(masters)
+		ic.ControlPlane.Replicas = &replicas
+	}
+	if len(options.MasterInstanceType) > 0 {
+		ic.ControlPlane.setInstanceType(options.MasterInstanceType)
+	}
+	for i := range ic.Compute {
+		if ic.Compute[i].Name != "worker" {
+			continue
+		}
+		if options.Workers != nil {
+			replicas := int64(workers)
+			ic.Compute[i].Replicas = &replicas
+		}
+		if len(options.WorkerInstanceType) > 0 {
+			ic.Compute[i].setInstanceType(options.WorkerInstanceType)
+		}
+	}
+}
+
+// Returns the replicas of the worker compute pool, nil if there is none.
+func (ic *InstallConfig) workerReplicas() *int64 {
+	for _, pool := range ic.Compute {
+		if pool.Name == "worker" {
+			return pool.Replicas
+		}
+	}
+	return nil
+}
+
+// The installer uses 3 replicas when none are set.
+func replicasString(replicas *int64) string {
+	if replicas == nil {
+		return "3"
+	}
+	return strconv.FormatInt(*replicas, 10)
+}
+
+// Sets platform.aws.type of the machine pool, the other machine platform fields are kept.
+func (pool *MachinePool) setInstanceType(instanceType string) {
+	if pool.Platform == nil {
+		pool.Platform = map[string]interface{}{}
+	}
+	aws, ok := pool.Platform["aws"].(map[string]interface{})
+	if !ok {
+		aws = map[string]interface{}{}
+		pool.Platform["aws"] = aws
+	}
+	aws["type"] = instanceType
+}
+
+// Fills in the fields that depend on the lab. They are set after the overlay so an overlay cannot break them.
+func (ic *InstallConfig) setInfraDetails(infra *InfraDetails) {
+	if ic.Platform.AWS == nil {
+		ic.Platform.AWS = &AWSPlatform{}
+	}
+	ic.Platform.AWS.Region = infra.AWSRegion
+	ic.Platform.AWS.Subnets = []string{infra.PrivateSubnet1, infra.PrivateSubnet2, infra.PrivateSubnet3}
+
+	// Image content sources of the overlay for other repositories are kept.
+	for _, repository := range mirroredReleaseRepositories {
+		mirrors := []string{infra.PrivateDNS + ":8443/" + repository.mirror}
+		found := false
+		for i := range ic.ImageContentSources {
+			if ic.ImageContentSources[i].Source == repository.source {
+				ic.ImageContentSources[i].Mirrors = mirrors
+				found = true
+			}
+		}
+		if !found {
+			ic.ImageContentSources = append(ic.ImageContentSources, ImageContentSource{Mirrors: mirrors, Source: repository.source})
+		}
+	}
+}
+
+// Applies a YAML overlay to the install-config. Mappings are merged like a JSON merge patch (RFC 7386): a field of the
+// overlay replaces the same field and null removes it. Lists of named entries such as compute are merged by name like a
+// strategic merge patch, so an overlay can change the replicas of the workers only. Any other list is replaced.
+func applyInstallConfigOverlay(ic *InstallConfig, overlay []byte) (*InstallConfig, error) {
+	var patch map[string]interface{}
+	if err := yaml.Unmarshal(overlay, &patch); err != nil {
+		return nil, fmt.Errorf("the overlay is not a YAML mapping: %v", err)
+	}
+
+	baseYAML, err := yaml.Marshal(ic)
+	if err != nil {
+		return nil, err
+	}
+	var base map[string]interface{}
+	if err := yaml.Unmarshal(baseYAML, &base); err != nil {
+		return nil, err
+	}
+
+	mergedYAML, err := yaml.Marshal(mergeOverlay(base, patch))
+	if err != nil {
+		return nil, err
+	}
+	merged := &InstallConfig{}
+	if err := yaml.Unmarshal(mergedYAML, merged); err != nil {
+		return nil, fmt.Errorf("the overlay does not fit the install-config: %v", err)
+	}
+	return merged, nil
+}
+
+func mergeOverlay(base interface{}, overlay interface{}) interface{} {
+	switch overlay := overlay.(type) {
+	case map[string]interface{}:
+		baseMap, ok := base.(map[string]interface{})
+		if !ok {
+			baseMap = map[string]interface{}{}
+		}
+		merged := map[string]interface{}{}
+		for key, value := range baseMap {
+			merged[key] = value
+		}
+		for key, value := range overlay {
+			if value == nil {
+				delete(merged, key)
+			} else {
+				merged[key] = mergeOverlay(merged[key], value)
+			}
+		}
+		return merged
+	case []interface{}:
+		baseList, ok := base.([]interface{})
+		if !ok || !namedEntries(baseList) || !namedEntries(overlay) {
+			return overlay
+		}
+		merged := append([]interface{}{}, baseList...)
+		for _, entry := range overlay {
+			name := entry.(map[string]interface{})["name"]
+			found := false
+			for i := range merged {
+				if merged[i].(map[string]interface{})["name"] == name {
+					merged[i] = mergeOverlay(merged[i], entry)
+					found = true
+				}
+			}
+			if !found {
+				merged = append(merged, entry)
+			}
+		}
+		return merged
+	default:
+		return overlay
+	}
+}
+
+// Whether every entry of the list is a mapping with a name.
+func namedEntries(list []interface{}) bool {
+	for _, entry := range list {
+		mapping, ok := entry.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		if _, ok := mapping["name"]; !ok {
+			return false
+		}
+	}
+	return len(list) > 0
+}
+
+// The overlays written for the template of older releases have placeholders ocpd used to replace. The infra fields are
+// set by setInfraDetails, only the name and the network type are left.
+func (ic *InstallConfig) expandLegacyPlaceholders(suffix string, networkType string) {
+	ic.Metadata.Name = strings.ReplaceAll(ic.Metadata.Name, "$RANDOM_VALUE", suffix)
+	if ic.Networking.NetworkType == "$CNI" {
+		ic.Networking.NetworkType = networkType
+	}
+}
+
+// The install-config as JSON, the format the agent receives.
+func (ic *InstallConfig) toJSON() (string, error) {
+	yamlData, err := yaml.Marshal(ic)
+	if err != nil {
+		return "", err
+	}
+	var data map[string]interface{}
+	if err := yaml.Unmarshal(yamlData, &data); err != nil {
+		return "", err
+	}
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		return "", err
+	}
+	return string(jsonData), nil
+}
+
+// Returns the cluster name and the base domain of the default install-config: the flags first, then the ClusterName and
+// BaseDomain of the init file, then disconnected-<suffix> and the default base domain.
+func clusterNaming(options ClusterOptions, suffix string) (string, string) {
+	clusterName, baseDomain := options.ClusterName, options.BaseDomain
+	settings := readInitSettings(initFileName)
+	if len(clusterName) == 0 {
+		clusterName = settings["ClusterName"]
+	}
+	if len(clusterName) == 0 {
+		clusterName = "disconnected-" + suffix
+	}
+	if len(baseDomain) == 0 {
+		baseDomain = settings["BaseDomain"]
+	}
+	if len(baseDomain) == 0 {
+		baseDomain = defaultBaseDomain
+	}
+	return clusterName, baseDomain
+}
+
+// A random number in [10000, 99999] so every cluster gets its own name.
+func randomClusterSuffix() (string, error) {
+	randomBigInt, err := rand.Int(rand.Reader, big.NewInt(int64(99999-10000+1)))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d", randomBigInt.Int64()+10000), nil
+}
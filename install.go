@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+const installStateFile = "install-state.json"
+
+// The progress of an installation. It is saved after every phase so an interrupted install can continue with --resume
+// from the first phase that did not complete, using the options it was started with.
+type InstallState struct {
+	Region         string
+	ClusterVersion string
+	ClusterOptions
+	CompletedPhases []string
+	UpdatedAt       time.Time
+}
+
+// A named step of the installation. Cluster phases run only when a cluster version was requested.
+type installPhase struct {
+	name    string
+	cluster bool
+	run     func(state *InstallState) error
+}
+
+var installPhases []installPhase
+
+func init() {
+	installPhases = []installPhase{
+		{name: "render", run: renderPhase},
+		{name: "terraform-init", run: terraformInitPhase},
+		{name: "apply-registry", run: applyRegistryPhase},
+		{name: "wait-for-agent", cluster: true, run: waitForAgentPhase},
+		{name: "apply-cluster-dependencies", cluster: true, run: applyClusterDependenciesPhase},
+		{name: "send-install-config", cluster: true, run: sendInstallConfigPhase},
+		{name: "send-action", cluster: true, run: sendActionPhase},
+		{name: "wait-for-cluster", cluster: true, run: waitForClusterPhase},
+	}
+}
+
+// Reads the state of an unfinished install. Returns nil if there is none.
+func readInstallState() (*InstallState, error) {
+	data, err := os.ReadFile(installStateFile)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	state := &InstallState{}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("cannot parse %s: %v", installStateFile, err)
+	}
+	return state, nil
+}
+
+func (s *InstallState) save() error {
+	s.UpdatedAt = time.Now()
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(installStateFile, data, 0644)
+}
+
+func (s *InstallState) completed(phase string) bool {
+	for _, completed := range s.CompletedPhases {
+		if completed == phase {
+			return true
+		}
+	}
+	return false
+}
+
+// Runs every phase that has not completed yet. The state is saved after each phase and removed once the install is finished.
+func runInstallPhases(state *InstallState) error {
+	clusterFlag := len(state.ClusterVersion) > 0
+
+	for _, phase := range installPhases {
+		if phase.cluster && !clusterFlag {
+			continue
+		}
+		if state.completed(phase.name) {
+			fmt.Printf("==> Phase %s already completed. Skipping\n", phase.name)
+			continue
+		}
+
+		fmt.Printf("==> Phase %s\n", phase.name)
+		if err := phase.run(state); err != nil {
+			return fmt.Errorf("phase %s failed: %v", phase.name, err)
+		}
+
+		state.CompletedPhases = append(state.CompletedPhases, phase.name)
+		if err := state.save(); err != nil {
+			return fmt.Errorf("cannot save the install state: %v", err)
+		}
+	}
+
+	if !clusterFlag {
+		fmt.Println("No cluster version specified. Deploying only the registy.")
+	}
+	return os.Remove(installStateFile)
+}
+
+// Creates the CA, the pull-secret template, the registry bootstrap script and the terraform variables.
+func renderPhase(state *InstallState) error {
+	pullSecretPath, publicKeyPath = readPathsFromFile(initFileName)
+	CAcertString, CAkeyString, err := createCertificateAuthority()
+	if err != nil {
+		return fmt.Errorf("couldn't generate the CA cert and key with error: %v", err)
+	}
+
+	// Create new PullSecretTemplate
+	createPullSecretTemplate(pullSecretPath)
+	// Update bash script with Pull Secret and Certs for the agent
+	updateRegistryScriptFile(pullSecretTemplate, CAcertString, CAkeyString)
+	// Replace the appropriate values in registry template terraform file
+	UpdateCreateTfFileRegistry(publicKeyPath, state.Region, regions[state.Region])
+	return nil
+}
+
+func terraformInitPhase(state *InstallState) error {
+	return terraformExecutor.Init()
+}
+
+func applyRegistryPhase(state *InstallState) error {
+	return terraformExecutor.Apply()
+}
+
+// Waits for the agent on the registry host to reply with a healthy and initialized registry.
+func waitForAgentPhase(state *InstallState) error {
+	return waitFor("the registry and the agent", readinessTimeout, agentRegistryReady)
+}
+
+func applyClusterDependenciesPhase(state *InstallState) error {
+	return applyTerraformConfig()
+}
+
+func sendInstallConfigPhase(state *InstallState) error {
+	GetInfraDetails()
+	if !ClientGetStatus(infraDetailsStatus.InstancePublicDNS) {
+		return fmt.Errorf("the agent or the registry is not healthy")
+	}
+	if agentStatus.ClusterStatus == "Exists" {
+		return fmt.Errorf("there is already a cluster deployed")
+	}
+
+	installConfig := populateInstallConfigValues(state.ClusterOptions)
+	return sendInstallConfigToAgent(installConfig, state.ClusterVersion, infraDetailsStatus.InstancePublicDNS)
+}
+
+func sendActionPhase(state *InstallState) error {
+	// We need to let the mirror-registry to initialize properly before we run the installation script.
+	if err := waitFor("the registry to finish initializing", readinessTimeout, agentRegistryReady); err != nil {
+		return err
+	}
+
+	GetInfraDetails()
+	populateActionAndVersion(true, state.ClusterVersion)
+	_, err := sendActionAndVersionToAgent(infraDetailsStatus.InstancePublicDNS)
+	return err
+}
+
+// Waits until the agent reports the cluster installation in the install dir. Mirroring the release comes first so it can take a while.
+func waitForClusterPhase(state *InstallState) error {
+	err := waitFor("the cluster installation to start", readinessTimeout, func() (bool, error) {
+		if err := refreshAgentStatus(); err != nil {
+			return false, err
+		}
+		return agentStatus.ClusterStatus == "Exists", nil
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Println("The cluster installation is running on the registry host. Check it with 'ocpd status'")
+	return nil
+}
+
+// Asks the agent for the status without exiting when the agent is not up yet.
+func refreshAgentStatus() error {
+	if err := readInfraDetails(); err != nil {
+		return err
+	}
+	client, err := createHTTPClientWithCACert(CAcert)
+	if err != nil {
+		return err
+	}
+	return fetchAgentStatus(client, infraDetailsStatus.InstancePublicDNS)
+}
+
+// The registry is ready once it is healthy and the bootstrap script has written the READY marker.
+// Agents that do not report the marker are considered ready as soon as the registry is healthy.
+func agentRegistryReady() (bool, error) {
+	if err := refreshAgentStatus(); err != nil {
+		return false, err
+	}
+	if agentStatus.RegistryHealth != "Healthy" {
+		return false, fmt.Errorf("the registry is %s", agentStatus.RegistryHealth)
+	}
+	if agentStatus.BootstrapStatus == "NotReady" {
+		return false, fmt.Errorf("the registry host is still initializing")
+	}
+	return true, nil
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// An install or destroy of the cluster the agent runs in the background. Phase is one of Running, Succeeded, Failed or
+// Cancelled.
+type AgentJob struct {
+	ID                string
+	Action            string
+	ClusterVersion    string
+	Phase             string
+	StartedAt         time.Time
+	EndedAt           *time.Time `json:",omitempty"`
+	CancelRequestedAt *time.Time `json:",omitempty"`
+	ExitStatus        *int       `json:",omitempty"`
+	Error             string     `json:",omitempty"`
+}
+
+func (j *AgentJob) finished() bool {
+	return j.Phase == "Succeeded" || j.Phase == "Failed" || j.Phase == "Cancelled"
+}
+
+// Requests every job the agent ran since it started, the oldest first.
+func fetchAgentJobs(client *http.Client, url string) ([]AgentJob, error) {
+	var jobs []AgentJob
+	if err := fetchAgentJSON(client, url, "/v1/jobs", &jobs); err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
+
+// Requests the job with the given id.
+func fetchAgentJob(client *http.Client, url string, id string) (*AgentJob, error) {
+	job := &AgentJob{}
+	if err := fetchAgentJSON(client, url, "/v1/jobs/"+id, job); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+func fetchAgentJSON(client *http.Client, url string, path string, value interface{}) error {
+	resp, err := agentRequest(client, "GET", url, path, nil)
+	if err != nil {
+		return fmt.Errorf("error making GET request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return &agentResponseError{StatusCode: resp.StatusCode}
+	}
+	if err := json.NewDecoder(resp.Body).Decode(value); err != nil {
+		return fmt.Errorf("error unmarshaling JSON: %v", err)
+	}
+	return nil
+}
+
+// Waits until the job finishes. Every change of its phase is printed. A failed job is returned as an error.
+func followAgentJob(client *http.Client, url string, id string) error {
+	var job *AgentJob
+	phase := ""
+	err := waitFor("job "+id+" to finish", readinessTimeout, func() (bool, error) {
+		var err error
+		job, err = fetchAgentJob(client, url, id)
+		if err != nil {
+			return false, err
+		}
+		if job.Phase != phase {
+			phase = job.Phase
+			fmt.Printf("Job %s is %s\n", job.ID, job.Phase)
+		}
+		return job.finished(), nil
+	})
+	if err != nil {
+		return err
+	}
+	if job.Phase == "Failed" {
+		if job.ExitStatus == nil {
+			return fmt.Errorf("job %s failed: %s", job.ID, job.Error)
+		}
+		return fmt.Errorf("job %s failed with exit status %d: %s", job.ID, *job.ExitStatus, job.Error)
+	} else if job.Phase == "Cancelled" {
+		return fmt.Errorf("job %s was cancelled", job.ID)
+	}
+	return nil
+}
+
+// Asks the agent to cancel the running job and waits until its scripts stopped. It returns the cancelled job, or nil if
+// no job is running.
+func cancelAgentJob(client *http.Client, url string) (*AgentJob, error) {
+	jobs, err := fetchAgentJobs(client, url)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get the jobs from the agent: %v", err)
+	}
+	var running *AgentJob
+	for i := range jobs {
+		if !jobs[i].finished() {
+			running = &jobs[i]
+		}
+	}
+	if running == nil {
+		return nil, nil
+	}
+
+	resp, err := agentRequest(client, "POST", url, "/v1/jobs/"+running.ID+"/cancel", nil)
+	if err != nil {
+		return nil, fmt.Errorf("error sending the cancel request: %v", err)
+	}
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusAccepted:
+		fmt.Printf("Cancelling job %s (%s). Waiting for its scripts to stop\n", running.ID, strings.ToLower(running.Action))
+	case http.StatusConflict:
+		// The job ended between listing and cancelling.
+	case http.StatusNotFound:
+		return nil, fmt.Errorf("the agent cannot cancel jobs. Update the agent image of the registry host")
+	default:
+		return nil, &agentResponseError{StatusCode: resp.StatusCode}
+	}
+
+	var job *AgentJob
+	err = waitFor("job "+running.ID+" to stop", readinessTimeout, func() (bool, error) {
+		var err error
+		job, err = fetchAgentJob(client, url, running.ID)
+		if err != nil {
+			return false, err
+		}
+		return job.finished(), nil
+	})
+	return job, err
+}
+
+// Cancels the cluster install or destroy the agent is running.
+func runCancelCluster() {
+	GetInfraDetails()
+	client, err := createHTTPClientWithCACert(CAcert)
+	if err != nil {
+		fmt.Printf("Error creating HTTP client: %v\n", err)
+		os.Exit(2)
+	}
+
+	job, err := cancelAgentJob(client, infraDetailsStatus.InstancePublicDNS)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(2)
+	}
+	if job == nil {
+		fmt.Println("There is no cluster install or destroy running.")
+		return
+	}
+
+	fmt.Printf("Job %s is %s\n", job.ID, job.Phase)
+	if job.Action == "Install" && job.Phase == "Cancelled" {
+		fmt.Println("Run 'ocpd cluster destroy' to remove what the install created so far")
+	}
+}
+
+// Prints a job or, without an id, every job of the agent. With follow it waits until the job finishes.
+func runJobs(id string, follow bool) {
+	GetInfraDetails()
+	client, err := createHTTPClientWithCACert(CAcert)
+	if err != nil {
+		fmt.Printf("Error creating HTTP client: %v\n", err)
+		os.Exit(2)
+	}
+	url := infraDetailsStatus.InstancePublicDNS
+
+	if len(id) == 0 {
+		jobs, err := fetchAgentJobs(client, url)
+		if err != nil {
+			fmt.Printf("Cannot get the jobs from the agent: %v\n", err)
+			os.Exit(2)
+		}
+		printAgentJobs(jobs)
+		return
+	}
+
+	if follow {
+		if err := followAgentJob(client, url, id); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+	job, err := fetchAgentJob(client, url, id)
+	if err != nil {
+		fmt.Printf("Cannot get the job %s from the agent: %v\n", id, err)
+		os.Exit(2)
+	}
+	printAgentJobs([]AgentJob{*job})
+	if len(job.Error) > 0 {
+		fmt.Printf("Error: %s\n", job.Error)
+	}
+}
+
+func printAgentJobs(jobs []AgentJob) {
+	if len(jobs) == 0 {
+		fmt.Println("The agent has not run any job")
+		return
+	}
+
+	fmt.Printf("%-18s %-8s %-10s %-10s %-26s %-10s %s\n", "ID", "ACTION", "VERSION", "PHASE", "STARTED", "DURATION", "EXIT")
+	for _, job := range jobs {
+		duration := formatAge(time.Since(job.StartedAt))
+		if job.EndedAt != nil {
+			duration = formatAge(job.EndedAt.Sub(job.StartedAt))
+		}
+		exitStatus := "-"
+		if job.ExitStatus != nil {
+			exitStatus = fmt.Sprint(*job.ExitStatus)
+		}
+		version := job.ClusterVersion
+		if job.Action == "Destroy" || len(version) == 0 {
+			version = "-"
+		}
+		fmt.Printf("%-18s %-8s %-10s %-10s %-26s %-10s %s\n", job.ID, strings.ToLower(job.Action), version, job.Phase, job.StartedAt.Format(time.RFC3339), duration, exitStatus)
+	}
+}
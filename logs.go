@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+)
+
+// Prints the log of a job of the agent. Without an id the latest job is used. With installer the .openshift_install.log
+// of the registry host is printed instead of the output of the job. With follow it keeps printing until the job finishes.
+func runLogs(id string, installer bool, follow bool) {
+	GetInfraDetails()
+	client, err := createHTTPClientWithCACert(CAcert)
+	if err != nil {
+		fmt.Printf("Error creating HTTP client: %v\n", err)
+		os.Exit(2)
+	}
+	host := infraDetailsStatus.InstancePublicDNS
+
+	if len(id) == 0 {
+		jobs, err := fetchAgentJobs(client, host)
+		if err != nil {
+			fmt.Printf("Cannot get the jobs from the agent: %v\n", err)
+			os.Exit(2)
+		}
+		if len(jobs) == 0 {
+			fmt.Println("The agent has not run any job")
+			return
+		}
+		id = jobs[len(jobs)-1].ID
+		fmt.Fprintf(os.Stderr, "Showing the log of job %s (%s)\n", id, jobs[len(jobs)-1].Action)
+	}
+
+	if err := streamAgentLog(client, host, id, installer, follow, os.Stdout); err != nil {
+		fmt.Printf("Cannot get the log of job %s: %v\n", id, err)
+		os.Exit(2)
+	}
+}
+
+// Copies the log of the job to out as the agent sends it.
+func streamAgentLog(client *http.Client, host string, id string, installer bool, follow bool, out io.Writer) error {
+	query := url.Values{}
+	if installer {
+		query.Set("source", "installer")
+	}
+	if follow {
+		query.Set("follow", "true")
+	}
+
+	path := "/v1/jobs/" + url.PathEscape(id) + "/logs"
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+	resp, err := agentRequest(client, "GET", host, path, nil)
+	if err != nil {
+		return fmt.Errorf("error making GET request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return &agentResponseError{StatusCode: resp.StatusCode}
+	}
+	_, err = io.Copy(out, resp.Body)
+	return err
+}
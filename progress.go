@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// How far the cluster installation got as reported by the agent. Stage is the last stage reached.
+// The agent sends the field names capitalized, which decode into these tags too.
+type InstallProgress struct {
+	Stage     string          `json:"stage"`
+	StartedAt time.Time       `json:"startedAt"`
+	UpdatedAt time.Time       `json:"updatedAt"`
+	Stages    []ProgressStage `json:"stages"`
+	Error     string          `json:"error,omitempty"`
+}
+
+// A stage of the installation and when the agent saw it reached.
+type ProgressStage struct {
+	Name      string    `json:"name"`
+	ReachedAt time.Time `json:"reachedAt"`
+}
+
+// The stages of the installation as they are shown to the user.
+var progressStageNames = map[string]string{
+	"Mirroring":         "mirroring the release",
+	"Manifests":         "creating manifests",
+	"Bootstrap":         "bootstrapping",
+	"APIUp":             "API up",
+	"BootstrapComplete": "bootstrap complete",
+	"ClusterOperators":  "cluster operators settling",
+	"InstallComplete":   "install complete",
+	"InstallFailed":     "install failed",
+}
+
+// Describes the progress in a few words, e.g "bootstrap complete, 12m in".
+func (p *InstallProgress) summary(now time.Time) string {
+	name, ok := progressStageNames[p.Stage]
+	if !ok {
+		name = p.Stage
+	}
+
+	switch p.Stage {
+	case "InstallComplete":
+		return fmt.Sprintf("%s, took %s", name, formatAge(p.UpdatedAt.Sub(p.StartedAt)))
+	case "InstallFailed":
+		return fmt.Sprintf("%s after %s: %s", name, formatAge(p.UpdatedAt.Sub(p.StartedAt)), p.Error)
+	}
+	return fmt.Sprintf("%s, %s in", name, formatAge(now.Sub(p.StartedAt)))
+}
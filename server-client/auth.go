@@ -0,0 +1,415 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/subtle"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The scopes of a credential. A viewer can read the status, the jobs and their logs. An operator can also send the
+// install-config, run jobs and manage the viewer credentials.
+const (
+	scopeViewer   = "viewer"
+	scopeOperator = "operator"
+)
+
+// How long a minted viewer credential is valid if the request does not say.
+const defaultCredentialValidity = 30 * 24 * time.Hour
+
+// A viewer credential minted by the agent. The ID is the serial number of its client certificate.
+type Credential struct {
+	ID        string
+	Name      string
+	Scope     string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	RevokedAt *time.Time `json:",omitempty"`
+}
+
+// The reply to a mint request. The key is sent only once and the agent does not keep it.
+type MintedCredential struct {
+	Credential
+	Certificate   string
+	Key           string
+	CACertificate string
+}
+
+// The request to mint a viewer credential. ValidFor is a duration like 720h.
+type MintRequest struct {
+	Name     string
+	ValidFor string
+}
+
+// Who sent a request, as verified from the client certificate and the token.
+type identity struct {
+	name   string
+	serial string
+	scope  string
+}
+
+var (
+	credentials      []Credential
+	credentialsMutex sync.Mutex
+	auditMutex       sync.Mutex
+)
+
+// Loads the credentials minted before the agent started so revoked ones stay revoked.
+func loadCredentials() error {
+	credentialsMutex.Lock()
+	defer credentialsMutex.Unlock()
+
+	data, err := os.ReadFile(config.CredentialsFile)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, &credentials)
+}
+
+// Must be called with the credentials mutex held.
+func saveCredentials() error {
+	data, err := json.MarshalIndent(credentials, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(config.CredentialsFile, data, 0600)
+}
+
+func credentialRevoked(serial string) bool {
+	credentialsMutex.Lock()
+	defer credentialsMutex.Unlock()
+
+	for _, credential := range credentials {
+		if credential.ID == serial {
+			return credential.RevokedAt != nil
+		}
+	}
+	return false
+}
+
+// Works out who sent the request. The TLS handshake already verified the client certificate against the CA.
+// Certificates minted as viewer credentials carry the viewer scope. The certificate of the CLI that installed the lab and
+// anyone presenting the agent token are operators. A token that does not match, or any token when none is configured, is
+// refused.
+func identify(r *http.Request) (*identity, error) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return nil, fmt.Errorf("no verified client certificate")
+	}
+	certificate := r.TLS.VerifiedChains[0][0]
+	who := &identity{
+		name:   certificate.Subject.CommonName,
+		serial: certificate.SerialNumber.Text(16),
+		scope:  scopeOperator,
+	}
+	for _, unit := range certificate.Subject.OrganizationalUnit {
+		if unit == scopeViewer {
+			who.scope = scopeViewer
+		}
+	}
+	if credentialRevoked(who.serial) {
+		return who, fmt.Errorf("the credential %s is revoked", who.serial)
+	}
+
+	authHeader := r.Header.Get("X-Auth-Token")
+	if len(authHeader) > 0 {
+		if len(authToken) == 0 {
+			return who, fmt.Errorf("no agent token is configured")
+		}
+		if subtle.ConstantTimeCompare([]byte(authHeader), []byte(authToken)) != 1 {
+			return who, fmt.Errorf("wrong token")
+		}
+		who.scope = scopeOperator
+	}
+	return who, nil
+}
+
+// This function is a security authentication mechanism. Reading (GET) needs the viewer scope and everything else the
+// operator scope. We return 403 otherwise. Every request needing the operator scope is recorded in the audit log.
+// Neither the token nor the certificates are ever printed.
+func withAuthorization(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		scope := scopeOperator
+		if r.Method == http.MethodGet {
+			scope = scopeViewer
+		}
+		authorize(w, r, scope, next)
+	}
+}
+
+// Same as withAuthorization but every method needs the operator scope, so every request is audited. Routes that change
+// the lab whatever the method use it.
+func withOperatorAuthorization(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		authorize(w, r, scopeOperator, next)
+	}
+}
+
+func authorize(w http.ResponseWriter, r *http.Request, scope string, next http.HandlerFunc) {
+	who, err := identify(r)
+	if err == nil && scope == scopeOperator && who.scope != scopeOperator {
+		err = fmt.Errorf("the %s scope is required", scopeOperator)
+	}
+
+	// What is audited depends on the scope the route needs, not on the method: a GET to an operator route is audited too.
+	recorder := &statusRecorder{ResponseWriter: w, statusCode: http.StatusOK}
+	if scope == scopeOperator {
+		defer func() { audit(r, who, recorder.statusCode, err) }()
+		w = recorder
+	}
+
+	if err != nil {
+		fmt.Printf("withAuthorization: %s %s refused: %v\n", r.Method, r.URL.Path, err)
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	next.ServeHTTP(w, r)
+}
+
+// Keeps the status code of the response for the audit log and the metrics.
+type statusRecorder struct {
+	http.ResponseWriter
+	statusCode int
+}
+
+func (s *statusRecorder) WriteHeader(statusCode int) {
+	s.statusCode = statusCode
+	s.ResponseWriter.WriteHeader(statusCode)
+}
+
+// The logs are streamed so the recorder has to pass the flushes on.
+func (s *statusRecorder) Flush() {
+	if flusher, ok := s.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// An entry of the audit log.
+type auditEntry struct {
+	Time       time.Time
+	Identity   string
+	Serial     string `json:",omitempty"`
+	Scope      string `json:",omitempty"`
+	Method     string
+	Path       string
+	StatusCode int
+	RemoteAddr string
+	Error      string `json:",omitempty"`
+}
+
+// Appends the request to the audit log as a JSON line.
+func audit(r *http.Request, who *identity, statusCode int, authErr error) {
+	entry := auditEntry{
+		Time:       time.Now(),
+		Identity:   "unknown",
+		Method:     r.Method,
+		Path:       r.URL.Path,
+		StatusCode: statusCode,
+		RemoteAddr: r.RemoteAddr,
+	}
+	if who != nil {
+		entry.Identity, entry.Serial, entry.Scope = who.name, who.serial, who.scope
+	}
+	if authErr != nil {
+		entry.Error = authErr.Error()
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		fmt.Printf("Cannot marshal the audit entry: %v\n", err)
+		return
+	}
+
+	auditMutex.Lock()
+	defer auditMutex.Unlock()
+	file, err := os.OpenFile(config.AuditLogFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		fmt.Printf("Cannot open the audit log: %v\n", err)
+		return
+	}
+	defer file.Close()
+	file.Write(append(data, '\n'))
+}
+
+// ======================================================================================
+// These are the HTTP handlers for requests comming on path /v1/credentials and /v1/credentials/{id}
+// ======================================================================================
+
+func credentialsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		credentialsMutex.Lock()
+		list := append([]Credential{}, credentials...)
+		credentialsMutex.Unlock()
+		writeJSON(w, http.StatusOK, list)
+	case http.MethodPost:
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "Unable to read request body", http.StatusBadRequest)
+			return
+		}
+		defer r.Body.Close()
+
+		var request MintRequest
+		if err := json.Unmarshal(body, &request); err != nil {
+			http.Error(w, "Invalid JSON data", http.StatusBadRequest)
+			return
+		}
+		minted, err := mintViewerCredential(request)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, http.StatusCreated, minted)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func credentialHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		w.Header().Set("Allow", "DELETE")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	credential, err := revokeCredential(strings.TrimPrefix(r.URL.Path, "/v1/credentials/"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, credential)
+}
+
+// Issues a client certificate with the viewer scope from the CA of the lab.
+func mintViewerCredential(request MintRequest) (*MintedCredential, error) {
+	if len(strings.TrimSpace(request.Name)) == 0 {
+		return nil, fmt.Errorf("a name is required for the credential")
+	}
+	validFor := defaultCredentialValidity
+	if len(request.ValidFor) > 0 {
+		var err error
+		if validFor, err = time.ParseDuration(request.ValidFor); err != nil || validFor <= 0 {
+			return nil, fmt.Errorf("invalid ValidFor %q", request.ValidFor)
+		}
+	}
+
+	caCert, caKey, caPEM, err := loadCA()
+	if err != nil {
+		return nil, err
+	}
+
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serialNumber,
+		Subject: pkix.Name{
+			CommonName:         request.Name,
+			OrganizationalUnit: []string{scopeViewer},
+		},
+		NotBefore:   now,
+		NotAfter:    now.Add(validFor),
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	certBytes, err := x509.CreateCertificate(rand.Reader, template, caCert, &privateKey.PublicKey, caKey)
+	if err != nil {
+		return nil, err
+	}
+	keyBytes, err := x509.MarshalECPrivateKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	credential := Credential{
+		ID:        serialNumber.Text(16),
+		Name:      request.Name,
+		Scope:     scopeViewer,
+		CreatedAt: now,
+		ExpiresAt: template.NotAfter,
+	}
+	credentialsMutex.Lock()
+	credentials = append(credentials, credential)
+	err = saveCredentials()
+	credentialsMutex.Unlock()
+	if err != nil {
+		return nil, fmt.Errorf("cannot save the credentials: %v", err)
+	}
+
+	return &MintedCredential{
+		Credential:    credential,
+		Certificate:   string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certBytes})),
+		Key:           string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyBytes})),
+		CACertificate: caPEM,
+	}, nil
+}
+
+// Marks the credential as revoked. Requests with its certificate are refused from now on.
+func revokeCredential(id string) (*Credential, error) {
+	credentialsMutex.Lock()
+	defer credentialsMutex.Unlock()
+
+	for i := range credentials {
+		if credentials[i].ID != id {
+			continue
+		}
+		if credentials[i].RevokedAt == nil {
+			now := time.Now()
+			credentials[i].RevokedAt = &now
+			if err := saveCredentials(); err != nil {
+				return nil, fmt.Errorf("cannot save the credentials: %v", err)
+			}
+		}
+		credential := credentials[i]
+		return &credential, nil
+	}
+	return nil, fmt.Errorf("credential %s not found", id)
+}
+
+// Reads the CA the bootstrap script saved on the registry host.
+func loadCA() (*x509.Certificate, *ecdsa.PrivateKey, string, error) {
+	certPEM, err := os.ReadFile(config.CACertFile)
+	if err != nil {
+		return nil, nil, "", fmt.Errorf("cannot read the CA certificate: %v", err)
+	}
+	keyPEM, err := os.ReadFile(config.CAKeyFile)
+	if err != nil {
+		return nil, nil, "", fmt.Errorf("cannot read the CA key: %v", err)
+	}
+
+	certBlock, _ := pem.Decode(certPEM)
+	keyBlock, _ := pem.Decode(keyPEM)
+	if certBlock == nil || keyBlock == nil {
+		return nil, nil, "", fmt.Errorf("the CA certificate or key is not PEM encoded")
+	}
+	caCert, err := x509.ParseCertificate(certBlock.Bytes)
+	if err != nil {
+		return nil, nil, "", fmt.Errorf("cannot parse the CA certificate: %v", err)
+	}
+	caKey, err := x509.ParseECPrivateKey(keyBlock.Bytes)
+	if err != nil {
+		return nil, nil, "", fmt.Errorf("cannot parse the CA key: %v", err)
+	}
+	return caCert, caKey, string(certPEM), nil
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A request as it reaches the handlers after the TLS handshake verified a client certificate of the given unit.
+// An empty unit is the certificate of the CLI.
+func authenticatedRequest(method string, path string, unit string, serial int64) *http.Request {
+	r := httptest.NewRequest(method, path, strings.NewReader(`{"Deploy":"Destroy"}`))
+	certificate := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "test"},
+	}
+	if unit != "" {
+		certificate.Subject.OrganizationalUnit = []string{unit}
+	}
+	r.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{certificate}}}
+	return r
+}
+
+func readAuditLog(t *testing.T) []auditEntry {
+	t.Helper()
+	data, err := os.ReadFile(config.AuditLogFile)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	var entries []auditEntry
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var entry auditEntry
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func TestAuthorization(t *testing.T) {
+	revokedAt := time.Now()
+	tests := []struct {
+		name        string
+		operator    bool
+		handler     http.HandlerFunc
+		method      string
+		unit        string
+		serial      int64
+		token       string
+		wantCode    int
+		wantAudited bool
+	}{
+		{name: "a viewer can read", method: http.MethodGet, unit: scopeViewer, wantCode: http.StatusOK},
+		{name: "a viewer cannot write", method: http.MethodPost, unit: scopeViewer, wantCode: http.StatusForbidden, wantAudited: true},
+		{name: "an operator can write", method: http.MethodPost, wantCode: http.StatusOK, wantAudited: true},
+		{name: "an operator read is not audited", method: http.MethodGet, wantCode: http.StatusOK},
+		{name: "the token makes a viewer an operator", method: http.MethodPost, unit: scopeViewer, token: "secret", wantCode: http.StatusOK, wantAudited: true},
+		{name: "a wrong token is refused", method: http.MethodGet, token: "wrong", wantCode: http.StatusForbidden},
+		{name: "a revoked credential is refused", method: http.MethodGet, unit: scopeViewer, serial: 0xdead, wantCode: http.StatusForbidden},
+		{name: "an operator route refuses a viewer GET", operator: true, method: http.MethodGet, unit: scopeViewer, wantCode: http.StatusForbidden, wantAudited: true},
+		{name: "an operator route audits an operator GET", operator: true, method: http.MethodGet, wantCode: http.StatusOK, wantAudited: true},
+		{name: "a viewer GET on /action cannot destroy", operator: true, handler: deployDestroyHandler, method: http.MethodGet, unit: scopeViewer, wantCode: http.StatusForbidden, wantAudited: true},
+		{name: "an operator GET on /action is not an action", operator: true, handler: deployDestroyHandler, method: http.MethodGet, wantCode: http.StatusMethodNotAllowed, wantAudited: true},
+		{name: "an operator GET on /data is not an install-config", operator: true, handler: installConfigHandler, method: http.MethodGet, wantCode: http.StatusMethodNotAllowed, wantAudited: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			useTestConfig(t)
+			credentials = []Credential{{ID: big.NewInt(0xdead).Text(16), Scope: scopeViewer, RevokedAt: &revokedAt}}
+			authToken = "secret"
+			t.Cleanup(func() { credentials, authToken = nil, "" })
+
+			handler := test.handler
+			if handler == nil {
+				handler = func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("ok\n")) }
+			}
+			wrapped := withAuthorization(handler)
+			if test.operator {
+				wrapped = withOperatorAuthorization(handler)
+			}
+			serial := test.serial
+			if serial == 0 {
+				serial = 1
+			}
+			r := authenticatedRequest(test.method, "/action", test.unit, serial)
+			if test.token != "" {
+				r.Header.Set("X-Auth-Token", test.token)
+			}
+			w := httptest.NewRecorder()
+			wrapped(w, r)
+
+			if w.Code != test.wantCode {
+				t.Errorf("status code = %d, want %d", w.Code, test.wantCode)
+			}
+			entries := readAuditLog(t)
+			if audited := len(entries) > 0; audited != test.wantAudited {
+				t.Fatalf("audited = %v, want %v", audited, test.wantAudited)
+			}
+			if test.wantAudited && entries[0].StatusCode != test.wantCode {
+				t.Errorf("audited status code = %d, want %d", entries[0].StatusCode, test.wantCode)
+			}
+		})
+	}
+}
+
+func TestTokenWithoutTokenFile(t *testing.T) {
+	c := useTestConfig(t)
+	token, err := getAuthTokenFromFile(c.TokenFile)
+	if err != nil || token != "" {
+		t.Fatalf("getAuthTokenFromFile() of a missing file = %q, %v, want no token", token, err)
+	}
+	authToken = token
+
+	r := authenticatedRequest(http.MethodGet, "/status", "", 1)
+	r.Header.Set("X-Auth-Token", "anything")
+	w := httptest.NewRecorder()
+	withAuthorization(statusHandler)(w, r)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("status code = %d, want %d", w.Code, http.StatusForbidden)
+	}
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	neturl "net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// The agent reads its configuration from this file unless -config or OCPD_AGENT_CONFIG says otherwise.
+// The home of ec2-user is mounted in the container as /ec2-user so the file can be changed without a new image.
+const defaultConfigFile = "/ec2-user/agent-config.yaml"
+
+// The paths, the port and the registry URL the agent uses. Every field can be set in the config file and overridden by an
+// environment variable, see fields. The defaults are the layout of the registry host created by ocpd.
+type Config struct {
+	ListenAddress     string `yaml:"listenAddress"`
+	RegistryURL       string `yaml:"registryURL"`
+	InstallDir        string `yaml:"installDir"`
+	ReadyMarker       string `yaml:"readyMarker"`
+	TokenFile         string `yaml:"tokenFile"`
+	ServerCertFile    string `yaml:"serverCertFile"`
+	ServerKeyFile     string `yaml:"serverKeyFile"`
+	CACertFile        string `yaml:"caCertFile"`
+	CAKeyFile         string `yaml:"caKeyFile"`
+	CredentialsFile   string `yaml:"credentialsFile"`
+	MonitoringLogFile string `yaml:"monitoringLogFile"`
+	AuditLogFile      string `yaml:"auditLogFile"`
+	JobLogDir         string `yaml:"jobLogDir"`
+	ScriptTemplate    string `yaml:"scriptTemplate"`
+	Script            string `yaml:"script"`
+	StateFile         string `yaml:"stateFile"`
+	BinDir            string `yaml:"binDir"`
+
+	// The registry checks, see registry-checks.go. The hostname defaults to the hostname of the host since the agent shares
+	// its network and the Quay certificate is issued for it.
+	RegistryHostname       string `yaml:"registryHostname"`
+	RegistryRootCA         string `yaml:"registryRootCA"`
+	RegistryStoragePath    string `yaml:"registryStoragePath"`
+	RegistryAuthFile       string `yaml:"registryAuthFile"`
+	ReleaseRepositories    string `yaml:"releaseRepositories"`
+	DisabledRegistryChecks string `yaml:"disabledRegistryChecks"`
+
+	// The install-config is checked against the lab, see install-config.go. The region defaults to the region of the
+	// instance read from the instance metadata.
+	Region              string `yaml:"region"`
+	InstanceMetadataURL string `yaml:"instanceMetadataURL"`
+	AWSCredentialsFile  string `yaml:"awsCredentialsFile"`
+}
+
+var config = defaultConfig()
+
+func defaultConfig() *Config {
+	return &Config{
+		ListenAddress:     ":8090",
+		RegistryURL:       "https://localhost:8443",
+		InstallDir:        "/ec2-user/cluster",
+		ReadyMarker:       "/ec2-user/READY",
+		TokenFile:         "/ec2-user/agent-token",
+		ServerCertFile:    "/ec2-user/certs/server.crt",
+		ServerKeyFile:     "/ec2-user/certs/server.key",
+		CACertFile:        "/ec2-user/certs/CAcert.pem",
+		CAKeyFile:         "/ec2-user/certs/CAkey.pem",
+		CredentialsFile:   "/ec2-user/certs/credentials.json",
+		MonitoringLogFile: "/app/monitoring.log",
+		AuditLogFile:      "/app/audit.log",
+		JobLogDir:         "/app/jobs",
+		ScriptTemplate:    "/app/cluster-installation-script.sh.template",
+		Script:            "/app/cluster-installation-script.sh",
+		StateFile:         "/ec2-user/agent-state.json",
+		BinDir:            "/ec2-user/bin",
+
+		RegistryRootCA:      "/ec2-user/registry-stuff/quay-rootCA/rootCA.pem",
+		RegistryStoragePath: "/ec2-user/registry-stuff",
+		RegistryAuthFile:    "/ec2-user/.docker/config.json",
+		ReleaseRepositories: "openshift/release,openshift/release-images",
+
+		InstanceMetadataURL: "http://169.254.169.254",
+		AWSCredentialsFile:  "/ec2-user/.aws/credentials",
+	}
+}
+
+// A setting of the config file and the environment variable that overrides it. An optional setting can be empty.
+type configField struct {
+	key      string
+	env      string
+	value    *string
+	optional bool
+}
+
+// The settings in the order they are listed in the config file.
+func (c *Config) fields() []configField {
+	return []configField{
+		{"listenAddress", "OCPD_AGENT_LISTEN_ADDRESS", &c.ListenAddress, false},
+		{"registryURL", "OCPD_AGENT_REGISTRY_URL", &c.RegistryURL, false},
+		{"installDir", "OCPD_AGENT_INSTALL_DIR", &c.InstallDir, false},
+		{"readyMarker", "OCPD_AGENT_READY_MARKER", &c.ReadyMarker, false},
+		{"tokenFile", "OCPD_AGENT_TOKEN_FILE", &c.TokenFile, true},
+		{"serverCertFile", "OCPD_AGENT_SERVER_CERT_FILE", &c.ServerCertFile, false},
+		{"serverKeyFile", "OCPD_AGENT_SERVER_KEY_FILE", &c.ServerKeyFile, false},
+		{"caCertFile", "OCPD_AGENT_CA_CERT_FILE", &c.CACertFile, false},
+		{"caKeyFile", "OCPD_AGENT_CA_KEY_FILE", &c.CAKeyFile, false},
+		{"credentialsFile", "OCPD_AGENT_CREDENTIALS_FILE", &c.CredentialsFile, false},
+		{"monitoringLogFile", "OCPD_AGENT_MONITORING_LOG_FILE", &c.MonitoringLogFile, false},
+		{"auditLogFile", "OCPD_AGENT_AUDIT_LOG_FILE", &c.AuditLogFile, false},
+		{"jobLogDir", "OCPD_AGENT_JOB_LOG_DIR", &c.JobLogDir, false},
+		{"scriptTemplate", "OCPD_AGENT_SCRIPT_TEMPLATE", &c.ScriptTemplate, false},
+		{"script", "OCPD_AGENT_SCRIPT", &c.Script, false},
+		{"stateFile", "OCPD_AGENT_STATE_FILE", &c.StateFile, false},
+		{"binDir", "OCPD_AGENT_BIN_DIR", &c.BinDir, false},
+		{"registryHostname", "OCPD_AGENT_REGISTRY_HOSTNAME", &c.RegistryHostname, true},
+		{"registryRootCA", "OCPD_AGENT_REGISTRY_ROOT_CA", &c.RegistryRootCA, false},
+		{"registryStoragePath", "OCPD_AGENT_REGISTRY_STORAGE_PATH", &c.RegistryStoragePath, false},
+		{"registryAuthFile", "OCPD_AGENT_REGISTRY_AUTH_FILE", &c.RegistryAuthFile, false},
+		{"releaseRepositories", "OCPD_AGENT_RELEASE_REPOSITORIES", &c.ReleaseRepositories, true},
+		{"disabledRegistryChecks", "OCPD_AGENT_DISABLED_REGISTRY_CHECKS", &c.DisabledRegistryChecks, true},
+		{"region", "OCPD_AGENT_REGION", &c.Region, true},
+		{"instanceMetadataURL", "OCPD_AGENT_INSTANCE_METADATA_URL", &c.InstanceMetadataURL, false},
+		{"awsCredentialsFile", "OCPD_AGENT_AWS_CREDENTIALS_FILE", &c.AWSCredentialsFile, false},
+	}
+}
+
+// The log openshift-install writes in the installation directory.
+func (c *Config) installerLog() string {
+	return filepath.Join(c.InstallDir, ".openshift_install.log")
+}
+
+// The hostname the Quay certificate is issued for.
+func (c *Config) registryHostname() (string, error) {
+	if c.RegistryHostname != "" {
+		return c.RegistryHostname, nil
+	}
+	return os.Hostname()
+}
+
+// Splits a comma separated setting like releaseRepositories.
+func splitList(value string) []string {
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// Loads the config file, applies the environment overrides and validates the result. A missing file is an error only
+// when it was asked for explicitly, otherwise the defaults are used.
+func loadConfig(args []string) (*Config, error) {
+	flags := flag.NewFlagSet("agent", flag.ContinueOnError)
+	path := flags.String("config", os.Getenv("OCPD_AGENT_CONFIG"), "Path of the agent config file (default "+defaultConfigFile+")")
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+
+	explicit := *path != ""
+	if !explicit {
+		*path = defaultConfigFile
+	}
+
+	c := defaultConfig()
+	data, err := os.ReadFile(*path)
+	if os.IsNotExist(err) && !explicit {
+		fmt.Printf("No config file at %s, using the defaults\n", *path)
+	} else if err != nil {
+		return nil, fmt.Errorf("error reading the config file: %v", err)
+	} else {
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		// An empty file keeps the defaults.
+		if err := decoder.Decode(c); err != nil && err != io.EOF {
+			return nil, fmt.Errorf("error parsing the config file %s: %v", *path, err)
+		}
+		fmt.Printf("Using the config file %s\n", *path)
+	}
+
+	for _, field := range c.fields() {
+		if value, ok := os.LookupEnv(field.env); ok {
+			*field.value = value
+		}
+	}
+
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Checks the configuration before the agent starts so a wrong path or port is reported at once and not when a job
+// needs it. Every problem found is reported.
+func (c *Config) validate() error {
+	var problems []string
+	for _, field := range c.fields() {
+		if !field.optional && strings.TrimSpace(*field.value) == "" {
+			problems = append(problems, fmt.Sprintf("%s (%s) is empty", field.key, field.env))
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid agent configuration:\n  %s", strings.Join(problems, "\n  "))
+	}
+
+	if _, port, err := net.SplitHostPort(c.ListenAddress); err != nil || port == "" {
+		problems = append(problems, fmt.Sprintf("listenAddress %q is not a host:port address", c.ListenAddress))
+	}
+	if registry, err := neturl.Parse(c.RegistryURL); err != nil || (registry.Scheme != "https" && registry.Scheme != "http") || registry.Host == "" {
+		problems = append(problems, fmt.Sprintf("registryURL %q is not an http(s) URL", c.RegistryURL))
+	}
+	if metadata, err := neturl.Parse(c.InstanceMetadataURL); err != nil || metadata.Scheme != "http" || metadata.Host == "" {
+		problems = append(problems, fmt.Sprintf("instanceMetadataURL %q is not an http URL", c.InstanceMetadataURL))
+	}
+
+	for _, name := range splitList(c.DisabledRegistryChecks) {
+		if findRegistryCheck(name) == nil {
+			problems = append(problems, fmt.Sprintf("disabledRegistryChecks: unknown check %q. One of: %s", name, strings.Join(registryCheckNames(), ", ")))
+		}
+	}
+
+	// The agent cannot serve without these. The token is optional since the client certificate authenticates the CLI.
+	for _, file := range []string{c.ServerCertFile, c.ServerKeyFile, c.CACertFile, c.ScriptTemplate} {
+		if _, err := os.Stat(file); os.IsNotExist(err) {
+			problems = append(problems, fmt.Sprintf("%s does not exist", file))
+		} else if err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	if info, err := os.Stat(c.InstallDir); os.IsNotExist(err) {
+		problems = append(problems, fmt.Sprintf("the installation directory %s does not exist", c.InstallDir))
+	} else if err != nil {
+		problems = append(problems, err.Error())
+	} else if !info.IsDir() {
+		problems = append(problems, fmt.Sprintf("the installation directory %s is not a directory", c.InstallDir))
+	}
+
+	// The agent creates these files so only their directory has to exist.
+	for _, file := range []string{c.CredentialsFile, c.MonitoringLogFile, c.AuditLogFile, c.Script, c.StateFile} {
+		if info, err := os.Stat(filepath.Dir(file)); err != nil || !info.IsDir() {
+			problems = append(problems, fmt.Sprintf("the directory of %s does not exist", file))
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid agent configuration:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return nil
+}
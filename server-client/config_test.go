@@ -0,0 +1,197 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Points the global config at a temporary layout of the registry host with every file the agent needs to start.
+func useTestConfig(t *testing.T) *Config {
+	t.Helper()
+	dir := t.TempDir()
+	for _, sub := range []string{"cluster", "certs", "app", "app/jobs"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c := defaultConfig()
+	c.InstallDir = filepath.Join(dir, "cluster")
+	c.ReadyMarker = filepath.Join(dir, "READY")
+	c.TokenFile = filepath.Join(dir, "agent-token")
+	c.ServerCertFile = filepath.Join(dir, "certs", "server.crt")
+	c.ServerKeyFile = filepath.Join(dir, "certs", "server.key")
+	c.CACertFile = filepath.Join(dir, "certs", "CAcert.pem")
+	c.CAKeyFile = filepath.Join(dir, "certs", "CAkey.pem")
+	c.CredentialsFile = filepath.Join(dir, "certs", "credentials.json")
+	c.MonitoringLogFile = filepath.Join(dir, "app", "monitoring.log")
+	c.AuditLogFile = filepath.Join(dir, "app", "audit.log")
+	c.JobLogDir = filepath.Join(dir, "app", "jobs")
+	c.ScriptTemplate = filepath.Join(dir, "app", "cluster-installation-script.sh.template")
+	c.Script = filepath.Join(dir, "app", "cluster-installation-script.sh")
+	c.StateFile = filepath.Join(dir, "agent-state.json")
+	for _, file := range []string{c.ServerCertFile, c.ServerKeyFile, c.CACertFile, c.ScriptTemplate} {
+		if err := os.WriteFile(file, []byte("#!/bin/bash\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	previous := config
+	config = c
+	t.Cleanup(func() { config = previous })
+	return c
+}
+
+func writeConfigFile(t *testing.T, c *Config, extra string) string {
+	t.Helper()
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "agent-config.yaml")
+	if err := os.WriteFile(path, append(data, []byte(extra)...), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		edit    func(c *Config)
+		extra   string
+		env     map[string]string
+		wantErr string
+		check   func(t *testing.T, c *Config)
+	}{
+		{
+			name: "file values are kept",
+			edit: func(c *Config) { c.ListenAddress = ":9443" },
+			check: func(t *testing.T, c *Config) {
+				if c.ListenAddress != ":9443" {
+					t.Errorf("ListenAddress = %q, want :9443", c.ListenAddress)
+				}
+			},
+		},
+		{
+			name: "the environment wins over the file",
+			edit: func(c *Config) { c.ListenAddress = ":9443" },
+			env:  map[string]string{"OCPD_AGENT_LISTEN_ADDRESS": ":9444", "OCPD_AGENT_REGION": "eu-west-1"},
+			check: func(t *testing.T, c *Config) {
+				if c.ListenAddress != ":9444" || c.Region != "eu-west-1" {
+					t.Errorf("ListenAddress, Region = %q, %q, want :9444, eu-west-1", c.ListenAddress, c.Region)
+				}
+			},
+		},
+		{
+			name: "the token file is optional",
+			edit: func(c *Config) { c.TokenFile = "" },
+			check: func(t *testing.T, c *Config) {
+				if c.TokenFile != "" {
+					t.Errorf("TokenFile = %q, want empty", c.TokenFile)
+				}
+			},
+		},
+		{
+			name:    "an unknown setting is refused",
+			extra:   "tokenFiles: /tmp/token\n",
+			wantErr: "field tokenFiles not found",
+		},
+		{
+			name:    "a required setting cannot be empty",
+			env:     map[string]string{"OCPD_AGENT_STATE_FILE": ""},
+			wantErr: "stateFile (OCPD_AGENT_STATE_FILE) is empty",
+		},
+		{
+			name:    "the listen address needs a port",
+			edit:    func(c *Config) { c.ListenAddress = "localhost" },
+			wantErr: `listenAddress "localhost" is not a host:port address`,
+		},
+		{
+			name:    "the registry URL must be http(s)",
+			edit:    func(c *Config) { c.RegistryURL = "ftp://localhost" },
+			wantErr: `registryURL "ftp://localhost" is not an http(s) URL`,
+		},
+		{
+			name:    "an unknown registry check cannot be disabled",
+			edit:    func(c *Config) { c.DisabledRegistryChecks = "tls,dns" },
+			wantErr: `disabledRegistryChecks: unknown check "dns"`,
+		},
+		{
+			name:    "the installation directory must exist",
+			edit:    func(c *Config) { c.InstallDir = filepath.Join(c.InstallDir, "missing") },
+			wantErr: "the installation directory",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := useTestConfig(t)
+			if test.edit != nil {
+				test.edit(c)
+			}
+			path := writeConfigFile(t, c, test.extra)
+			for key, value := range test.env {
+				t.Setenv(key, value)
+			}
+
+			loaded, err := loadConfig([]string{"-config", path})
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("loadConfig() error = %v, want %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("loadConfig() error = %v", err)
+			}
+			test.check(t, loaded)
+		})
+	}
+}
+
+func TestLoadConfigMissingFile(t *testing.T) {
+	if _, err := loadConfig([]string{"-config", filepath.Join(t.TempDir(), "missing.yaml")}); err == nil {
+		t.Error("loadConfig() of a missing explicit file succeeded, want an error")
+	}
+}
+
+// The destroy runs the installer of the configured bin directory with the configured AWS credentials.
+func TestDestroyClusterUsesTheConfig(t *testing.T) {
+	c := useTestConfig(t)
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	c.BinDir = t.TempDir()
+	c.AWSCredentialsFile = filepath.Join(t.TempDir(), "credentials")
+
+	// The fake installer records how it was run.
+	calls := filepath.Join(t.TempDir(), "calls")
+	installer := "#!/bin/bash\necho \"$* $AWS_SHARED_CREDENTIALS_FILE\" >> " + calls + "\n"
+	if err := os.WriteFile(filepath.Join(c.BinDir, "openshift-install"), []byte(installer), 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, filepath.Join(c.InstallDir, "metadata.json"), "{}")
+
+	var out strings.Builder
+	if err := destroyCluster(&out); err != nil {
+		t.Fatalf("destroyCluster() error = %v: %s", err, out.String())
+	}
+
+	data, err := os.ReadFile(calls)
+	if err != nil {
+		t.Fatalf("the installer of the bin directory was not run: %v", err)
+	}
+	if want := "destroy cluster --dir " + c.InstallDir + " --log-level debug " + c.AWSCredentialsFile + "\n"; string(data) != want {
+		t.Errorf("installer run with %q, want %q", data, want)
+	}
+	for _, file := range []string{filepath.Join(c.InstallDir, "metadata.json"), filepath.Join(c.BinDir, "openshift-install")} {
+		if _, err := os.Stat(file); !os.IsNotExist(err) {
+			t.Errorf("%s was not removed", file)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(home, ".bashrc")); !os.IsNotExist(err) {
+		t.Errorf("the destroy changed the .bashrc: %v", err)
+	}
+}
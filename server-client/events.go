@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// The cluster state transitions.
+const (
+	clusterAppeared    = "Appeared"
+	clusterDisappeared = "Disappeared"
+)
+
+// The agent keeps the latest events only.
+const maxClusterEvents = 100
+
+// A change of the cluster state seen in the installation directory. Files are the cluster files present after the change.
+type ClusterEvent struct {
+	Type  string
+	At    time.Time
+	Files []string
+}
+
+var (
+	clusterEvents     []ClusterEvent
+	eventSubscribers  = map[chan ClusterEvent]bool{}
+	clusterEventMutex sync.Mutex
+)
+
+// Records the event and sends it to every subscriber. A subscriber that is not keeping up misses the event rather than
+// blocking the monitor.
+func recordClusterEvent(event ClusterEvent) {
+	clusterEventMutex.Lock()
+	defer clusterEventMutex.Unlock()
+
+	log.Printf("Cluster installation %s. Files: %v\n", event.Type, event.Files)
+	clusterEvents = append(clusterEvents, event)
+	if len(clusterEvents) > maxClusterEvents {
+		clusterEvents = clusterEvents[len(clusterEvents)-maxClusterEvents:]
+	}
+	for subscriber := range eventSubscribers {
+		select {
+		case subscriber <- event:
+		default:
+		}
+	}
+}
+
+// Returns a copy of the recorded events, the oldest first.
+func listClusterEvents() []ClusterEvent {
+	clusterEventMutex.Lock()
+	defer clusterEventMutex.Unlock()
+
+	return append([]ClusterEvent{}, clusterEvents...)
+}
+
+// Returns a channel receiving every event from now on and a function to stop receiving them.
+func subscribeClusterEvents() (<-chan ClusterEvent, func()) {
+	clusterEventMutex.Lock()
+	defer clusterEventMutex.Unlock()
+
+	subscriber := make(chan ClusterEvent, 16)
+	eventSubscribers[subscriber] = true
+	return subscriber, func() {
+		clusterEventMutex.Lock()
+		defer clusterEventMutex.Unlock()
+		delete(eventSubscribers, subscriber)
+	}
+}
+
+// ======================================================================================
+// This is the HTTP handler for requests comming on path /v1/events
+// It returns the recorded cluster events. With follow=true the events are sent as JSON lines as they happen until the
+// client goes away.
+// ======================================================================================
+
+func eventsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if r.URL.Query().Get("follow") != "true" {
+		writeJSON(w, http.StatusOK, listClusterEvents())
+		return
+	}
+
+	// Subscribing before sending the recorded events means no event is lost in between, at worst one is sent twice.
+	subscriber, unsubscribe := subscribeClusterEvents()
+	defer unsubscribe()
+
+	flusher, _ := w.(http.Flusher)
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	encoder := json.NewEncoder(w)
+	for _, event := range listClusterEvents() {
+		encoder.Encode(event)
+	}
+	for {
+		if flusher != nil {
+			flusher.Flush()
+		}
+		select {
+		case <-r.Context().Done():
+			return
+		case event := <-subscriber:
+			if err := encoder.Encode(event); err != nil {
+				fmt.Printf("Streaming the cluster events stopped: %v\n", err)
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Forgets the recorded events and the subscribers.
+func resetClusterEvents(t *testing.T) {
+	t.Helper()
+	reset := func() {
+		clusterEventMutex.Lock()
+		clusterEvents, eventSubscribers = nil, map[chan ClusterEvent]bool{}
+		clusterEventMutex.Unlock()
+	}
+	reset()
+	t.Cleanup(reset)
+}
+
+// Starts the cluster monitor on the installation directory of the test config. It is stopped at the end of the test.
+func startClusterMonitor(t *testing.T, installDir string) {
+	t.Helper()
+	// The monitor updates the status that main creates.
+	previous := status
+	status = &InfraStatus{}
+	t.Cleanup(func() { status = previous })
+	stop, stopped := make(chan struct{}), make(chan struct{})
+	go func() {
+		monitorClusterInstallation(installDir, stop)
+		close(stopped)
+	}()
+	t.Cleanup(func() {
+		close(stop)
+		<-stopped
+	})
+}
+
+func readClusterEvent(t *testing.T, events <-chan ClusterEvent) ClusterEvent {
+	t.Helper()
+	select {
+	case event := <-events:
+		return event
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for a cluster event")
+		return ClusterEvent{}
+	}
+}
+
+// The installer creates metadata.json as soon as it starts creating the cluster and the destroy removes it.
+func TestClusterEvents(t *testing.T) {
+	c := useTestConfig(t)
+	resetJobs(t)
+	resetClusterEvents(t)
+	metadata := filepath.Join(c.InstallDir, "metadata.json")
+
+	// A follow subscriber of /v1/events receives the events as JSON lines.
+	server := httptest.NewServer(http.HandlerFunc(eventsHandler))
+	defer server.Close()
+	resp, err := http.Get(server.URL + "/v1/events?follow=true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if contentType := resp.Header.Get("Content-Type"); contentType != "application/x-ndjson" {
+		t.Errorf("content type = %q, want application/x-ndjson", contentType)
+	}
+	followed := make(chan ClusterEvent)
+	go func() {
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			var event ClusterEvent
+			if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+				t.Errorf("the followed event %q is not JSON: %v", scanner.Text(), err)
+				return
+			}
+			followed <- event
+		}
+	}()
+
+	events, unsubscribe := subscribeClusterEvents()
+	defer unsubscribe()
+	startClusterMonitor(t, c.InstallDir)
+	waitUntil(t, "the monitor checked the installation directory", func() bool {
+		_, known := restoredClusterFiles()
+		return known
+	})
+
+	writeTestFile(t, metadata, `{"clusterName": "lab1"}`)
+	appeared := readClusterEvent(t, events)
+	if appeared.Type != clusterAppeared || len(appeared.Files) != 1 || appeared.Files[0] != "metadata.json" {
+		t.Errorf("event = %+v, want %s with metadata.json", appeared, clusterAppeared)
+	}
+	// The status is set right after the event is sent.
+	waitUntil(t, "the cluster status is set once the cluster appeared", getClusterStatus)
+
+	if err := os.Remove(metadata); err != nil {
+		t.Fatal(err)
+	}
+	disappeared := readClusterEvent(t, events)
+	if disappeared.Type != clusterDisappeared || len(disappeared.Files) != 0 {
+		t.Errorf("event = %+v, want %s without files", disappeared, clusterDisappeared)
+	}
+
+	for _, want := range []string{clusterAppeared, clusterDisappeared} {
+		if event := readClusterEvent(t, followed); event.Type != want {
+			t.Errorf("followed event = %+v, want %s", event, want)
+		}
+	}
+
+	// Without follow the recorded events are returned at once.
+	w := httptest.NewRecorder()
+	eventsHandler(w, httptest.NewRequest(http.MethodGet, "/v1/events", nil))
+	var recorded []ClusterEvent
+	if err := json.Unmarshal(w.Body.Bytes(), &recorded); err != nil {
+		t.Fatal(err)
+	}
+	if len(recorded) != 2 || recorded[0].Type != clusterAppeared || recorded[1].Type != clusterDisappeared {
+		t.Errorf("recorded events = %+v, want %s and %s", recorded, clusterAppeared, clusterDisappeared)
+	}
+}
+
+func TestClusterEventsKeepTheLatest(t *testing.T) {
+	resetClusterEvents(t)
+	for i := 0; i < maxClusterEvents+10; i++ {
+		recordClusterEvent(ClusterEvent{Type: clusterAppeared, At: time.Unix(int64(i), 0)})
+	}
+
+	events := listClusterEvents()
+	if len(events) != maxClusterEvents || events[0].At.Unix() != 10 {
+		t.Errorf("kept %d events from %v, want %d from the 10th", len(events), events[0].At.Unix(), maxClusterEvents)
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -9,6 +10,8 @@ import (
 	"net/http"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"reflect"
 	"strings"
 	"sync"
 	"time"
@@ -16,25 +19,25 @@ import (
 	"gopkg.in/yaml.v3"
 )
 
-const (
-	url        = "https://localhost:8443"
-	installDir = "/ec2-user/cluster"
-)
-
 var (
 	isRegistryHealthy         bool
+	registryCheckResults      []RegistryCheckResult
 	isClusterInstalled        bool
 	healthMutex, clusterMutex sync.Mutex
 	status                    *InfraStatus
 	statusMutex               sync.Mutex
-	agentAction               *DeployDestroy
+	authToken                 string
 )
 
 type InfraStatus struct {
-	RegistryHealth string
-	ClusterStatus  string
+	RegistryHealth  string
+	ClusterStatus   string
+	BootstrapStatus string
+	RegistryChecks  []RegistryCheckResult `json:",omitempty"`
+	Progress        *InstallProgress      `json:",omitempty"`
 }
 
+// The action the client requests. Deploy is either Install or Destroy.
 type DeployDestroy struct {
 	ClusterVersion string
 	Deploy         string
@@ -44,10 +47,16 @@ func main() {
 
 	status = &InfraStatus{}
 
-	agentAction = &DeployDestroy{}
+	// The paths and the port come from the config file and the environment. The agent refuses to start with a wrong one.
+	loaded, err := loadConfig(os.Args[1:])
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(4)
+	}
+	config = loaded
 
 	// Open a file for logging
-	logFile, err := os.OpenFile("/app/monitoring.log", os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	logFile, err := os.OpenFile(config.MonitoringLogFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
 	if err != nil {
 		log.Fatalf("Failed to open log file: %v", err)
 	}
@@ -55,11 +64,17 @@ func main() {
 
 	log.SetOutput(logFile)
 
+	// The jobs, the last action and install-config and the cluster state from before a restart.
+	if err := restoreState(config); err != nil {
+		fmt.Printf("Error restoring the agent state: %s\n", err)
+		os.Exit(4)
+	}
+
 	fmt.Println("Starting monitoring the deployment")
 
-	go monitorRegistry(url)
+	go monitorRegistry(config)
 
-	go monitorClusterInstallation(installDir)
+	go monitorClusterInstallation(config.InstallDir, nil)
 
 	agentHTTPServer()
 
@@ -70,69 +85,96 @@ func agentHTTPServer() {
 
 	fmt.Println("Starting HTTP agent-server")
 
+	if err := loadCredentials(); err != nil {
+		fmt.Printf("Error loading the credentials: %s\n", err)
+		os.Exit(4)
+	}
+
+	token, err := getAuthTokenFromFile(config.TokenFile)
+	if err != nil {
+		fmt.Printf("Error reading the agent token: %s\n", err)
+		os.Exit(4)
+	}
+	authToken = token
+
 	// This handler will reply with the status of Registry and Cluster
-	http.HandleFunc("/status", withAuthorization(statusHandler))
+	http.HandleFunc("/status", withMetrics("/status", withAuthorization(statusHandler)))
 
-	// This handler will get the install-config from the agent.
+	// This handler will get the install-config from the agent. Only operators can send it.
 
-	http.HandleFunc("/data", withAuthorization(installConfigHandler))
+	http.HandleFunc("/data", withMetrics("/data", withOperatorAuthorization(installConfigHandler)))
 
-	// This handler will get the install or destroy action and a cluster-version in case of install
+	// This handler will get the install or destroy action and a cluster-version in case of install. Only operators can send it.
 
-	http.HandleFunc("/action", withAuthorization(deployDestroyHandler))
+	http.HandleFunc("/action", withMetrics("/action", withOperatorAuthorization(deployDestroyHandler)))
 
-	// These are the Certificate and key of the agent signed by the CAcert.pem that is local to the user machine.
-	certFile := "/ec2-user/certs/server.crt"
-	keyFile := "/ec2-user/certs/server.key"
+	// These handlers create a job for an install or destroy action and report the jobs with their outcome and their logs.
 
-	fmt.Println("Starting HTTP Agent")
-	if err := http.ListenAndServeTLS(":8090", certFile, keyFile, nil); err != nil {
-		fmt.Printf("Error Starting HTTP Agent: %s\n", err)
-	}
+	http.HandleFunc("/v1/jobs", withMetrics("/v1/jobs", withAuthorization(jobsHandler)))
+	http.HandleFunc("/v1/jobs/", withMetrics("/v1/jobs/", withAuthorization(jobHandler)))
 
-}
+	// This handler reports when the cluster appeared in or disappeared from the installation directory, or streams the changes.
 
-// This function is a security authentication mechanism to authorize requests with a token that only agent and client knows. We return 403 otherwise.
-func withAuthorization(next http.HandlerFunc) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		fmt.Println("withAuthorization: Checking token")
-		authToken := getAuthTokenFromFile()
-		fmt.Printf("The Token is %v\n", authToken)
-		authHeader := r.Header.Get("X-Auth-Token")
-		fmt.Printf("The Token received is: %v\n", authHeader)
-		if authHeader != authToken {
-			fmt.Println("The two strings are different")
-			http.Error(w, "Forbidden", http.StatusForbidden)
-			return
-		}
-		next.ServeHTTP(w, r)
-	}
-}
+	http.HandleFunc("/v1/events", withMetrics("/v1/events", withAuthorization(eventsHandler)))
 
-// We use this function so the agent to know what is the token it should expect when contacted by the client.
-func getAuthTokenFromFile() string {
-	// Open the file
-	file, err := os.Open("/ec2-user/agent-token")
+	// These handlers mint, list and revoke the viewer credentials teammates use to share the lab. Only operators can use them.
+
+	http.HandleFunc("/v1/credentials", withMetrics("/v1/credentials", withOperatorAuthorization(credentialsHandler)))
+	http.HandleFunc("/v1/credentials/", withMetrics("/v1/credentials/", withOperatorAuthorization(credentialHandler)))
+
+	// These handlers tell if the agent is alive and ready and expose its metrics to Prometheus. They need no authentication.
+
+	http.HandleFunc("/healthz", withMetrics("/healthz", healthzHandler))
+	http.HandleFunc("/readyz", withMetrics("/readyz", readyzHandler))
+	http.HandleFunc("/metrics", withMetrics("/metrics", metricsHandler))
+
+	// The CLI presents a client certificate issued from the same CA. A connection without one can only reach /healthz, /readyz
+	// and /metrics, withAuthorization refuses it everywhere else.
+	caCert, err := os.ReadFile(config.CACertFile)
 	if err != nil {
-		fmt.Println("Error opening agent-token file:", err)
+		fmt.Printf("Error reading the CA certificate: %s\n", err)
 		os.Exit(4)
 	}
-	defer file.Close()
-
-	// Read the file content
-	content, err := io.ReadAll(file)
-	if err != nil {
-		fmt.Println("Error reading agent-token file:", err)
+	clientCAs := x509.NewCertPool()
+	if !clientCAs.AppendCertsFromPEM(caCert) {
+		fmt.Printf("No certificate found in %s\n", config.CACertFile)
 		os.Exit(4)
 	}
-	// Convert content to string and trim any extra whitespace
-	result := strings.TrimSpace(string(content))
 
-	// Output the result
-	fmt.Println("agent-token file content appended to variable:")
-	fmt.Println(result)
+	server := &http.Server{
+		Addr: config.ListenAddress,
+		TLSConfig: &tls.Config{
+			ClientAuth: tls.VerifyClientCertIfGiven,
+			ClientCAs:  clientCAs,
+			MinVersion: tls.VersionTLS12,
+		},
+	}
+
+	// These are the Certificate and key of the agent signed by the CAcert.pem that is local to the user machine.
+	fmt.Printf("Starting HTTP Agent on %s\n", config.ListenAddress)
+	if err := server.ListenAndServeTLS(config.ServerCertFile, config.ServerKeyFile); err != nil {
+		fmt.Printf("Error Starting HTTP Agent: %s\n", err)
+	}
+
+}
 
-	return result
+// We use this function so the agent to know what is the token it should expect when contacted by the client.
+// It is read once when the agent starts. Without tokenFile or the file no token is configured and only the client certificates
+// authenticate.
+func getAuthTokenFromFile(path string) (string, error) {
+	if len(path) == 0 {
+		fmt.Println("No agent token configured, only the client certificates authenticate")
+		return "", nil
+	}
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		fmt.Printf("No agent token at %s, only the client certificates authenticate\n", path)
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	// Convert content to string and trim any extra whitespace
+	return strings.TrimSpace(string(content)), nil
 }
 
 // This is used to update the status of the registry and cluster existence and populates the struct that holds these values.
@@ -150,8 +192,17 @@ func updateInfraStatus() {
 		clusterStatus = "Exists"
 	}
 
+	// The bootstrap script of the registry host writes the READY marker as its last step.
+	bootstrapStatus := "NotReady"
+	if _, err := os.Stat(config.ReadyMarker); err == nil {
+		bootstrapStatus = "Ready"
+	}
+
 	status.RegistryHealth = registryHealth
 	status.ClusterStatus = clusterStatus
+	status.BootstrapStatus = bootstrapStatus
+	status.RegistryChecks = getRegistryChecks()
+	status.Progress = currentInstallProgress()
 }
 
 // ======================================================================================
@@ -181,6 +232,12 @@ func statusHandler(w http.ResponseWriter, r *http.Request) {
 //======================================================================================
 
 func installConfigHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
 	// Read the request body
 	fmt.Println("Using deployHandler")
 	body, err := io.ReadAll(r.Body)
@@ -200,64 +257,79 @@ func installConfigHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// A mistake in the install-config would only show up in openshift-install minutes after the job started.
+	// The region and the subnets are not checked if the lab cannot be read from the instance metadata.
+	lab, err := getLab(config)
+	if err != nil {
+		fmt.Printf("Cannot check the install-config against the lab: %v\n", err)
+	}
+	if errs := validateInstallConfig(body, r.URL.Query().Get("clusterVersion"), lab); len(errs) > 0 {
+		fmt.Printf("The install-config is not valid: %v\n", errs)
+		writeJSON(w, http.StatusUnprocessableEntity, InstallConfigErrors{Errors: errs})
+		return
+	}
+
+	if err := writeInstallConfig(jsonData); err != nil {
+		http.Error(w, "Error writing file", http.StatusInternalServerError)
+		return
+	}
+
+	// It is kept in the agent state so it survives a restart of the agent.
+	rememberInstallConfig(jsonData)
+
+	// Respond to the client
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Data received and saved successfully"))
+}
+
+// Writes the install-config received from the client as YAML in the installation directory.
+func writeInstallConfig(jsonData map[string]interface{}) error {
 	// Marshal the map back to YAML
 	fmt.Println("Marshal the JSON to yaml")
 	yamlData, err := yaml.Marshal(jsonData)
 	if err != nil {
 		fmt.Println("Marshal the JSON to yaml:", err)
-		http.Error(w, "Error converting to YAML", http.StatusInternalServerError)
-		return
+		return err
 	}
 
 	// Save the YAML data to a file
 	fmt.Println("Save the YAML data to a file")
-	filePath := installDir + "/install-config.yaml"
+	filePath := filepath.Join(config.InstallDir, "install-config.yaml")
 
 	fmt.Println("The file path is:", filePath)
 	err = os.WriteFile(filePath, yamlData, 0644)
 	if err != nil {
-		http.Error(w, "Error writing file", http.StatusInternalServerError)
 		fmt.Println("Save the YAML data to a file error is:", err)
-		return
+		return err
 	}
-
-	// Respond to the client
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte("Data received and saved successfully"))
+	return nil
 }
 
 //======================================================================================
-// Monitors the Registry by testing port 8443 every 5 seconds
+// Monitors the Registry by running the registry checks against its URL (https://localhost:8443 by default) every 5 seconds
 //======================================================================================
 
-func monitorRegistry(url string) {
+func monitorRegistry(c *Config) {
 
-	http.DefaultTransport.(*http.Transport).TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
 	for {
 
-		log.Println("Monitoring remote port...")
-		resp, err := http.Get(url)
-		if err != nil {
-			log.Printf("Error making GET request: %s\n", err)
-			setHealthStatus(false)
-		} else {
-
-			if resp.StatusCode == http.StatusOK {
-				log.Printf("The registry listens to %s\n", url)
-				setHealthStatus(true)
-			} else if resp.StatusCode != http.StatusOK {
-				log.Printf("Received non-200 status code: %d\n", resp.StatusCode)
-				setHealthStatus(false)
-			}
-			resp.Body.Close()
+		log.Println("Checking the registry...")
+		probeStart := time.Now()
+		results := runRegistryChecks(c)
+		for _, result := range results {
+			log.Printf("Registry check %s passed: %t. %s\n", result.Name, result.Passed, result.Message)
 		}
+		healthy := registryHealthy(results)
+		observeRegistryProbe(time.Since(probeStart), healthy)
+		setHealthStatus(healthy, results)
 		time.Sleep(5 * time.Second)
 	}
 }
 
-func setHealthStatus(health bool) {
+func setHealthStatus(health bool, results []RegistryCheckResult) {
 	healthMutex.Lock()
 	isRegistryHealthy = health
+	registryCheckResults = results
 	healthMutex.Unlock()
 	updateInfraStatus()
 }
@@ -268,58 +340,103 @@ func getHealthStatus() bool {
 	return isRegistryHealthy
 }
 
+// Returns the results of the last registry checks. The slice is replaced, never modified, so it can be shared.
+func getRegistryChecks() []RegistryCheckResult {
+	healthMutex.Lock()
+	defer healthMutex.Unlock()
+	return registryCheckResults
+}
+
 //======================================================================================
 // Here we monitor the if the cluster installation is present. We check that by checking for a terraform.tfstate files in the installation directory.
-// We check every 5 seconds.
+// The directory is watched with inotify so a change is seen at once. If it cannot be watched we check every 5 seconds.
+// Only the changes of the cluster state are logged and recorded as events.
 //======================================================================================
 
-func monitorClusterInstallation(installDir string) {
+// The files openshift-install leaves in the installation directory while a cluster exists. Versions 4.16+ use no
+// terraform.tfstate files anymore but keep terraform.platform.auto.tfvars.json. metadata.json is written as soon as the
+// installer starts creating the cluster, so an install cancelled half way is still found and can be destroyed.
+var clusterFiles = []string{"terraform.bootstrap.tfstate", "terraform.cluster.tfstate", "terraform.platform.auto.tfvars.json", "metadata.json"}
+
+const (
+	clusterPollInterval = 5 * time.Second
+
+	// Even when watching, the directory is checked this often in case an event was missed.
+	clusterRecheckInterval = 30 * time.Second
+
+	// The installer writes its log all the time. The changes that come in within this delay are handled at once.
+	clusterChangeDelay = 1 * time.Second
+)
+
+// Checks the installation directory until stop is closed. The agent never stops it.
+func monitorClusterInstallation(installDir string, stop <-chan struct{}) {
 
+	var changes <-chan struct{}
+	// The cluster files seen before the agent restarted, so a change that happened while it was down is recorded too.
+	present, known := restoredClusterFiles()
+	first := true
 	for {
-		bootstrapExists := false
-		clusterExists := false
-		cluster_4_16_Exists := false
-
-		bootstrapFile := installDir + "/" + "terraform.bootstrap.tfstate"
-		clusterFile := installDir + "/" + "terraform.cluster.tfstate"
-		cluster_4_16_file := installDir + "/" + "terraform.platform.auto.tfvars.json"
-
-		log.Printf("The path is: %s\n", bootstrapFile)
-		log.Printf("The path is: %s\n", clusterFile)
-
-		if _, err := os.Stat(bootstrapFile); os.IsNotExist(err) {
-			log.Println("No terraform.bootstrap.tfstate file detected.")
-		} else if err == nil {
-			bootstrapExists = true
-			log.Println("Terraform.bootstrap.tfstate file detected.")
+		if changes == nil {
+			var err error
+			if changes, err = watchDir(installDir); err != nil {
+				if first {
+					log.Printf("Polling %s every %v: %v\n", installDir, clusterPollInterval, err)
+				}
+			} else {
+				log.Printf("Watching %s for cluster changes\n", installDir)
+			}
 		}
 
-		if _, err := os.Stat(clusterFile); os.IsNotExist(err) {
-			log.Println("No terraform.cluster.tfstate file detected.")
-		} else if err == nil {
-			clusterExists = true
-			log.Println("Terraform.cluster.tfstate file detected.")
+		// The progress of the installation is parsed from the installer log and reported by /status.
+		if err := installLog.update(config.installerLog()); err != nil {
+			log.Printf("Cannot read the installer log: %v\n", err)
 		}
 
-		// For OCP version 4.16 + that use no terraform.tfstate files anymore.
-		//=================================================================
-		if _, err := os.Stat(cluster_4_16_file); os.IsNotExist(err) {
-			log.Println("No terraform.platform.auto.tfvars.json file detected.")
-		} else if err == nil {
-			cluster_4_16_Exists = true
-			log.Println("Terraform.platform.auto.tfvars.json file detected.")
+		found := presentClusterFiles(installDir)
+		if first {
+			log.Printf("Cluster installation present at startup: %t. Files: %v\n", len(found) > 0, found)
 		}
-		//=================================================================
-
-		if bootstrapExists || clusterExists || cluster_4_16_Exists {
-			log.Println("At least one cluster file detected in the install-dir. There is a cluster installation present")
-			setClusterStatus(true)
-		} else {
-			setClusterStatus(false)
+		if len(found) > 0 && len(present) == 0 && known {
+			recordClusterEvent(ClusterEvent{Type: clusterAppeared, At: time.Now(), Files: found})
+		} else if len(found) == 0 && len(present) > 0 && known {
+			recordClusterEvent(ClusterEvent{Type: clusterDisappeared, At: time.Now(), Files: found})
+		}
+		if first || !reflect.DeepEqual(found, present) {
+			rememberClusterFiles(found)
 		}
+		present = found
+		known = true
+		first = false
+		setClusterStatus(len(found) > 0)
+		observeClusterCheck()
+
+		wait := clusterPollInterval
+		if changes != nil {
+			wait = clusterRecheckInterval
+		}
+		select {
+		case _, open := <-changes:
+			if !open {
+				log.Printf("Stopped watching %s, polling until it can be watched again\n", installDir)
+				changes = nil
+			}
+			time.Sleep(clusterChangeDelay)
+		case <-time.After(wait):
+		case <-stop:
+			return
+		}
+	}
+}
 
-		time.Sleep(5 * time.Second)
+// Returns the cluster files present in the installation directory.
+func presentClusterFiles(installDir string) []string {
+	found := []string{}
+	for _, name := range clusterFiles {
+		if _, err := os.Stat(filepath.Join(installDir, name)); err == nil {
+			found = append(found, name)
+		}
 	}
+	return found
 }
 
 func setClusterStatus(clusterState bool) {
@@ -335,74 +452,61 @@ func getClusterStatus() bool {
 	return isClusterInstalled
 }
 
-// ====================================================================================================================================================
-// This is the fuction that controls the either the installation or destroy of openshift the openshift cluster according to the user requested action.
-// ====================================================================================================================================================
-
-func installOrDestroyCluster(action string, clusterVersion string) {
-
-	if agentAction.Deploy == "Install" && len(agentAction.ClusterVersion) > 0 {
-		populateVersionToInstallerScript(clusterVersion)
-		installCluster()
-	} else if agentAction.Deploy == "Destroy" && len(agentAction.ClusterVersion) == 3 {
-		fmt.Println("Destroying cluster")
-		destroyCluster()
-	} else {
-		fmt.Printf("Invalid combination of actionAndVersion. Action: %s Version: %s", action, clusterVersion)
-	}
-
-}
-
 // ======================================================================================
 // This is running the bash script for destroying the cluster
 // ======================================================================================
 
-func destroyCluster() {
+func destroyCluster(out io.Writer) error {
 
 	fmt.Println("Running the openshift-install destroy command")
 
-	cmdStr := `echo 'export PATH="/ec2-user/bin:$PATH"' >> $HOME/.bashrc && \
-	echo 'export AWS_SHARED_CREDENTIALS_FILE=/ec2-user/.aws/credentials' >> $HOME/.bashrc && \
-	source $HOME/.bashrc && \
-	openshift-install destroy cluster --dir "` + installDir + `" --log-level debug && \
-	rm -rf /ec2-user/bin/openshift-install && \
-	rm -rf /ec2-user/bin/oc && \
+	cmdStr := `if [ -f "` + filepath.Join(config.InstallDir, "metadata.json") + `" ]; then \
+		openshift-install destroy cluster --dir "` + config.InstallDir + `" --log-level debug && \
+		rm -f "` + filepath.Join(config.InstallDir, "metadata.json") + `"; \
+	else \
+		echo "There is no metadata.json. The installer did not create anything on AWS"; \
+	fi && \
+	rm -rf "` + filepath.Join(config.BinDir, "openshift-install") + `" && \
+	rm -rf "` + filepath.Join(config.BinDir, "oc") + `" && \
 	rm -rf /ec2-user/mirroring-workspace/imageset-config.yaml && \
-	rm -rf /app/cluster-installation-script.sh && \
-	rm -rf /ec2-user/cluster/.openshift_install.log`
+	rm -rf "` + config.Script + `" && \
+	rm -rf "` + config.installerLog() + `"`
 
 	cmd := exec.Command("bash", "-c", cmdStr)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	// The installer the install script downloaded and the AWS credentials of the lab.
+	cmd.Env = append(os.Environ(), "PATH="+config.BinDir+":"+os.Getenv("PATH"), "AWS_SHARED_CREDENTIALS_FILE="+config.AWSCredentialsFile)
+	cmd.Stdout = out
+	cmd.Stderr = out
 
 	// Start the command and check for errors
-	if err := cmd.Run(); err != nil {
+	if err := runJobCommand(cmd); err != nil {
 		fmt.Printf("Error running openshift-install destroy command: %v\n", err)
-	} else {
-		fmt.Println("openshift-install destroy executed successfully")
+		return err
 	}
+	fmt.Println("openshift-install destroy executed successfully")
+	return nil
 }
 
 // ======================================================================================
 // This is running the bash script for installing the cluster
 // ======================================================================================
 
-func installCluster() {
+func installCluster(out io.Writer) error {
 	fmt.Println("Running the installation script as ec2-user")
 
-	cmdStr := `chmod +x /app/cluster-installation-script.sh && /app/cluster-installation-script.sh`
+	cmdStr := `chmod +x "` + config.Script + `" && "` + config.Script + `"`
 
 	cmd := exec.Command("bash", "-c", cmdStr)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	cmd.Stdout = out
+	cmd.Stderr = out
 
 	// Start the command and check for errors
-	if err := cmd.Run(); err != nil {
+	if err := runJobCommand(cmd); err != nil {
 		fmt.Printf("Error running script: %v\n", err)
-	} else {
-		fmt.Println("Script executed successfully")
+		return err
 	}
-
+	fmt.Println("Script executed successfully")
+	return nil
 }
 
 //======================================================================================
@@ -410,6 +514,12 @@ func installCluster() {
 //======================================================================================
 
 func deployDestroyHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
 	// Read the request body
 	fmt.Println("Using desployDestroyHandler")
 	body, err := io.ReadAll(r.Body)
@@ -421,35 +531,44 @@ func deployDestroyHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Unmarshal the JSON data to a generic map
 	fmt.Println("Unmarshal the JSON")
-	err = json.Unmarshal(body, &agentAction)
+	var action DeployDestroy
+	err = json.Unmarshal(body, &action)
 	if err != nil {
 		fmt.Println("Unmarshal the JSON error:", err)
 		http.Error(w, "Invalid JSON data actionForAgent", http.StatusBadRequest)
 		return
 	}
 
-	message := fmt.Sprintf("Agent action received and saved successfully. Action is: %s and Version is: %s\n", agentAction.Deploy, agentAction.ClusterVersion)
+	// The action runs as a job like the ones created with /v1/jobs so two actions can never run at the same time.
+	job, err := startJob(action)
+	if err != nil {
+		statusCode := http.StatusBadRequest
+		if _, ok := err.(*jobConflictError); ok {
+			statusCode = http.StatusConflict
+		}
+		http.Error(w, err.Error(), statusCode)
+		return
+	}
+
+	message := fmt.Sprintf("Agent action received and saved successfully. Action is: %s and Version is: %s. Job is: %s\n", job.Action, job.ClusterVersion, job.ID)
 
 	// Respond to the client
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte(message))
-
-	fmt.Printf("Running go routine to install or destroy the cluster. Action is: %s and Version is: %s\n", agentAction.Deploy, agentAction.ClusterVersion)
-	go installOrDestroyCluster(agentAction.Deploy, agentAction.ClusterVersion)
 }
 
 //============================================================================================
 // Here we populate the installer script with specific details taken from the /action handler
 //============================================================================================
 
-func populateVersionToInstallerScript(clusterVersion string) {
+func populateVersionToInstallerScript(clusterVersion string) error {
 
 	// Read the contents of the Terraform template file
 	fmt.Println("Updating the installer script file")
-	scriptContent, err := os.ReadFile("/app/cluster-installation-script.sh.template")
+	scriptContent, err := os.ReadFile(config.ScriptTemplate)
 	if err != nil {
 		fmt.Println("Cannot read install script file")
-		return
+		return err
 	}
 
 	//Create the Release channel from the cluster version provided from the user
@@ -461,13 +580,18 @@ func populateVersionToInstallerScript(clusterVersion string) {
 		clusterReleaseChannnel = "stable-" + parts[0] + "." + parts[1]
 	}
 
+	// The wildcard *.apps record goes to the zone of <cluster name>.<base domain> of the install-config
+	clusterName, baseDomain := rememberedClusterDomain()
+
 	// Replace the placeholder string with the generated public key path
 	replacedClusterVersion := strings.ReplaceAll(string(scriptContent), "$CLUSTER_VERSION", clusterVersion)
 	replacedChannel := strings.ReplaceAll(string(replacedClusterVersion), "$RELEASE_CHANNEL", clusterReleaseChannnel)
-	err = os.WriteFile("/app/cluster-installation-script.sh", []byte(replacedChannel), 0644)
+	replacedClusterName := strings.ReplaceAll(replacedChannel, "$CLUSTER_NAME", clusterName)
+	replacedBaseDomain := strings.ReplaceAll(replacedClusterName, "$BASE_DOMAIN", baseDomain)
+	err = os.WriteFile(config.Script, []byte(replacedBaseDomain), 0644)
 	if err != nil {
 		fmt.Println("Cannot write the Installer script file")
-		return
+		return err
 	}
-
+	return nil
 }
@@ -0,0 +1,403 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The parts of the install-config the agent validates. The document written to the installation directory is the one
+// the client sent, fields missing here are kept as they are.
+type InstallConfig struct {
+	APIVersion   string        `json:"apiVersion"`
+	BaseDomain   string        `json:"baseDomain"`
+	Metadata     Metadata      `json:"metadata"`
+	ControlPlane *MachinePool  `json:"controlPlane"`
+	Compute      []MachinePool `json:"compute"`
+	Networking   *Networking   `json:"networking"`
+	Platform     Platform      `json:"platform"`
+}
+
+type Metadata struct {
+	Name string `json:"name"`
+}
+
+type MachinePool struct {
+	Name     string `json:"name"`
+	Replicas *int64 `json:"replicas"`
+}
+
+type Networking struct {
+	NetworkType    string                `json:"networkType"`
+	ClusterNetwork []ClusterNetworkEntry `json:"clusterNetwork"`
+	MachineNetwork []MachineNetworkEntry `json:"machineNetwork"`
+	ServiceNetwork []string              `json:"serviceNetwork"`
+}
+
+type ClusterNetworkEntry struct {
+	CIDR       string `json:"cidr"`
+	HostPrefix int32  `json:"hostPrefix"`
+}
+
+type MachineNetworkEntry struct {
+	CIDR string `json:"cidr"`
+}
+
+type Platform struct {
+	AWS *AWSPlatform `json:"aws"`
+}
+
+type AWSPlatform struct {
+	Region  string   `json:"region"`
+	Subnets []string `json:"subnets"`
+}
+
+// A problem found in the install-config. Field is the path of the field like networking.clusterNetwork[0].cidr.
+type FieldError struct {
+	Field   string
+	Message string
+}
+
+// The reply to an install-config that is not valid.
+type InstallConfigErrors struct {
+	Errors []FieldError
+}
+
+// The lab the agent runs in, read from the instance metadata. The cluster must be installed in its region and VPC.
+type Lab struct {
+	Region string
+	VPCID  string
+}
+
+// A subnet as described by aws ec2 describe-subnets.
+type AWSSubnet struct {
+	SubnetId  string
+	VpcId     string
+	CidrBlock string
+}
+
+// OpenShiftSDN cannot be used for new clusters from 4.15. A single node cluster can be installed on AWS from 4.11 and a
+// control plane of 4 or 5 nodes from 4.18.
+const (
+	lastOpenShiftSDNMinor     = 14
+	singleNodeMinMinor        = 11
+	largeControlPlaneMinMinor = 18
+)
+
+var (
+	dnsLabel     = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+	dnsSubdomain = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?(\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*$`)
+)
+
+// Decodes the install-config and validates it. clusterVersion is the version the client is going to install, empty if
+// the client did not tell. lab is nil when the agent could not read it, then the region and the subnets are not checked.
+func validateInstallConfig(body []byte, clusterVersion string, lab *Lab) []FieldError {
+	installConfig := InstallConfig{}
+	if err := json.Unmarshal(body, &installConfig); err != nil {
+		if typeError, ok := err.(*json.UnmarshalTypeError); ok {
+			return []FieldError{{Field: typeError.Field, Message: fmt.Sprintf("cannot be a %s", typeError.Value)}}
+		}
+		return []FieldError{{Field: "", Message: err.Error()}}
+	}
+
+	var errs []FieldError
+	add := func(field string, format string, args ...interface{}) {
+		errs = append(errs, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if installConfig.APIVersion != "v1" {
+		add("apiVersion", "must be v1, not %q", installConfig.APIVersion)
+	}
+	if name := installConfig.Metadata.Name; name == "" {
+		add("metadata.name", "is required")
+	} else if len(name) > 63 || !dnsLabel.MatchString(name) {
+		add("metadata.name", "%q must be a DNS label: at most 63 lower case letters, digits and '-', starting and ending with a letter or a digit", name)
+	}
+	if domain := installConfig.BaseDomain; domain == "" {
+		add("baseDomain", "is required")
+	} else if len(domain) > 253 || !dnsSubdomain.MatchString(domain) {
+		add("baseDomain", "%q must be a DNS domain like example.com", domain)
+	}
+
+	errs = append(errs, validateReplicas(&installConfig, clusterVersion)...)
+	errs = append(errs, validateNetworking(installConfig.Networking, clusterVersion)...)
+	errs = append(errs, validatePlatform(&installConfig, lab)...)
+	return errs
+}
+
+// A single node cluster has one control plane node and no workers, otherwise the control plane has three nodes, or four
+// or five from 4.18. When the version is not known only 1 and 3 are accepted.
+func validateReplicas(installConfig *InstallConfig, clusterVersion string) []FieldError {
+	var errs []FieldError
+	controlPlaneReplicas := int64(3)
+	if installConfig.ControlPlane != nil && installConfig.ControlPlane.Replicas != nil {
+		controlPlaneReplicas = *installConfig.ControlPlane.Replicas
+		minor, known := minorVersion(clusterVersion)
+		switch {
+		case controlPlaneReplicas == 3:
+		case controlPlaneReplicas == 1:
+			if known && minor < singleNodeMinMinor {
+				errs = append(errs, FieldError{Field: "controlPlane.replicas", Message: fmt.Sprintf("a single node cluster cannot be installed on AWS before 4.%d, not %s", singleNodeMinMinor, clusterVersion)})
+			}
+		case controlPlaneReplicas == 4 || controlPlaneReplicas == 5:
+			if !known || minor < largeControlPlaneMinMinor {
+				errs = append(errs, FieldError{Field: "controlPlane.replicas", Message: fmt.Sprintf("%d needs 4.%d or later, the cluster version is %q", controlPlaneReplicas, largeControlPlaneMinMinor, clusterVersion)})
+			}
+		default:
+			errs = append(errs, FieldError{Field: "controlPlane.replicas", Message: fmt.Sprintf("must be 1, 3, 4 or 5, not %d", controlPlaneReplicas)})
+		}
+	}
+
+	for i, pool := range installConfig.Compute {
+		if pool.Replicas == nil {
+			continue
+		}
+		field := fmt.Sprintf("compute[%d].replicas", i)
+		if *pool.Replicas < 0 {
+			errs = append(errs, FieldError{Field: field, Message: fmt.Sprintf("must not be negative, not %d", *pool.Replicas)})
+		} else if controlPlaneReplicas == 1 && *pool.Replicas != 0 {
+			errs = append(errs, FieldError{Field: field, Message: "must be 0 for a single node cluster (controlPlane.replicas is 1)"})
+		}
+	}
+	return errs
+}
+
+// Checks the network type against the version and that the cluster, service and machine networks do not overlap.
+func validateNetworking(networking *Networking, clusterVersion string) []FieldError {
+	if networking == nil {
+		return []FieldError{{Field: "networking", Message: "is required"}}
+	}
+
+	var errs []FieldError
+	switch networking.NetworkType {
+	case "OVNKubernetes":
+	case "OpenShiftSDN":
+		if minor, ok := minorVersion(clusterVersion); ok && minor > lastOpenShiftSDNMinor {
+			errs = append(errs, FieldError{Field: "networking.networkType", Message: fmt.Sprintf("OpenShiftSDN cannot be used to install %s, use OVNKubernetes", clusterVersion)})
+		}
+	default:
+		errs = append(errs, FieldError{Field: "networking.networkType", Message: fmt.Sprintf("must be OVNKubernetes or OpenShiftSDN, not %q", networking.NetworkType)})
+	}
+
+	type namedNetwork struct {
+		field   string
+		network *net.IPNet
+	}
+	var networks []namedNetwork
+	parse := func(field string, cidr string) *net.IPNet {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			errs = append(errs, FieldError{Field: field, Message: fmt.Sprintf("%q is not a CIDR", cidr)})
+			return nil
+		}
+		networks = append(networks, namedNetwork{field: field, network: network})
+		return network
+	}
+
+	if len(networking.ClusterNetwork) == 0 {
+		errs = append(errs, FieldError{Field: "networking.clusterNetwork", Message: "is required"})
+	}
+	for i, entry := range networking.ClusterNetwork {
+		field := fmt.Sprintf("networking.clusterNetwork[%d]", i)
+		if network := parse(field+".cidr", entry.CIDR); network != nil {
+			ones, bits := network.Mask.Size()
+			if int(entry.HostPrefix) <= ones || int(entry.HostPrefix) > bits {
+				errs = append(errs, FieldError{Field: field + ".hostPrefix", Message: fmt.Sprintf("must be between %d and %d for %s, not %d", ones+1, bits, entry.CIDR, entry.HostPrefix)})
+			}
+		}
+	}
+	if len(networking.ServiceNetwork) == 0 {
+		errs = append(errs, FieldError{Field: "networking.serviceNetwork", Message: "is required"})
+	}
+	for i, cidr := range networking.ServiceNetwork {
+		parse(fmt.Sprintf("networking.serviceNetwork[%d]", i), cidr)
+	}
+	for i, entry := range networking.MachineNetwork {
+		parse(fmt.Sprintf("networking.machineNetwork[%d].cidr", i), entry.CIDR)
+	}
+
+	for i := range networks {
+		for j := i + 1; j < len(networks); j++ {
+			if networks[i].network.Contains(networks[j].network.IP) || networks[j].network.Contains(networks[i].network.IP) {
+				errs = append(errs, FieldError{Field: networks[j].field, Message: fmt.Sprintf("%s overlaps with %s (%s)", networks[j].network, networks[i].field, networks[i].network)})
+			}
+		}
+	}
+	return errs
+}
+
+// The cluster is installed in the private subnets of the lab so the region and the subnets must be the ones of the lab.
+// The subnets must also be in the machine network, openshift-install refuses them otherwise.
+func validatePlatform(installConfig *InstallConfig, lab *Lab) []FieldError {
+	platform := installConfig.Platform.AWS
+	if platform == nil {
+		return []FieldError{{Field: "platform.aws", Message: "is required, the lab is on AWS"}}
+	}
+
+	var errs []FieldError
+	if platform.Region == "" {
+		errs = append(errs, FieldError{Field: "platform.aws.region", Message: "is required"})
+	} else if lab != nil && platform.Region != lab.Region {
+		errs = append(errs, FieldError{Field: "platform.aws.region", Message: fmt.Sprintf("must be the region of the lab %s, not %s", lab.Region, platform.Region)})
+	}
+	if len(platform.Subnets) == 0 {
+		errs = append(errs, FieldError{Field: "platform.aws.subnets", Message: "is required, the cluster is installed in the private subnets of the lab"})
+	}
+	if lab == nil || len(platform.Subnets) == 0 || len(errs) > 0 {
+		return errs
+	}
+
+	subnets, err := describeSubnets(config, lab.Region, platform.Subnets)
+	if err != nil {
+		fmt.Printf("Cannot check the subnets of the install-config against the lab: %v\n", err)
+		return errs
+	}
+	var machineNetworks []*net.IPNet
+	if installConfig.Networking != nil {
+		for _, entry := range installConfig.Networking.MachineNetwork {
+			if _, network, err := net.ParseCIDR(entry.CIDR); err == nil {
+				machineNetworks = append(machineNetworks, network)
+			}
+		}
+	}
+	for i, id := range platform.Subnets {
+		field := fmt.Sprintf("platform.aws.subnets[%d]", i)
+		subnet, found := subnets[id]
+		if !found {
+			errs = append(errs, FieldError{Field: field, Message: fmt.Sprintf("subnet %s does not exist in %s", id, lab.Region)})
+			continue
+		}
+		if subnet.VpcId != lab.VPCID {
+			errs = append(errs, FieldError{Field: field, Message: fmt.Sprintf("subnet %s is in %s, not in the VPC of the lab %s", id, subnet.VpcId, lab.VPCID)})
+		}
+		if _, network, err := net.ParseCIDR(subnet.CidrBlock); err == nil && len(machineNetworks) > 0 && !containsNetwork(machineNetworks, network) {
+			errs = append(errs, FieldError{Field: field, Message: fmt.Sprintf("the CIDR %s of subnet %s is not in networking.machineNetwork", subnet.CidrBlock, id)})
+		}
+	}
+	return errs
+}
+
+func containsNetwork(networks []*net.IPNet, network *net.IPNet) bool {
+	ones, _ := network.Mask.Size()
+	for _, candidate := range networks {
+		candidateOnes, _ := candidate.Mask.Size()
+		if candidateOnes <= ones && candidate.Contains(network.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the minor of a version like 4.14.10.
+func minorVersion(version string) (int, bool) {
+	parts := strings.Split(version, ".")
+	if len(parts) < 2 || parts[0] != "4" {
+		return 0, false
+	}
+	minor, err := strconv.Atoi(parts[1])
+	return minor, err == nil
+}
+
+// Describes the subnets with the AWS CLI and the credentials of the cluster deployer. A subnet that does not exist is
+// missing from the result.
+func describeSubnets(c *Config, region string, ids []string) (map[string]AWSSubnet, error) {
+	subnets := map[string]AWSSubnet{}
+	for _, id := range ids {
+		// One subnet at a time: describing a subnet that does not exist fails for the whole list.
+		cmd := exec.Command("aws", "ec2", "describe-subnets", "--region", region, "--subnet-ids", id, "--output", "json")
+		cmd.Env = append(os.Environ(), "AWS_SHARED_CREDENTIALS_FILE="+c.AWSCredentialsFile)
+		stderr := &bytes.Buffer{}
+		cmd.Stderr = stderr
+		output, err := cmd.Output()
+		if err != nil && strings.Contains(stderr.String(), "InvalidSubnetID") {
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("%v: %s", err, strings.TrimSpace(stderr.String()))
+		}
+
+		var described struct{ Subnets []AWSSubnet }
+		if err := json.Unmarshal(output, &described); err != nil {
+			return nil, err
+		}
+		for _, subnet := range described.Subnets {
+			subnets[subnet.SubnetId] = subnet
+		}
+	}
+	return subnets, nil
+}
+
+var (
+	lab      *Lab
+	labMutex sync.Mutex
+)
+
+// Returns the lab the agent runs in. It is read from the instance metadata once, a failure is retried on the next call.
+func getLab(c *Config) (*Lab, error) {
+	labMutex.Lock()
+	defer labMutex.Unlock()
+	if lab != nil {
+		return lab, nil
+	}
+
+	client := &http.Client{Timeout: 2 * time.Second}
+	request, err := http.NewRequest(http.MethodPut, c.InstanceMetadataURL+"/latest/api/token", nil)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "60")
+	token, err := readMetadata(client, request)
+	if err != nil {
+		return nil, err
+	}
+	get := func(path string) (string, error) {
+		request, err := http.NewRequest(http.MethodGet, c.InstanceMetadataURL+"/latest/meta-data/"+path, nil)
+		if err != nil {
+			return "", err
+		}
+		request.Header.Set("X-aws-ec2-metadata-token", token)
+		return readMetadata(client, request)
+	}
+
+	found := &Lab{Region: c.Region}
+	if found.Region == "" {
+		if found.Region, err = get("placement/region"); err != nil {
+			return nil, err
+		}
+	}
+	mac, err := get("mac")
+	if err != nil {
+		return nil, err
+	}
+	if found.VPCID, err = get("network/interfaces/macs/" + mac + "/vpc-id"); err != nil {
+		return nil, err
+	}
+	lab = found
+	fmt.Printf("The lab is the VPC %s in %s\n", lab.VPCID, lab.Region)
+	return lab, nil
+}
+
+func readMetadata(client *http.Client, request *http.Request) (string, error) {
+	resp, err := client.Do(request)
+	if err != nil {
+		return "", fmt.Errorf("cannot read the instance metadata: %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("cannot read the instance metadata: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("the instance metadata %s replied %s", request.URL.Path, resp.Status)
+	}
+	return strings.TrimSpace(string(body)), nil
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// A single node install-config as the CLI sends it for a lab in eu-west-1.
+func validInstallConfig() InstallConfig {
+	masters, workers := int64(1), int64(0)
+	return InstallConfig{
+		APIVersion:   "v1",
+		BaseDomain:   "emea.aws.cee.support",
+		Metadata:     Metadata{Name: "disconnected-12345"},
+		ControlPlane: &MachinePool{Name: "master", Replicas: &masters},
+		Compute:      []MachinePool{{Name: "worker", Replicas: &workers}},
+		Networking: &Networking{
+			NetworkType:    "OVNKubernetes",
+			ClusterNetwork: []ClusterNetworkEntry{{CIDR: "10.128.0.0/14", HostPrefix: 23}},
+			MachineNetwork: []MachineNetworkEntry{{CIDR: "10.0.0.0/16"}},
+			ServiceNetwork: []string{"172.30.0.0/16"},
+		},
+		Platform: Platform{AWS: &AWSPlatform{Region: "eu-west-1", Subnets: []string{"subnet-1", "subnet-2", "subnet-3"}}},
+	}
+}
+
+func TestValidateInstallConfig(t *testing.T) {
+	replicas := func(n int64) *int64 { return &n }
+	tests := []struct {
+		name           string
+		edit           func(ic *InstallConfig)
+		clusterVersion string
+		withLab        bool
+		wantFields     []string
+	}{
+		{name: "valid", edit: func(ic *InstallConfig) {}},
+		{name: "apiVersion", edit: func(ic *InstallConfig) { ic.APIVersion = "v2" }, wantFields: []string{"apiVersion"}},
+		{name: "missing name", edit: func(ic *InstallConfig) { ic.Metadata.Name = "" }, wantFields: []string{"metadata.name"}},
+		{name: "name is not a DNS label", edit: func(ic *InstallConfig) { ic.Metadata.Name = "Lab_1" }, wantFields: []string{"metadata.name"}},
+		{name: "base domain", edit: func(ic *InstallConfig) { ic.BaseDomain = "example..com" }, wantFields: []string{"baseDomain"}},
+		{name: "two control plane nodes", edit: func(ic *InstallConfig) { ic.ControlPlane.Replicas = replicas(2) }, wantFields: []string{"controlPlane.replicas"}},
+		{
+			name:           "single node before 4.11",
+			edit:           func(ic *InstallConfig) {},
+			clusterVersion: "4.10.3",
+			wantFields:     []string{"controlPlane.replicas"},
+		},
+		{
+			name:           "five control plane nodes from 4.18",
+			edit:           func(ic *InstallConfig) { ic.ControlPlane.Replicas, ic.Compute[0].Replicas = replicas(5), replicas(3) },
+			clusterVersion: "4.18.5",
+		},
+		{
+			name:           "five control plane nodes before 4.18",
+			edit:           func(ic *InstallConfig) { ic.ControlPlane.Replicas, ic.Compute[0].Replicas = replicas(5), replicas(3) },
+			clusterVersion: "4.16.1",
+			wantFields:     []string{"controlPlane.replicas"},
+		},
+		{name: "workers on a single node", edit: func(ic *InstallConfig) { ic.Compute[0].Replicas = replicas(2) }, wantFields: []string{"compute[0].replicas"}},
+		{
+			name:       "negative workers",
+			edit:       func(ic *InstallConfig) { ic.ControlPlane.Replicas, ic.Compute[0].Replicas = replicas(3), replicas(-1) },
+			wantFields: []string{"compute[0].replicas"},
+		},
+		{
+			name:           "OpenShiftSDN up to 4.14",
+			edit:           func(ic *InstallConfig) { ic.Networking.NetworkType = "OpenShiftSDN" },
+			clusterVersion: "4.14.10",
+		},
+		{
+			name:           "OpenShiftSDN from 4.15",
+			edit:           func(ic *InstallConfig) { ic.Networking.NetworkType = "OpenShiftSDN" },
+			clusterVersion: "4.16.1",
+			wantFields:     []string{"networking.networkType"},
+		},
+		{name: "unknown network type", edit: func(ic *InstallConfig) { ic.Networking.NetworkType = "Calico" }, wantFields: []string{"networking.networkType"}},
+		{name: "missing networking", edit: func(ic *InstallConfig) { ic.Networking = nil }, wantFields: []string{"networking"}},
+		{
+			name:       "not a CIDR",
+			edit:       func(ic *InstallConfig) { ic.Networking.ServiceNetwork = []string{"172.30.0.0"} },
+			wantFields: []string{"networking.serviceNetwork[0]"},
+		},
+		{
+			name:       "hostPrefix does not fit",
+			edit:       func(ic *InstallConfig) { ic.Networking.ClusterNetwork[0].HostPrefix = 12 },
+			wantFields: []string{"networking.clusterNetwork[0].hostPrefix"},
+		},
+		{
+			name:       "overlapping networks",
+			edit:       func(ic *InstallConfig) { ic.Networking.ServiceNetwork = []string{"10.0.128.0/20"} },
+			wantFields: []string{"networking.machineNetwork[0].cidr"},
+		},
+		{name: "missing platform", edit: func(ic *InstallConfig) { ic.Platform.AWS = nil }, wantFields: []string{"platform.aws"}},
+		{name: "region of another lab", edit: func(ic *InstallConfig) { ic.Platform.AWS.Region = "us-east-1" }, withLab: true, wantFields: []string{"platform.aws.region"}},
+		{name: "missing subnets", edit: func(ic *InstallConfig) { ic.Platform.AWS.Subnets = nil }, wantFields: []string{"platform.aws.subnets"}},
+	}
+	// Without the lab the region and the subnets are not checked against AWS. With it the subnets are described only when
+	// the region is right, so no case reaches AWS.
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			installConfig := validInstallConfig()
+			test.edit(&installConfig)
+			body, err := json.Marshal(installConfig)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var labOfTest *Lab
+			if test.withLab {
+				labOfTest = &Lab{Region: "eu-west-1", VPCID: "vpc-1"}
+			}
+			var fields []string
+			for _, fieldError := range validateInstallConfig(body, test.clusterVersion, labOfTest) {
+				fields = append(fields, fieldError.Field)
+			}
+			if !reflect.DeepEqual(fields, test.wantFields) {
+				t.Errorf("fields = %v, want %v", fields, test.wantFields)
+			}
+		})
+	}
+}
+
+func TestValidateInstallConfigTypeError(t *testing.T) {
+	errs := validateInstallConfig([]byte(`{"apiVersion": "v1", "controlPlane": {"replicas": "three"}}`), "", nil)
+	if len(errs) != 1 || errs[0].Field != "controlPlane.replicas" {
+		t.Errorf("validateInstallConfig() = %v, want one error on controlPlane.replicas", errs)
+	}
+}
+
+func TestInstallConfigHandler(t *testing.T) {
+	c := useTestConfig(t)
+	resetJobs(t)
+	labMutex.Lock()
+	lab = &Lab{Region: "eu-west-1", VPCID: "vpc-1"}
+	labMutex.Unlock()
+	t.Cleanup(func() {
+		labMutex.Lock()
+		lab = nil
+		labMutex.Unlock()
+	})
+
+	installConfig := validInstallConfig()
+	installConfig.Networking.NetworkType = "OpenShiftSDN"
+	installConfig.Platform.AWS.Region = "us-east-1"
+	body, err := json.Marshal(installConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	installConfigHandler(w, httptest.NewRequest(http.MethodPost, "/data?clusterVersion=4.16.1", strings.NewReader(string(body))))
+
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("status code = %d, want %d: %s", w.Code, http.StatusUnprocessableEntity, w.Body.String())
+	}
+	var rejected InstallConfigErrors
+	if err := json.Unmarshal(w.Body.Bytes(), &rejected); err != nil {
+		t.Fatal(err)
+	}
+	var fields []string
+	for _, fieldError := range rejected.Errors {
+		fields = append(fields, fieldError.Field)
+	}
+	if want := []string{"networking.networkType", "platform.aws.region"}; !reflect.DeepEqual(fields, want) {
+		t.Errorf("fields = %v, want %v", fields, want)
+	}
+	if _, err := os.Stat(filepath.Join(c.InstallDir, "install-config.yaml")); !os.IsNotExist(err) {
+		t.Errorf("a rejected install-config was written: %v", err)
+	}
+}
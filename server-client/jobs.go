@@ -0,0 +1,369 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// The phases of a job.
+const (
+	jobRunning   = "Running"
+	jobSucceeded = "Succeeded"
+	jobFailed    = "Failed"
+	jobCancelled = "Cancelled"
+)
+
+// How long the scripts of a cancelled job get to stop after SIGTERM before they are killed.
+const cancelGracePeriod = 30 * time.Second
+
+// An install or destroy of the cluster requested by the client. Only one job can run at a time.
+type Job struct {
+	ID                string
+	Action            string
+	ClusterVersion    string
+	Phase             string
+	StartedAt         time.Time
+	EndedAt           *time.Time `json:",omitempty"`
+	CancelRequestedAt *time.Time `json:",omitempty"`
+	ExitStatus        *int       `json:",omitempty"`
+	Error             string     `json:",omitempty"`
+}
+
+// Returned when a job is requested while another one is still running.
+type jobConflictError struct {
+	running Job
+}
+
+func (e *jobConflictError) Error() string {
+	return fmt.Sprintf("job %s (%s) is still running", e.running.ID, e.running.Action)
+}
+
+// Returned when a job that already ended is cancelled.
+type jobNotRunningError struct {
+	job Job
+}
+
+func (e *jobNotRunningError) Error() string {
+	return fmt.Sprintf("job %s is not running. Its phase is %s", e.job.ID, e.job.Phase)
+}
+
+var errJobCancelled = errors.New("the job was cancelled")
+
+var (
+	jobs       = map[string]*Job{}
+	jobOrder   []string
+	runningJob *Job
+	// The script the running job is executing. A cancel stops its process group.
+	runningCmd *exec.Cmd
+	jobsMutex  sync.Mutex
+)
+
+// Creates a job for the action and runs it in the background. It fails if the action is not valid or another job is running.
+// A copy of the job is returned so it can be encoded without holding the lock.
+func startJob(action DeployDestroy) (Job, error) {
+	if action.Deploy == "Install" && len(action.ClusterVersion) == 0 {
+		return Job{}, fmt.Errorf("a cluster version is required to install a cluster")
+	} else if action.Deploy != "Install" && action.Deploy != "Destroy" {
+		return Job{}, fmt.Errorf("invalid action %q. One of: Install, Destroy", action.Deploy)
+	}
+
+	id, err := newJobID()
+	if err != nil {
+		return Job{}, err
+	}
+
+	job, err := addJob(id, action)
+	if err != nil {
+		return Job{}, err
+	}
+	// The job is saved before it runs so a restart of the agent does not forget it.
+	rememberAction(action)
+
+	fmt.Printf("Starting job %s. Action is: %s and Version is: %s\n", job.ID, job.Action, job.ClusterVersion)
+	go runJob(job.ID, job.Action, job.ClusterVersion)
+	return job, nil
+}
+
+// Adds the job as the running one unless another job is running.
+func addJob(id string, action DeployDestroy) (Job, error) {
+	jobsMutex.Lock()
+	defer jobsMutex.Unlock()
+
+	if runningJob != nil {
+		return Job{}, &jobConflictError{running: *runningJob}
+	}
+
+	job := &Job{
+		ID:             id,
+		Action:         action.Deploy,
+		ClusterVersion: action.ClusterVersion,
+		Phase:          jobRunning,
+		StartedAt:      time.Now(),
+	}
+	jobs[job.ID] = job
+	jobOrder = append(jobOrder, job.ID)
+	runningJob = job
+	return *job, nil
+}
+
+func newJobID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("cannot generate the job id: %v", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Runs the install or destroy of the cluster and records how it ended. The output is kept in the log file of the job.
+func runJob(id string, action string, clusterVersion string) {
+	var out io.Writer = os.Stdout
+	logFile, err := createJobLog(id)
+	if err != nil {
+		fmt.Printf("Cannot create the log file of job %s: %v\n", id, err)
+	} else {
+		defer logFile.Close()
+		out = io.MultiWriter(os.Stdout, logFile)
+	}
+
+	if action == "Install" {
+		if err = populateVersionToInstallerScript(clusterVersion); err == nil {
+			err = installCluster(out)
+		}
+	} else {
+		err = destroyCluster(out)
+	}
+	if err != nil {
+		fmt.Fprintf(out, "Job %s failed: %v\n", id, err)
+	}
+	finishJob(id, err)
+}
+
+func finishJob(id string, err error) {
+	endJob(id, err)
+	saveState()
+}
+
+func endJob(id string, err error) {
+	jobsMutex.Lock()
+	defer jobsMutex.Unlock()
+
+	job := jobs[id]
+	endedAt := time.Now()
+	exitStatus := 0
+	job.EndedAt = &endedAt
+	job.Phase = jobSucceeded
+	if err != nil {
+		job.Phase = jobFailed
+		job.Error = err.Error()
+		exitStatus = 1
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			exitStatus = exitErr.ExitCode()
+		}
+	}
+	if job.CancelRequestedAt != nil {
+		job.Phase = jobCancelled
+		job.Error = errJobCancelled.Error()
+	}
+	job.ExitStatus = &exitStatus
+	runningJob = nil
+	fmt.Printf("Job %s finished. Phase is: %s\n", job.ID, job.Phase)
+}
+
+// Runs a script of the running job in its own process group so a cancel stops the script and every process it started,
+// like openshift-install and its terraform. A script of a job cancelled before it started is not run.
+func runJobCommand(cmd *exec.Cmd) error {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	jobsMutex.Lock()
+	if runningJob != nil && runningJob.CancelRequestedAt != nil {
+		jobsMutex.Unlock()
+		return errJobCancelled
+	}
+	if err := cmd.Start(); err != nil {
+		jobsMutex.Unlock()
+		return err
+	}
+	runningCmd = cmd
+	jobsMutex.Unlock()
+
+	err := cmd.Wait()
+
+	jobsMutex.Lock()
+	defer jobsMutex.Unlock()
+	runningCmd = nil
+	if runningJob != nil && runningJob.CancelRequestedAt != nil {
+		return errJobCancelled
+	}
+	return err
+}
+
+// Asks the running job to stop. Its scripts get SIGTERM and, if they are still running after the grace period, SIGKILL.
+// The job ends as Cancelled once its script exited. Cancelling twice only signals the scripts again.
+func cancelJob(id string) (Job, error) {
+	jobsMutex.Lock()
+	defer jobsMutex.Unlock()
+
+	job := jobs[id]
+	if job.Phase != jobRunning {
+		return Job{}, &jobNotRunningError{job: *job}
+	}
+	if job.CancelRequestedAt == nil {
+		now := time.Now()
+		job.CancelRequestedAt = &now
+	}
+	fmt.Printf("Cancelling job %s\n", job.ID)
+
+	if cmd := runningCmd; cmd != nil {
+		// The process group id is the pid of the script since it was started with Setpgid.
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGTERM)
+		go func() {
+			time.Sleep(cancelGracePeriod)
+			jobsMutex.Lock()
+			defer jobsMutex.Unlock()
+			if runningCmd == cmd {
+				fmt.Printf("Job %s did not stop within %v, killing it\n", id, cancelGracePeriod)
+				syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+			}
+		}()
+	}
+	return *job, nil
+}
+
+// Returns a copy of the job with the given id.
+func getJob(id string) (Job, bool) {
+	jobsMutex.Lock()
+	defer jobsMutex.Unlock()
+
+	job, ok := jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return *job, true
+}
+
+// Returns a copy of every job, the oldest first.
+func listJobs() []Job {
+	jobsMutex.Lock()
+	defer jobsMutex.Unlock()
+
+	list := []Job{}
+	for _, id := range jobOrder {
+		list = append(list, *jobs[id])
+	}
+	return list
+}
+
+// ======================================================================================
+// These are the HTTP handlers for requests comming on path /v1/jobs and /v1/jobs/{id}[/logs]
+// ======================================================================================
+
+func jobsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, listJobs())
+	case http.MethodPost:
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "Unable to read request body", http.StatusBadRequest)
+			return
+		}
+		defer r.Body.Close()
+
+		var action DeployDestroy
+		if err := json.Unmarshal(body, &action); err != nil {
+			http.Error(w, "Invalid JSON data", http.StatusBadRequest)
+			return
+		}
+
+		job, err := startJob(action)
+		if conflict, ok := err.(*jobConflictError); ok {
+			// The running job is returned so the client can tell the user what to wait for.
+			writeJSON(w, http.StatusConflict, conflict.running)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, http.StatusCreated, job)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func jobHandler(w http.ResponseWriter, r *http.Request) {
+	id, subPath, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/v1/jobs/"), "/")
+	if subPath == "logs" {
+		jobLogsHandler(w, r, id)
+		return
+	} else if subPath == "cancel" {
+		jobCancelHandler(w, r, id)
+		return
+	} else if len(subPath) > 0 {
+		http.NotFound(w, r)
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	job, ok := getJob(id)
+	if !ok {
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, job)
+}
+
+// ======================================================================================
+// This is the HTTP handler for requests comming on path /v1/jobs/{id}/cancel
+// It replies 202 with the job once the cancel is requested. The job is Cancelled when its scripts exited.
+// ======================================================================================
+
+func jobCancelHandler(w http.ResponseWriter, r *http.Request, id string) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if _, ok := getJob(id); !ok {
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return
+	}
+
+	job, err := cancelJob(id)
+	if notRunning, ok := err.(*jobNotRunningError); ok {
+		writeJSON(w, http.StatusConflict, notRunning.job)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	saveState()
+	writeJSON(w, http.StatusAccepted, job)
+}
+
+func writeJSON(w http.ResponseWriter, statusCode int, value interface{}) {
+	jsonData, err := json.Marshal(value)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	w.Write(jsonData)
+}
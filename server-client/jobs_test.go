@@ -0,0 +1,141 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// Forgets the jobs and what the agent remembered, as after a restart without a state file.
+func resetJobs(t *testing.T) {
+	t.Helper()
+	reset := func() {
+		jobsMutex.Lock()
+		jobs, jobOrder, runningJob, runningCmd = map[string]*Job{}, nil, nil, nil
+		jobsMutex.Unlock()
+
+		stateMutex.Lock()
+		persisted.lastAction, persisted.installConfig, persisted.installConfigReceivedAt = nil, nil, nil
+		persisted.clusterFiles, persisted.clusterFilesKnown = nil, false
+		stateMutex.Unlock()
+	}
+	reset()
+	t.Cleanup(reset)
+}
+
+func TestAddJobConflict(t *testing.T) {
+	useTestConfig(t)
+	resetJobs(t)
+
+	first, err := addJob("0000000000000001", DeployDestroy{Deploy: "Install", ClusterVersion: "4.14.10"})
+	if err != nil {
+		t.Fatalf("addJob() error = %v", err)
+	}
+	if first.Phase != jobRunning {
+		t.Errorf("phase = %s, want %s", first.Phase, jobRunning)
+	}
+
+	_, err = addJob("0000000000000002", DeployDestroy{Deploy: "Destroy"})
+	conflict, ok := err.(*jobConflictError)
+	if !ok {
+		t.Fatalf("addJob() while a job runs error = %v, want a jobConflictError", err)
+	}
+	if conflict.running.ID != first.ID {
+		t.Errorf("conflicting job = %s, want %s", conflict.running.ID, first.ID)
+	}
+	if _, found := getJob("0000000000000002"); found {
+		t.Error("the refused job was recorded")
+	}
+
+	// The handlers answer 409 to the CLI.
+	for _, handler := range []http.HandlerFunc{deployDestroyHandler, jobsHandler} {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest(http.MethodPost, "/v1/jobs", strings.NewReader(`{"Deploy":"Destroy"}`)))
+		if w.Code != http.StatusConflict {
+			t.Errorf("status code = %d, want %d: %s", w.Code, http.StatusConflict, w.Body.String())
+		}
+	}
+
+	endJob(first.ID, nil)
+	if _, err := addJob("0000000000000003", DeployDestroy{Deploy: "Destroy"}); err != nil {
+		t.Errorf("addJob() after the job ended error = %v", err)
+	}
+}
+
+func TestStartJobRejectsInvalidActions(t *testing.T) {
+	useTestConfig(t)
+	resetJobs(t)
+
+	for _, action := range []DeployDestroy{{Deploy: "Install"}, {Deploy: "Upgrade"}, {}} {
+		if _, err := startJob(action); err == nil {
+			t.Errorf("startJob(%+v) succeeded, want an error", action)
+		}
+	}
+	if len(listJobs()) != 0 {
+		t.Errorf("jobs = %v, want none", listJobs())
+	}
+}
+
+func TestCancelStopsTheScripts(t *testing.T) {
+	c := useTestConfig(t)
+	resetJobs(t)
+
+	// The script starts a child like openshift-install does. The cancel must stop both.
+	pidFile := filepath.Join(t.TempDir(), "child.pid")
+	writeTestFile(t, c.ScriptTemplate, "#!/bin/bash\nsleep 60 &\necho $! > "+pidFile+"\nwait\n")
+
+	job, err := startJob(DeployDestroy{Deploy: "Install", ClusterVersion: "4.14.10"})
+	if err != nil {
+		t.Fatalf("startJob() error = %v", err)
+	}
+	var child int
+	waitUntil(t, "the script started its child", func() bool {
+		data, err := os.ReadFile(pidFile)
+		if err != nil {
+			return false
+		}
+		child, err = strconv.Atoi(strings.TrimSpace(string(data)))
+		return err == nil
+	})
+
+	if _, err := cancelJob(job.ID); err != nil {
+		t.Fatalf("cancelJob() error = %v", err)
+	}
+	waitUntil(t, "the job was cancelled", func() bool {
+		job, _ = getJob(job.ID)
+		return job.Phase != jobRunning
+	})
+	if job.Phase != jobCancelled || job.ExitStatus == nil {
+		t.Errorf("job = %+v, want Cancelled with an exit status", job)
+	}
+	waitUntil(t, "the child of the script stopped", func() bool {
+		return syscall.Kill(child, 0) != nil
+	})
+
+	if _, err := cancelJob(job.ID); err == nil {
+		t.Error("cancelJob() of an ended job succeeded, want a jobNotRunningError")
+	}
+}
+
+func waitUntil(t *testing.T, what string, done func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+		if done() {
+			return
+		}
+	}
+	t.Fatalf("timed out waiting until %s", what)
+}
+
+func writeTestFile(t *testing.T, path string, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// How often a followed log is checked for new lines.
+var logPollInterval = 1 * time.Second
+
+// Every job writes the output of openshift-install and of the scripts it runs in its own file under the job log directory.
+func jobLogPath(id string) string {
+	return filepath.Join(config.JobLogDir, id+".log")
+}
+
+// Creates the log file of a job. The output goes also to the stdout of the container as before.
+func createJobLog(id string) (*os.File, error) {
+	if err := os.MkdirAll(config.JobLogDir, 0755); err != nil {
+		return nil, err
+	}
+	return os.Create(jobLogPath(id))
+}
+
+// ======================================================================================
+// This is the HTTP handler for requests comming on path /v1/jobs/{id}/logs
+// It sends the output of the job, or with source=installer the .openshift_install.log, using chunked HTTP.
+// With follow=true the connection is kept open and new lines are sent as they are written until the job finishes.
+// ======================================================================================
+
+func jobLogsHandler(w http.ResponseWriter, r *http.Request, id string) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if _, ok := getJob(id); !ok {
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return
+	}
+
+	path := jobLogPath(id)
+	switch r.URL.Query().Get("source") {
+	case "", "job":
+	case "installer":
+		path = config.installerLog()
+	default:
+		http.Error(w, "Invalid source. One of: job, installer", http.StatusBadRequest)
+		return
+	}
+	follow := r.URL.Query().Get("follow") == "true"
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusOK)
+
+	jobFinished := func() bool {
+		job, _ := getJob(id)
+		return job.EndedAt != nil
+	}
+	if err := streamFile(w, r, path, follow, jobFinished); err != nil {
+		fmt.Printf("Streaming %s for job %s stopped: %v\n", path, id, err)
+	}
+}
+
+// Writes the file to the response. If follow is set it keeps sending what is appended to the file until done returns true
+// or the client goes away. A file that does not exist yet is waited for, since the installer creates its log after it starts.
+func streamFile(w http.ResponseWriter, r *http.Request, path string, follow bool, done func() bool) error {
+	flusher, _ := w.(http.Flusher)
+	var file *os.File
+	defer func() {
+		if file != nil {
+			file.Close()
+		}
+	}()
+
+	for {
+		// Checked before reading so the lines written right before the job finished are still sent.
+		finished := done()
+
+		if file == nil {
+			var err error
+			file, err = os.Open(path)
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		if file != nil {
+			if _, err := io.Copy(w, file); err != nil {
+				return err
+			}
+		}
+		// Flushed even before the file exists so the client gets the headers instead of waiting for them.
+		if flusher != nil {
+			flusher.Flush()
+		}
+
+		if !follow || finished {
+			return nil
+		}
+
+		select {
+		case <-r.Context().Done():
+			return r.Context().Err()
+		case <-time.After(logPollInterval):
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJobLogsHandler(t *testing.T) {
+	c := useTestConfig(t)
+	resetJobs(t)
+	job, err := addJob("0123456789abcdef", DeployDestroy{Deploy: "Install", ClusterVersion: "4.14.10"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	endJob(job.ID, nil)
+	writeTestFile(t, jobLogPath(job.ID), "Mirroring the release\n")
+	writeTestFile(t, c.installerLog(), `time="2024-05-01T10:55:00Z" level=info msg="Install complete!"`+"\n")
+
+	tests := []struct {
+		name     string
+		method   string
+		id       string
+		query    string
+		wantCode int
+		wantBody string
+	}{
+		{name: "job output", id: job.ID, wantCode: http.StatusOK, wantBody: "Mirroring the release\n"},
+		{name: "job output by name", id: job.ID, query: "?source=job", wantCode: http.StatusOK, wantBody: "Mirroring the release\n"},
+		{name: "installer log", id: job.ID, query: "?source=installer", wantCode: http.StatusOK, wantBody: `time="2024-05-01T10:55:00Z" level=info msg="Install complete!"` + "\n"},
+		{name: "an ended job is not followed", id: job.ID, query: "?follow=true", wantCode: http.StatusOK, wantBody: "Mirroring the release\n"},
+		{name: "unknown source", id: job.ID, query: "?source=agent", wantCode: http.StatusBadRequest},
+		{name: "unknown job", id: "fedcba9876543210", wantCode: http.StatusNotFound},
+		{name: "not a GET", method: http.MethodDelete, id: job.ID, wantCode: http.StatusMethodNotAllowed},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			method := test.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			w := httptest.NewRecorder()
+			jobLogsHandler(w, httptest.NewRequest(method, "/v1/jobs/"+test.id+"/logs"+test.query, nil), test.id)
+
+			if w.Code != test.wantCode {
+				t.Errorf("status code = %d, want %d: %s", w.Code, test.wantCode, w.Body.String())
+			}
+			if test.wantBody != "" && w.Body.String() != test.wantBody {
+				t.Errorf("body = %q, want %q", w.Body.String(), test.wantBody)
+			}
+		})
+	}
+}
+
+// A followed log sends the lines as they are written and ends once the job ended.
+func TestJobLogsFollow(t *testing.T) {
+	useTestConfig(t)
+	resetJobs(t)
+	previous := logPollInterval
+	logPollInterval = 10 * time.Millisecond
+	t.Cleanup(func() { logPollInterval = previous })
+
+	job, err := addJob("0123456789abcdef", DeployDestroy{Deploy: "Install", ClusterVersion: "4.14.10"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	log, err := createJobLog(job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer log.Close()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		jobLogsHandler(w, r, job.ID)
+	}))
+	defer server.Close()
+	resp, err := http.Get(server.URL + "/v1/jobs/" + job.ID + "/logs?follow=true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	reader := bufio.NewReader(resp.Body)
+
+	for _, line := range []string{"Mirroring the release\n", "Running openshift-install\n"} {
+		if _, err := log.WriteString(line); err != nil {
+			t.Fatal(err)
+		}
+		if got, err := reader.ReadString('\n'); err != nil || got != line {
+			t.Fatalf("followed line = %q, %v, want %q", got, err, line)
+		}
+	}
+
+	// The last line is written right before the job ends and must still be sent.
+	if _, err := log.WriteString("Install complete\n"); err != nil {
+		t.Fatal(err)
+	}
+	endJob(job.ID, nil)
+	done := make(chan string)
+	go func() {
+		rest, _ := io.ReadAll(reader)
+		done <- string(rest)
+	}()
+	select {
+	case rest := <-done:
+		if rest != "Install complete\n" {
+			t.Errorf("rest of the log = %q, want the last line", rest)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("the followed log did not end with the job")
+	}
+}
+
+// The installer log does not exist until openshift-install starts. Following it waits for the file.
+func TestJobLogsFollowInstallerLogNotCreatedYet(t *testing.T) {
+	c := useTestConfig(t)
+	resetJobs(t)
+	previous := logPollInterval
+	logPollInterval = 10 * time.Millisecond
+	t.Cleanup(func() { logPollInterval = previous })
+
+	job, err := addJob("0123456789abcdef", DeployDestroy{Deploy: "Install", ClusterVersion: "4.14.10"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		jobLogsHandler(w, r, job.ID)
+	}))
+	defer server.Close()
+	resp, err := http.Get(server.URL + "/v1/jobs/" + job.ID + "/logs?follow=true&source=installer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	line := `time="2024-05-01T10:00:00Z" level=info msg="Consuming Install Config from target directory"` + "\n"
+	if err := os.WriteFile(c.installerLog(), []byte(line), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := bufio.NewReader(resp.Body).ReadString('\n'); err != nil || !strings.Contains(got, "Consuming Install Config") {
+		t.Errorf("followed installer line = %q, %v, want %q", got, err, line)
+	}
+	endJob(job.ID, nil)
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The buckets of the histograms in seconds. The registry checks on localhost take milliseconds, a job tens of minutes.
+var (
+	probeBuckets       = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+	jobDurationBuckets = []float64{60, 300, 600, 1200, 1800, 2700, 3600, 5400, 7200}
+)
+
+// The readiness fails when a monitor did not report for this long. Both monitors run every 5 seconds.
+const monitorStaleAfter = 1 * time.Minute
+
+// A Prometheus histogram without labels.
+type histogram struct {
+	buckets []float64
+	counts  []uint64
+	sum     float64
+	count   uint64
+}
+
+func newHistogram(buckets []float64) *histogram {
+	return &histogram{buckets: buckets, counts: make([]uint64, len(buckets))}
+}
+
+func (h *histogram) observe(value float64) {
+	for i, bound := range h.buckets {
+		if value <= bound {
+			h.counts[i]++
+		}
+	}
+	h.sum += value
+	h.count++
+}
+
+// Writes the bucket, sum and count lines. The labels, if any, are added to every line.
+func (h *histogram) write(b *strings.Builder, name string, labels string) {
+	separator := ""
+	if labels != "" {
+		separator = ","
+	}
+	for i, bound := range h.buckets {
+		fmt.Fprintf(b, "%s_bucket{%s%sle=\"%g\"} %d\n", name, labels, separator, bound, h.counts[i])
+	}
+	fmt.Fprintf(b, "%s_bucket{%s%sle=\"+Inf\"} %d\n", name, labels, separator, h.count)
+	fmt.Fprintf(b, "%s_sum%s %g\n", name, braces(labels), h.sum)
+	fmt.Fprintf(b, "%s_count%s %d\n", name, braces(labels), h.count)
+}
+
+func braces(labels string) string {
+	if labels == "" {
+		return ""
+	}
+	return "{" + labels + "}"
+}
+
+// What the monitors and the handlers record for /metrics and /readyz.
+var (
+	metricsMutex      sync.Mutex
+	registryProbe     = newHistogram(probeBuckets)
+	registryFailures  uint64
+	lastRegistryProbe time.Time
+	lastClusterCheck  time.Time
+	requestCounts     = map[requestKey]uint64{}
+)
+
+type requestKey struct {
+	handler    string
+	method     string
+	statusCode int
+}
+
+// Called by monitorRegistry after every run of the registry checks.
+func observeRegistryProbe(duration time.Duration, healthy bool) {
+	metricsMutex.Lock()
+	defer metricsMutex.Unlock()
+
+	registryProbe.observe(duration.Seconds())
+	if !healthy {
+		registryFailures++
+	}
+	lastRegistryProbe = time.Now()
+}
+
+// Called by monitorClusterInstallation after every check of the installation directory.
+func observeClusterCheck() {
+	metricsMutex.Lock()
+	defer metricsMutex.Unlock()
+
+	lastClusterCheck = time.Now()
+}
+
+// Counts the requests per handler, method and status code.
+func withMetrics(handler string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		recorder := &statusRecorder{ResponseWriter: w, statusCode: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+
+		metricsMutex.Lock()
+		defer metricsMutex.Unlock()
+		requestCounts[requestKey{handler: handler, method: r.Method, statusCode: recorder.statusCode}]++
+	}
+}
+
+// ======================================================================================
+// These are the HTTP handlers for requests comming on path /healthz, /readyz and /metrics
+// They need no client certificate so load balancers and Prometheus can reach them. They expose no secrets.
+// ======================================================================================
+
+// The agent is alive as long as it answers.
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte("ok\n"))
+}
+
+// The agent is ready once both monitors reported recently, so /status reflects the registry and the cluster.
+func readyzHandler(w http.ResponseWriter, r *http.Request) {
+	metricsMutex.Lock()
+	registryProbedAt, clusterCheckedAt := lastRegistryProbe, lastClusterCheck
+	metricsMutex.Unlock()
+
+	var problems []string
+	if time.Since(registryProbedAt) > monitorStaleAfter {
+		problems = append(problems, "the registry monitor has not reported")
+	}
+	if time.Since(clusterCheckedAt) > monitorStaleAfter {
+		problems = append(problems, "the cluster monitor has not reported")
+	}
+	if len(problems) > 0 {
+		http.Error(w, strings.Join(problems, "\n"), http.StatusServiceUnavailable)
+		return
+	}
+	w.Write([]byte("ok\n"))
+}
+
+// Writes the metrics in the Prometheus text format.
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	b := &strings.Builder{}
+
+	b.WriteString("# HELP ocpd_agent_registry_healthy Whether every critical registry check passed in the last run.\n")
+	b.WriteString("# TYPE ocpd_agent_registry_healthy gauge\n")
+	fmt.Fprintf(b, "ocpd_agent_registry_healthy %d\n", boolMetric(getHealthStatus()))
+
+	b.WriteString("# HELP ocpd_agent_registry_check_passed Whether the last run of each registry check passed.\n")
+	b.WriteString("# TYPE ocpd_agent_registry_check_passed gauge\n")
+	for _, result := range getRegistryChecks() {
+		fmt.Fprintf(b, "ocpd_agent_registry_check_passed{check=%q,critical=\"%t\"} %d\n", result.Name, result.Critical, boolMetric(result.Passed))
+	}
+
+	b.WriteString("# HELP ocpd_agent_cluster_present Whether a cluster installation is present in the installation directory.\n")
+	b.WriteString("# TYPE ocpd_agent_cluster_present gauge\n")
+	fmt.Fprintf(b, "ocpd_agent_cluster_present %d\n", boolMetric(getClusterStatus()))
+
+	metricsMutex.Lock()
+	b.WriteString("# HELP ocpd_agent_registry_probe_duration_seconds How long a run of the registry checks took.\n")
+	b.WriteString("# TYPE ocpd_agent_registry_probe_duration_seconds histogram\n")
+	registryProbe.write(b, "ocpd_agent_registry_probe_duration_seconds", "")
+
+	b.WriteString("# HELP ocpd_agent_registry_probe_failures_total The runs of the registry checks where a critical check failed.\n")
+	b.WriteString("# TYPE ocpd_agent_registry_probe_failures_total counter\n")
+	fmt.Fprintf(b, "ocpd_agent_registry_probe_failures_total %d\n", registryFailures)
+
+	b.WriteString("# HELP ocpd_agent_registry_last_probe_timestamp_seconds When the registry checks last ran.\n")
+	b.WriteString("# TYPE ocpd_agent_registry_last_probe_timestamp_seconds gauge\n")
+	fmt.Fprintf(b, "ocpd_agent_registry_last_probe_timestamp_seconds %d\n", unixOrZero(lastRegistryProbe))
+
+	b.WriteString("# HELP ocpd_agent_http_requests_total The requests served per handler, method and status code.\n")
+	b.WriteString("# TYPE ocpd_agent_http_requests_total counter\n")
+	keys := make([]requestKey, 0, len(requestCounts))
+	for key := range requestCounts {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].handler != keys[j].handler {
+			return keys[i].handler < keys[j].handler
+		}
+		if keys[i].method != keys[j].method {
+			return keys[i].method < keys[j].method
+		}
+		return keys[i].statusCode < keys[j].statusCode
+	})
+	for _, key := range keys {
+		fmt.Fprintf(b, "ocpd_agent_http_requests_total{handler=%q,method=%q,code=\"%d\"} %d\n", key.handler, key.method, key.statusCode, requestCounts[key])
+	}
+	metricsMutex.Unlock()
+
+	writeJobMetrics(b, listJobs())
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write([]byte(b.String()))
+}
+
+// The phase of the latest job as one series per phase set to 1 for the current one, and the durations of the finished jobs.
+func writeJobMetrics(b *strings.Builder, allJobs []Job) {
+	b.WriteString("# HELP ocpd_agent_job_phase The phase of the latest job. The series of its phase is 1.\n")
+	b.WriteString("# TYPE ocpd_agent_job_phase gauge\n")
+	if len(allJobs) > 0 {
+		latest := allJobs[len(allJobs)-1]
+		for _, phase := range []string{jobRunning, jobSucceeded, jobFailed, jobCancelled} {
+			fmt.Fprintf(b, "ocpd_agent_job_phase{action=%q,phase=%q} %d\n", latest.Action, phase, boolMetric(latest.Phase == phase))
+		}
+	}
+
+	b.WriteString("# HELP ocpd_agent_jobs_running The number of jobs running.\n")
+	b.WriteString("# TYPE ocpd_agent_jobs_running gauge\n")
+	running := 0
+	for _, job := range allJobs {
+		if job.Phase == jobRunning {
+			running++
+		}
+	}
+	fmt.Fprintf(b, "ocpd_agent_jobs_running %d\n", running)
+
+	b.WriteString("# HELP ocpd_agent_job_duration_seconds How long the finished jobs took per action and phase.\n")
+	b.WriteString("# TYPE ocpd_agent_job_duration_seconds histogram\n")
+	durations := map[[2]string]*histogram{}
+	for _, job := range allJobs {
+		if job.EndedAt == nil {
+			continue
+		}
+		key := [2]string{job.Action, job.Phase}
+		if durations[key] == nil {
+			durations[key] = newHistogram(jobDurationBuckets)
+		}
+		durations[key].observe(job.EndedAt.Sub(job.StartedAt).Seconds())
+	}
+	for _, action := range []string{"Install", "Destroy"} {
+		for _, phase := range []string{jobSucceeded, jobFailed, jobCancelled} {
+			if h := durations[[2]string{action, phase}]; h != nil {
+				h.write(b, "ocpd_agent_job_duration_seconds", fmt.Sprintf("action=%q,phase=%q", action, phase))
+			}
+		}
+	}
+}
+
+func boolMetric(value bool) int {
+	if value {
+		return 1
+	}
+	return 0
+}
+
+func unixOrZero(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
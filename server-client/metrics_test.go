@@ -0,0 +1,236 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Forgets what the monitors and the handlers recorded, as when the agent starts.
+func resetMetrics(t *testing.T) {
+	t.Helper()
+	reset := func() {
+		metricsMutex.Lock()
+		registryProbe, registryFailures = newHistogram(probeBuckets), 0
+		lastRegistryProbe, lastClusterCheck = time.Time{}, time.Time{}
+		requestCounts = map[requestKey]uint64{}
+		metricsMutex.Unlock()
+	}
+	reset()
+	t.Cleanup(reset)
+}
+
+func scrapeMetrics(t *testing.T) string {
+	t.Helper()
+	w := httptest.NewRecorder()
+	metricsHandler(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status code = %d, want %d", w.Code, http.StatusOK)
+	}
+	if contentType := w.Header().Get("Content-Type"); !strings.HasPrefix(contentType, "text/plain; version=0.0.4") {
+		t.Errorf("content type = %q, want the Prometheus text format", contentType)
+	}
+	return w.Body.String()
+}
+
+func checkMetricLines(t *testing.T, metrics string, want ...string) {
+	t.Helper()
+	lines := strings.Split(metrics, "\n")
+	for _, line := range want {
+		if !contains(lines, line) {
+			t.Errorf("metrics do not have the line %q:\n%s", line, metrics)
+		}
+	}
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func TestHealthz(t *testing.T) {
+	resetMetrics(t)
+	w := httptest.NewRecorder()
+	healthzHandler(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if w.Code != http.StatusOK || w.Body.String() != "ok\n" {
+		t.Errorf("healthz = %d %q, want 200 ok, even before the monitors reported", w.Code, w.Body.String())
+	}
+}
+
+func TestReadyz(t *testing.T) {
+	tests := []struct {
+		name             string
+		registryProbedAt time.Duration
+		clusterCheckedAt time.Duration
+		wantCode         int
+		wantBody         []string
+	}{
+		{
+			name:     "the monitors have not reported yet",
+			wantCode: http.StatusServiceUnavailable,
+			wantBody: []string{"the registry monitor has not reported", "the cluster monitor has not reported"},
+		},
+		{
+			name:             "only the registry monitor reported",
+			registryProbedAt: 5 * time.Second,
+			wantCode:         http.StatusServiceUnavailable,
+			wantBody:         []string{"the cluster monitor has not reported"},
+		},
+		{
+			name:             "the registry monitor stopped reporting",
+			registryProbedAt: 2 * monitorStaleAfter,
+			clusterCheckedAt: 5 * time.Second,
+			wantCode:         http.StatusServiceUnavailable,
+			wantBody:         []string{"the registry monitor has not reported"},
+		},
+		{
+			name:             "both monitors reported",
+			registryProbedAt: 5 * time.Second,
+			clusterCheckedAt: 5 * time.Second,
+			wantCode:         http.StatusOK,
+			wantBody:         []string{"ok"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resetMetrics(t)
+			metricsMutex.Lock()
+			if test.registryProbedAt > 0 {
+				lastRegistryProbe = time.Now().Add(-test.registryProbedAt)
+			}
+			if test.clusterCheckedAt > 0 {
+				lastClusterCheck = time.Now().Add(-test.clusterCheckedAt)
+			}
+			metricsMutex.Unlock()
+
+			w := httptest.NewRecorder()
+			readyzHandler(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+			if w.Code != test.wantCode {
+				t.Errorf("status code = %d, want %d", w.Code, test.wantCode)
+			}
+			if body := strings.Split(strings.TrimSpace(w.Body.String()), "\n"); strings.Join(body, "|") != strings.Join(test.wantBody, "|") {
+				t.Errorf("body = %q, want %q", body, test.wantBody)
+			}
+		})
+	}
+}
+
+func TestReadyzAfterTheMonitorsReported(t *testing.T) {
+	resetMetrics(t)
+	observeRegistryProbe(10*time.Millisecond, true)
+	observeClusterCheck()
+
+	w := httptest.NewRecorder()
+	readyzHandler(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("status code = %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+}
+
+func TestHistogramWrite(t *testing.T) {
+	h := newHistogram([]float64{0.1, 1, 10})
+	for _, value := range []float64{0.05, 0.5, 0.5, 30} {
+		h.observe(value)
+	}
+
+	b := &strings.Builder{}
+	h.write(b, "test_seconds", "")
+	want := `test_seconds_bucket{le="0.1"} 1
+test_seconds_bucket{le="1"} 3
+test_seconds_bucket{le="10"} 3
+test_seconds_bucket{le="+Inf"} 4
+test_seconds_sum 31.05
+test_seconds_count 4
+`
+	if b.String() != want {
+		t.Errorf("histogram =\n%s\nwant\n%s", b.String(), want)
+	}
+
+	b.Reset()
+	h.write(b, "test_seconds", `action="Install"`)
+	checkMetricLines(t, b.String(),
+		`test_seconds_bucket{action="Install",le="0.1"} 1`,
+		`test_seconds_bucket{action="Install",le="+Inf"} 4`,
+		`test_seconds_sum{action="Install"} 31.05`,
+		`test_seconds_count{action="Install"} 4`,
+	)
+}
+
+func TestMetricsRegistryProbe(t *testing.T) {
+	resetMetrics(t)
+	observeRegistryProbe(30*time.Millisecond, true)
+	observeRegistryProbe(2*time.Second, false)
+
+	checkMetricLines(t, scrapeMetrics(t),
+		"# TYPE ocpd_agent_registry_probe_duration_seconds histogram",
+		`ocpd_agent_registry_probe_duration_seconds_bucket{le="0.025"} 0`,
+		`ocpd_agent_registry_probe_duration_seconds_bucket{le="0.05"} 1`,
+		`ocpd_agent_registry_probe_duration_seconds_bucket{le="2.5"} 2`,
+		`ocpd_agent_registry_probe_duration_seconds_bucket{le="+Inf"} 2`,
+		"ocpd_agent_registry_probe_duration_seconds_sum 2.03",
+		"ocpd_agent_registry_probe_duration_seconds_count 2",
+		"ocpd_agent_registry_probe_failures_total 1",
+	)
+}
+
+func TestWithMetrics(t *testing.T) {
+	resetMetrics(t)
+	handler := withMetrics("status", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Write([]byte("ok\n"))
+	})
+	for _, method := range []string{http.MethodGet, http.MethodGet, http.MethodPost} {
+		handler(httptest.NewRecorder(), httptest.NewRequest(method, "/status", nil))
+	}
+
+	checkMetricLines(t, scrapeMetrics(t),
+		`ocpd_agent_http_requests_total{handler="status",method="GET",code="200"} 2`,
+		`ocpd_agent_http_requests_total{handler="status",method="POST",code="405"} 1`,
+	)
+}
+
+func TestMetricsJobs(t *testing.T) {
+	useTestConfig(t)
+	resetJobs(t)
+	resetMetrics(t)
+
+	metrics := scrapeMetrics(t)
+	checkMetricLines(t, metrics, "ocpd_agent_jobs_running 0")
+	if strings.Contains(metrics, "ocpd_agent_job_duration_seconds_count") {
+		t.Errorf("metrics have job durations before any job ended:\n%s", metrics)
+	}
+
+	job, err := addJob("0000000000000001", DeployDestroy{Deploy: "Install", ClusterVersion: "4.14.10"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkMetricLines(t, scrapeMetrics(t),
+		"ocpd_agent_jobs_running 1",
+		`ocpd_agent_job_phase{action="Install",phase="Running"} 1`,
+		`ocpd_agent_job_phase{action="Install",phase="Succeeded"} 0`,
+	)
+
+	endJob(job.ID, nil)
+	checkMetricLines(t, scrapeMetrics(t),
+		"ocpd_agent_jobs_running 0",
+		`ocpd_agent_job_phase{action="Install",phase="Succeeded"} 1`,
+		`ocpd_agent_job_duration_seconds_bucket{action="Install",phase="Succeeded",le="60"} 1`,
+		`ocpd_agent_job_duration_seconds_count{action="Install",phase="Succeeded"} 1`,
+	)
+
+	job, err = addJob("0000000000000002", DeployDestroy{Deploy: "Install", ClusterVersion: "4.14.10"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	endJob(job.ID, nil)
+	checkMetricLines(t, scrapeMetrics(t), `ocpd_agent_job_duration_seconds_count{action="Install",phase="Succeeded"} 2`)
+}
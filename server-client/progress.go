@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The stages of a cluster installation in the order they are reached.
+const (
+	stageMirroring         = "Mirroring"
+	stageManifests         = "Manifests"
+	stageBootstrap         = "Bootstrap"
+	stageAPIUp             = "APIUp"
+	stageBootstrapComplete = "BootstrapComplete"
+	stageClusterOperators  = "ClusterOperators"
+	stageInstallComplete   = "InstallComplete"
+	stageInstallFailed     = "InstallFailed"
+)
+
+// A stage of the installation and when it was reached.
+type ProgressStage struct {
+	Name      string
+	ReachedAt time.Time
+}
+
+// How far the cluster installation got. Stage is the last stage reached, Stages has every stage with its timestamp.
+type InstallProgress struct {
+	Stage     string
+	StartedAt time.Time
+	UpdatedAt time.Time
+	Stages    []ProgressStage
+	Error     string `json:",omitempty"`
+}
+
+// The messages of openshift-install that mark the start of a stage.
+var installerStageMarkers = []struct {
+	stage   string
+	message *regexp.Regexp
+}{
+	{stageBootstrap, regexp.MustCompile(`^Creating infrastructure resources`)},
+	{stageAPIUp, regexp.MustCompile(`^API v\S+ up$`)},
+	{stageBootstrapComplete, regexp.MustCompile(`^Bootstrap status: complete$|^Destroying the bootstrap resources`)},
+	{stageClusterOperators, regexp.MustCompile(`^Waiting up to \S+ .*to initialize\.\.\.$`)},
+	{stageInstallComplete, regexp.MustCompile(`^Install complete!$`)},
+}
+
+// A line of .openshift_install.log: time="2024-05-01T10:00:00Z" level=info msg="API v1.29.5 up"
+var installerLogLine = regexp.MustCompile(`^time="([^"]+)" level=(\w+) msg="((?:[^"\\]|\\.)*)"`)
+
+// Reads .openshift_install.log as it grows. Only the lines appended since the last read are parsed.
+// The monitoring goroutine updates it while /status reads the progress so the progress is guarded by the mutex.
+type installLogTracker struct {
+	mutex    sync.Mutex
+	offset   int64
+	progress InstallProgress
+}
+
+var installLog = &installLogTracker{}
+
+func (t *installLogTracker) reset() {
+	t.offset = 0
+	t.progress = InstallProgress{}
+}
+
+// Parses the new lines of the log. A log that disappeared or got shorter belongs to a new installation so parsing starts over.
+func (t *installLogTracker) update(path string) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		t.reset()
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if info.Size() < t.offset {
+		t.reset()
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if _, err := file.Seek(t.offset, io.SeekStart); err != nil {
+		return err
+	}
+
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadString('\n')
+		// A line without its newline is still being written. It is parsed on the next update.
+		if err != nil {
+			break
+		}
+		t.offset += int64(len(line))
+		t.parseLine(strings.TrimRight(line, "\n"))
+	}
+	return nil
+}
+
+func (t *installLogTracker) parseLine(line string) {
+	match := installerLogLine.FindStringSubmatch(line)
+	if match == nil {
+		return
+	}
+	timestamp, err := time.Parse(time.RFC3339, match[1])
+	if err != nil {
+		return
+	}
+	message, err := strconv.Unquote(`"` + match[3] + `"`)
+	if err != nil {
+		message = match[3]
+	}
+
+	// The installer writes its log as soon as it starts rendering the manifests.
+	if len(t.progress.Stages) == 0 {
+		t.progress.reach(stageManifests, timestamp)
+	}
+	if match[2] == "fatal" {
+		t.progress.reach(stageInstallFailed, timestamp)
+		t.progress.Error = message
+		return
+	}
+	for _, marker := range installerStageMarkers {
+		if marker.message.MatchString(message) {
+			t.progress.reach(marker.stage, timestamp)
+		}
+	}
+}
+
+// Returns a copy of the progress found in the log.
+func (t *installLogTracker) snapshot() InstallProgress {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	progress := t.progress
+	progress.Stages = append([]ProgressStage(nil), t.progress.Stages...)
+	return progress
+}
+
+// Records the stage unless it was already reached.
+func (p *InstallProgress) reach(stage string, at time.Time) {
+	for _, reached := range p.Stages {
+		if reached.Name == stage {
+			return
+		}
+	}
+	if len(p.Stages) == 0 {
+		p.StartedAt = at
+	}
+	p.Stages = append(p.Stages, ProgressStage{Name: stage, ReachedAt: at})
+	p.Stage = stage
+	p.UpdatedAt = at
+}
+
+// Combines the stages found in the installer log with the latest job if it is an install. The job starts by mirroring
+// the release before openshift-install runs, and a job that failed before the installer finished is reported as a failed
+// installation, as is a cancelled one. Returns nil when there is no installation to report.
+func currentInstallProgress() *InstallProgress {
+	var job *Job
+	if allJobs := listJobs(); len(allJobs) > 0 {
+		job = &allJobs[len(allJobs)-1]
+		if job.Action != "Install" {
+			return nil
+		}
+	}
+
+	logProgress := installLog.snapshot()
+	if job == nil && len(logProgress.Stages) == 0 {
+		return nil
+	}
+
+	progress := &InstallProgress{}
+	if job != nil {
+		progress.reach(stageMirroring, job.StartedAt)
+	}
+	for _, stage := range logProgress.Stages {
+		progress.reach(stage.Name, stage.ReachedAt)
+	}
+	progress.Error = logProgress.Error
+
+	if job != nil && (job.Phase == jobFailed || job.Phase == jobCancelled) && progress.Stage != stageInstallFailed && progress.Stage != stageInstallComplete {
+		progress.reach(stageInstallFailed, *job.EndedAt)
+		progress.Error = job.Error
+	}
+	return progress
+}
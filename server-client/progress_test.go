@@ -0,0 +1,162 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// The lines openshift-install writes in .openshift_install.log during an installation, in order.
+var installerLogLines = []string{
+	`time="2024-05-01T10:00:00Z" level=info msg="Consuming Install Config from target directory"`,
+	`time="2024-05-01T10:00:05Z" level=debug msg="Generating asset Manifests..."`,
+	`time="2024-05-01T10:01:00Z" level=info msg="Creating infrastructure resources..."`,
+	`time="2024-05-01T10:05:00Z" level=info msg="Waiting up to 20m0s (until 10:25AM UTC) for the Kubernetes API at https://api.lab.example.com:6443..."`,
+	`time="2024-05-01T10:09:00Z" level=info msg="API v1.29.5 up"`,
+	`time="2024-05-01T10:09:01Z" level=info msg="Waiting up to 45m0s (until 10:54AM UTC) for bootstrapping to complete..."`,
+	`time="2024-05-01T10:25:00Z" level=info msg="Destroying the bootstrap resources..."`,
+	`time="2024-05-01T10:27:00Z" level=info msg="Waiting up to 40m0s (until 11:07AM UTC) for the cluster at https://api.lab.example.com:6443 to initialize..."`,
+	`time="2024-05-01T10:55:00Z" level=info msg="Install complete!"`,
+}
+
+func TestInstallLogTracker(t *testing.T) {
+	lines := func(count int) string {
+		log := ""
+		for _, line := range installerLogLines[:count] {
+			log += line + "\n"
+		}
+		return log
+	}
+	tests := []struct {
+		name       string
+		log        string
+		wantStages []string
+		wantError  string
+	}{
+		{name: "no log yet", wantStages: nil},
+		{name: "manifests", log: lines(2), wantStages: []string{stageManifests}},
+		{name: "bootstrap", log: lines(4), wantStages: []string{stageManifests, stageBootstrap}},
+		{name: "api up", log: lines(6), wantStages: []string{stageManifests, stageBootstrap, stageAPIUp}},
+		{name: "bootstrap complete", log: lines(7), wantStages: []string{stageManifests, stageBootstrap, stageAPIUp, stageBootstrapComplete}},
+		{
+			name:       "cluster operators",
+			log:        lines(8),
+			wantStages: []string{stageManifests, stageBootstrap, stageAPIUp, stageBootstrapComplete, stageClusterOperators},
+		},
+		{
+			name:       "install complete",
+			log:        lines(9),
+			wantStages: []string{stageManifests, stageBootstrap, stageAPIUp, stageBootstrapComplete, stageClusterOperators, stageInstallComplete},
+		},
+		{
+			name:       "bootstrap status of an older installer",
+			log:        lines(6) + `time="2024-05-01T10:25:00Z" level=info msg="Bootstrap status: complete"` + "\n",
+			wantStages: []string{stageManifests, stageBootstrap, stageAPIUp, stageBootstrapComplete},
+		},
+		{
+			name:       "fatal error",
+			log:        lines(5) + `time="2024-05-01T10:40:00Z" level=fatal msg="failed to wait for bootstrapping to complete: timed out waiting for the condition on \"bootstrap\""` + "\n",
+			wantStages: []string{stageManifests, stageBootstrap, stageAPIUp, stageInstallFailed},
+			wantError:  `failed to wait for bootstrapping to complete: timed out waiting for the condition on "bootstrap"`,
+		},
+		{
+			name:       "unknown lines are skipped",
+			log:        "Starting the installer\n" + `time="yesterday" level=info msg="API v1.29.5 up"` + "\n" + `level=info msg="Install complete!"` + "\n",
+			wantStages: nil,
+		},
+		{
+			name:       "a line still being written is not parsed",
+			log:        lines(4) + `time="2024-05-01T10:09:00Z" level=info msg="API v1.29.5 up"`,
+			wantStages: []string{stageManifests, stageBootstrap},
+		},
+		{
+			name:       "a line cut in the middle is not a stage",
+			log:        lines(4) + `time="2024-05-01T10:09:00Z" level=info msg="API v1.2` + "\n",
+			wantStages: []string{stageManifests, stageBootstrap},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), ".openshift_install.log")
+			if test.log != "" {
+				if err := os.WriteFile(path, []byte(test.log), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			tracker := &installLogTracker{}
+			if err := tracker.update(path); err != nil {
+				t.Fatalf("update() error = %v", err)
+			}
+
+			progress := tracker.snapshot()
+			var stages []string
+			for _, stage := range progress.Stages {
+				stages = append(stages, stage.Name)
+			}
+			if !reflect.DeepEqual(stages, test.wantStages) {
+				t.Errorf("stages = %v, want %v", stages, test.wantStages)
+			}
+			if len(stages) > 0 && progress.Stage != stages[len(stages)-1] {
+				t.Errorf("stage = %s, want the last stage reached %s", progress.Stage, stages[len(stages)-1])
+			}
+			if progress.Error != test.wantError {
+				t.Errorf("error = %q, want %q", progress.Error, test.wantError)
+			}
+		})
+	}
+}
+
+// The log is read as it grows and a new installation, with a new shorter log, starts over.
+func TestInstallLogTrackerFollowsTheLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".openshift_install.log")
+	tracker := &installLogTracker{}
+	appendLine := func(line string) {
+		t.Helper()
+		file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer file.Close()
+		if _, err := file.WriteString(line); err != nil {
+			t.Fatal(err)
+		}
+		if err := tracker.update(path); err != nil {
+			t.Fatalf("update() error = %v", err)
+		}
+	}
+
+	for _, line := range installerLogLines[:5] {
+		appendLine(line + "\n")
+	}
+	if stage := tracker.snapshot().Stage; stage != stageAPIUp {
+		t.Errorf("stage = %s, want %s", stage, stageAPIUp)
+	}
+	// The last line is written in two parts.
+	appendLine(`time="2024-05-01T10:25:00Z" level=info msg="Destroying the `)
+	if stage := tracker.snapshot().Stage; stage != stageAPIUp {
+		t.Errorf("stage after half a line = %s, want %s", stage, stageAPIUp)
+	}
+	appendLine(`bootstrap resources..."` + "\n")
+	if stage := tracker.snapshot().Stage; stage != stageBootstrapComplete {
+		t.Errorf("stage = %s, want %s", stage, stageBootstrapComplete)
+	}
+
+	if err := os.WriteFile(path, []byte(installerLogLines[0]+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := tracker.update(path); err != nil {
+		t.Fatal(err)
+	}
+	if progress := tracker.snapshot(); len(progress.Stages) != 1 || progress.Stage != stageManifests {
+		t.Errorf("progress of the new log = %+v, want only %s", progress, stageManifests)
+	}
+
+	os.Remove(path)
+	if err := tracker.update(path); err != nil {
+		t.Fatal(err)
+	}
+	if progress := tracker.snapshot(); len(progress.Stages) != 0 {
+		t.Errorf("progress without a log = %+v, want none", progress)
+	}
+}
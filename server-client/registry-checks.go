@@ -0,0 +1,350 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	neturl "net/url"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// The registry is unhealthy when the storage path has less free space than this.
+const minFreeDiskPercent = 10
+
+// A check of the mirror registry. Critical checks decide if the registry is Healthy. The others are reported but a registry
+// failing them still serves images, e.g the release repositories exist only after the first mirroring.
+// run returns a short description of what it found or why the check failed.
+type registryCheck struct {
+	name     string
+	critical bool
+	run      func(c *Config) (string, error)
+}
+
+// The checks run by monitorRegistry in this order. A new check only has to be added here. disabledRegistryChecks in the
+// config file turns checks off by name.
+var registryChecks = []registryCheck{
+	{"quay-health", true, checkQuayHealth},
+	{"v2-api", true, checkV2API},
+	{"tls", true, checkRegistryTLS},
+	{"disk-space", false, checkFreeDiskSpace},
+	{"release-repositories", false, checkReleaseRepositories},
+}
+
+// The outcome of a check as reported by /status.
+type RegistryCheckResult struct {
+	Name      string
+	Critical  bool
+	Passed    bool
+	Message   string
+	CheckedAt time.Time
+}
+
+// The HTTP checks look at what Quay answers. Whether its certificate can be trusted is the job of the tls check so a
+// certificate problem is reported once and does not hide the state of Quay. Its timeout is also the one of the tls check.
+var registryHTTPClient = &http.Client{
+	Timeout:   10 * time.Second,
+	Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}},
+}
+
+func findRegistryCheck(name string) *registryCheck {
+	for i := range registryChecks {
+		if registryChecks[i].name == name {
+			return &registryChecks[i]
+		}
+	}
+	return nil
+}
+
+func registryCheckNames() []string {
+	var names []string
+	for _, check := range registryChecks {
+		names = append(names, check.name)
+	}
+	return names
+}
+
+// Runs every check that is not disabled.
+func runRegistryChecks(c *Config) []RegistryCheckResult {
+	disabled := map[string]bool{}
+	for _, name := range splitList(c.DisabledRegistryChecks) {
+		disabled[name] = true
+	}
+
+	results := []RegistryCheckResult{}
+	for _, check := range registryChecks {
+		if disabled[check.name] {
+			continue
+		}
+		message, err := check.run(c)
+		result := RegistryCheckResult{Name: check.name, Critical: check.critical, Passed: err == nil, Message: message, CheckedAt: time.Now()}
+		if err != nil {
+			result.Message = err.Error()
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// The registry is healthy when every critical check passed.
+func registryHealthy(results []RegistryCheckResult) bool {
+	for _, result := range results {
+		if result.Critical && !result.Passed {
+			return false
+		}
+	}
+	return true
+}
+
+// Quay reports the state of its services (database, redis, storage, auth) on /health/instance.
+func checkQuayHealth(c *Config) (string, error) {
+	resp, err := registryHTTPClient.Get(strings.TrimRight(c.RegistryURL, "/") + "/health/instance")
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var health struct {
+		Data struct {
+			Services map[string]bool `json:"services"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&health); err != nil && resp.StatusCode == http.StatusOK {
+		return "", fmt.Errorf("cannot decode the health of Quay: %v", err)
+	}
+
+	var failing []string
+	for service, up := range health.Data.Services {
+		if !up {
+			failing = append(failing, service)
+		}
+	}
+	if len(failing) > 0 {
+		return "", fmt.Errorf("Quay reports %d, failing services: %s", resp.StatusCode, strings.Join(failing, ", "))
+	} else if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("Quay reports %d", resp.StatusCode)
+	}
+	return fmt.Sprintf("%d services up", len(health.Data.Services)), nil
+}
+
+// A registry serving the v2 API answers an anonymous /v2/ with 401 and a bearer challenge telling where to get a token.
+func checkV2API(c *Config) (string, error) {
+	resp, err := registryHTTPClient.Get(strings.TrimRight(c.RegistryURL, "/") + "/v2/")
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusUnauthorized {
+		return "", fmt.Errorf("/v2/ returned %d instead of 401", resp.StatusCode)
+	}
+	challenge := parseChallenge(resp.Header.Get("WWW-Authenticate"))
+	if challenge == nil || challenge["realm"] == "" {
+		return "", fmt.Errorf("/v2/ returned no bearer challenge")
+	}
+	return fmt.Sprintf("bearer challenge from %s", challenge["realm"]), nil
+}
+
+// Verifies the certificate of Quay against the rootCA mirror-registry created, as oc-mirror and the cluster do.
+func checkRegistryTLS(c *Config) (string, error) {
+	rootCA, err := os.ReadFile(c.RegistryRootCA)
+	if err != nil {
+		return "", err
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(rootCA) {
+		return "", fmt.Errorf("no certificate found in %s", c.RegistryRootCA)
+	}
+	hostname, err := c.registryHostname()
+	if err != nil {
+		return "", err
+	}
+	registry, err := neturl.Parse(c.RegistryURL)
+	if err != nil {
+		return "", err
+	}
+
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: registryHTTPClient.Timeout}, "tcp", registry.Host, &tls.Config{RootCAs: roots, ServerName: hostname})
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	certificate := conn.ConnectionState().PeerCertificates[0]
+	return fmt.Sprintf("certificate for %s valid until %s", hostname, certificate.NotAfter.Format(time.RFC3339)), nil
+}
+
+// The images are stored under the Quay root.
+func checkFreeDiskSpace(c *Config) (string, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(c.RegistryStoragePath, &stat); err != nil {
+		return "", err
+	}
+	free := stat.Bavail * uint64(stat.Bsize)
+	total := stat.Blocks * uint64(stat.Bsize)
+	if total == 0 {
+		return "", fmt.Errorf("%s reports no space", c.RegistryStoragePath)
+	}
+
+	percent := free * 100 / total
+	message := fmt.Sprintf("%d%% free (%.1f GiB of %.1f GiB) in %s", percent, float64(free)/(1<<30), float64(total)/(1<<30), c.RegistryStoragePath)
+	if percent < minFreeDiskPercent {
+		return "", fmt.Errorf("only %s", message)
+	}
+	return message, nil
+}
+
+// The repositories oc-mirror pushes the release to. They are read with the credentials of the pull-secret of ec2-user.
+func checkReleaseRepositories(c *Config) (string, error) {
+	repositories := splitList(c.ReleaseRepositories)
+	if len(repositories) == 0 {
+		return "no release repositories configured", nil
+	}
+	auth, err := registryAuth(c)
+	if err != nil {
+		return "", err
+	}
+
+	var found, missing []string
+	for _, repository := range repositories {
+		tags, err := listTags(c, repository, auth)
+		if err != nil {
+			missing = append(missing, fmt.Sprintf("%s (%v)", repository, err))
+			continue
+		}
+		found = append(found, fmt.Sprintf("%s: %d tags", repository, tags))
+	}
+	if len(missing) > 0 {
+		return "", fmt.Errorf("missing %s", strings.Join(missing, ", "))
+	}
+	return strings.Join(found, ", "), nil
+}
+
+// Returns the base64 user:password of the registry from the auth file.
+func registryAuth(c *Config) (string, error) {
+	data, err := os.ReadFile(c.RegistryAuthFile)
+	if err != nil {
+		return "", err
+	}
+	var authFile struct {
+		Auths map[string]struct {
+			Auth string `json:"auth"`
+		} `json:"auths"`
+	}
+	if err := json.Unmarshal(data, &authFile); err != nil {
+		return "", fmt.Errorf("cannot decode %s: %v", c.RegistryAuthFile, err)
+	}
+
+	hostname, err := c.registryHostname()
+	if err != nil {
+		return "", err
+	}
+	registry, err := neturl.Parse(c.RegistryURL)
+	if err != nil {
+		return "", err
+	}
+	key := net.JoinHostPort(hostname, registry.Port())
+	if entry, ok := authFile.Auths[key]; ok && entry.Auth != "" {
+		return entry.Auth, nil
+	}
+	return "", fmt.Errorf("no credentials for %s in %s", key, c.RegistryAuthFile)
+}
+
+// Lists the tags of a repository and returns how many there are. Quay wants a bearer token even for reading so the
+// challenge of the first request is answered with a token requested with the basic credentials.
+func listTags(c *Config, repository string, auth string) (int, error) {
+	tagsURL := strings.TrimRight(c.RegistryURL, "/") + "/v2/" + repository + "/tags/list"
+	resp, err := registryHTTPClient.Get(tagsURL)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		token, err := registryToken(resp.Header.Get("WWW-Authenticate"), repository, auth)
+		if err != nil {
+			return 0, err
+		}
+		request, err := http.NewRequest("GET", tagsURL, nil)
+		if err != nil {
+			return 0, err
+		}
+		request.Header.Set("Authorization", "Bearer "+token)
+		resp, err = registryHTTPClient.Do(request)
+		if err != nil {
+			return 0, err
+		}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return 0, fmt.Errorf("not found")
+	} else if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("status %d", resp.StatusCode)
+	}
+	var tags struct {
+		Tags []string `json:"tags"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tags); err != nil {
+		return 0, err
+	}
+	if len(tags.Tags) == 0 {
+		return 0, fmt.Errorf("no tags")
+	}
+	return len(tags.Tags), nil
+}
+
+// Requests a pull token for the repository from the realm of the challenge.
+func registryToken(header string, repository string, auth string) (string, error) {
+	challenge := parseChallenge(header)
+	if challenge == nil || challenge["realm"] == "" {
+		return "", fmt.Errorf("no bearer challenge")
+	}
+	query := neturl.Values{}
+	query.Set("service", challenge["service"])
+	query.Set("scope", "repository:"+repository+":pull")
+
+	request, err := http.NewRequest("GET", challenge["realm"]+"?"+query.Encode(), nil)
+	if err != nil {
+		return "", err
+	}
+	request.Header.Set("Authorization", "Basic "+auth)
+	resp, err := registryHTTPClient.Do(request)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token request returned %d", resp.StatusCode)
+	}
+	var token struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", err
+	}
+	return token.Token, nil
+}
+
+// Parses a challenge like: Bearer realm="https://host:8443/v2/auth",service="host:8443"
+// Returns nil if it is not a bearer challenge.
+func parseChallenge(header string) map[string]string {
+	scheme, params, ok := strings.Cut(header, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return nil
+	}
+	challenge := map[string]string{}
+	for _, param := range strings.Split(params, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(param), "=")
+		if ok {
+			challenge[key] = strings.Trim(value, `"`)
+		}
+	}
+	return challenge
+}
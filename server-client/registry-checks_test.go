@@ -0,0 +1,231 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Starts a TLS server answering like a healthy Quay with the release repositories mirrored, and points the config at it.
+// The routes replace those of the healthy Quay.
+func startFakeQuay(t *testing.T, routes map[string]http.HandlerFunc) *Config {
+	t.Helper()
+	c := useTestConfig(t)
+	var server *httptest.Server
+
+	quay := map[string]http.HandlerFunc{}
+	quay["/health/instance"] = func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"data": {"services": {"auth": true, "database": true, "redis": true, "storage": true}}, "status_code": 200}`)
+	}
+	quay["/v2/"] = func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer pull-token" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="`+server.URL+`/v2/auth",service="example.com"`)
+			http.Error(w, `{"errors": [{"code": "UNAUTHORIZED"}]}`, http.StatusUnauthorized)
+			return
+		}
+		if !strings.HasPrefix(r.URL.Path, "/v2/openshift/") || !strings.HasSuffix(r.URL.Path, "/tags/list") {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, `{"name": "openshift/release", "tags": ["4.14.10-x86_64", "4.14.10-x86_64-etcd"]}`)
+	}
+	quay["/v2/auth"] = func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Basic "+base64.StdEncoding.EncodeToString([]byte("init:password")) {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"token": "pull-token"})
+	}
+	for path, handler := range routes {
+		quay[path] = handler
+	}
+	mux := http.NewServeMux()
+	for path, handler := range quay {
+		mux.HandleFunc(path, handler)
+	}
+	server = httptest.NewUnstartedServer(mux)
+	// The failing tls checks end in handshake errors the server would log.
+	server.Config.ErrorLog = log.New(io.Discard, "", 0)
+	server.StartTLS()
+	t.Cleanup(server.Close)
+
+	// The certificate of httptest is issued for example.com and 127.0.0.1.
+	c.RegistryURL = server.URL
+	c.RegistryHostname = "example.com"
+	c.RegistryRootCA = filepath.Join(t.TempDir(), "rootCA.pem")
+	writeTestFile(t, c.RegistryRootCA, string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})))
+	c.RegistryStoragePath = t.TempDir()
+	c.RegistryAuthFile = filepath.Join(t.TempDir(), "config.json")
+	port := server.URL[strings.LastIndex(server.URL, ":")+1:]
+	auth := base64.StdEncoding.EncodeToString([]byte("init:password"))
+	writeTestFile(t, c.RegistryAuthFile, `{"auths": {"example.com:`+port+`": {"auth": "`+auth+`"}}}`)
+	return c
+}
+
+// Makes the checks give up quickly on a registry that does not answer.
+func useShortRegistryTimeout(t *testing.T) {
+	t.Helper()
+	previous := registryHTTPClient
+	registryHTTPClient = &http.Client{Timeout: 200 * time.Millisecond, Transport: previous.Transport}
+	t.Cleanup(func() { registryHTTPClient = previous })
+}
+
+// A handler that answers only after the checks gave up.
+func hangingHandler(w http.ResponseWriter, r *http.Request) {
+	select {
+	case <-r.Context().Done():
+	case <-time.After(5 * time.Second):
+	}
+}
+
+func TestRegistryChecks(t *testing.T) {
+	tests := []struct {
+		name        string
+		check       string
+		routes      map[string]http.HandlerFunc
+		edit        func(c *Config)
+		wantPassed  bool
+		wantMessage string
+	}{
+		{name: "quay health", check: "quay-health", wantPassed: true, wantMessage: "4 services up"},
+		{
+			name:  "quay health with a failing service",
+			check: "quay-health",
+			routes: map[string]http.HandlerFunc{"/health/instance": func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				fmt.Fprint(w, `{"data": {"services": {"auth": true, "database": false}}, "status_code": 503}`)
+			}},
+			wantMessage: "Quay reports 503, failing services: database",
+		},
+		{name: "quay health timeout", check: "quay-health", routes: map[string]http.HandlerFunc{"/health/instance": hangingHandler}, wantMessage: "Timeout"},
+		{name: "v2 api", check: "v2-api", wantPassed: true, wantMessage: "bearer challenge from https://127.0.0.1:"},
+		{
+			name:  "v2 api without a challenge",
+			check: "v2-api",
+			routes: map[string]http.HandlerFunc{"/v2/": func(w http.ResponseWriter, r *http.Request) {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			}},
+			wantMessage: "/v2/ returned no bearer challenge",
+		},
+		{
+			name:  "v2 api served by something else",
+			check: "v2-api",
+			routes: map[string]http.HandlerFunc{"/v2/": func(w http.ResponseWriter, r *http.Request) {
+				http.Error(w, "Bad Gateway", http.StatusBadGateway)
+			}},
+			wantMessage: "/v2/ returned 502 instead of 401",
+		},
+		{name: "v2 api timeout", check: "v2-api", routes: map[string]http.HandlerFunc{"/v2/": hangingHandler}, wantMessage: "Timeout"},
+		{name: "tls", check: "tls", wantPassed: true, wantMessage: "certificate for example.com valid until"},
+		{name: "tls for another hostname", check: "tls", edit: func(c *Config) { c.RegistryHostname = "quay.lab.example.com" }, wantMessage: "quay.lab.example.com"},
+		{name: "tls without the root CA", check: "tls", edit: func(c *Config) { writeTestFile(t, c.RegistryRootCA, "not a certificate") }, wantMessage: "no certificate found"},
+		{name: "disk space", check: "disk-space", wantPassed: true, wantMessage: "free"},
+		{name: "disk space of a missing path", check: "disk-space", edit: func(c *Config) { c.RegistryStoragePath = filepath.Join(c.RegistryStoragePath, "missing") }, wantMessage: "no such file"},
+		{name: "release repositories", check: "release-repositories", wantPassed: true, wantMessage: "openshift/release: 2 tags, openshift/release-images: 2 tags"},
+		{
+			name:        "release repositories not mirrored yet",
+			check:       "release-repositories",
+			edit:        func(c *Config) { c.ReleaseRepositories = "openshift/release,ocp/release" },
+			wantMessage: "missing ocp/release (not found)",
+		},
+		{
+			name:        "release repositories with wrong credentials",
+			check:       "release-repositories",
+			edit:        func(c *Config) { writeTestFile(t, c.RegistryAuthFile, `{"auths": {}}`) },
+			wantMessage: "no credentials for example.com:",
+		},
+		{
+			name:        "release repositories timeout",
+			check:       "release-repositories",
+			routes:      map[string]http.HandlerFunc{"/v2/auth": hangingHandler},
+			wantMessage: "Timeout",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := startFakeQuay(t, test.routes)
+			useShortRegistryTimeout(t)
+			if test.edit != nil {
+				test.edit(c)
+			}
+
+			message, err := findRegistryCheck(test.check).run(c)
+			if passed := err == nil; passed != test.wantPassed {
+				t.Fatalf("passed = %v, want %v: %q, %v", passed, test.wantPassed, message, err)
+			}
+			if err != nil {
+				message = err.Error()
+			}
+			if !strings.Contains(message, test.wantMessage) {
+				t.Errorf("message = %q, want %q in it", message, test.wantMessage)
+			}
+		})
+	}
+}
+
+// The TLS handshake of a registry that accepts connections and never answers is given up after the timeout too.
+func TestRegistryTLSCheckTimeout(t *testing.T) {
+	c := startFakeQuay(t, nil)
+	useShortRegistryTimeout(t)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			t.Cleanup(func() { conn.Close() })
+		}
+	}()
+	c.RegistryURL = "https://" + listener.Addr().String()
+
+	start := time.Now()
+	_, err = checkRegistryTLS(c)
+	if netErr, ok := err.(net.Error); !ok || !netErr.Timeout() {
+		t.Errorf("checkRegistryTLS() error = %v, want a timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("the check took %s", elapsed)
+	}
+}
+
+func TestRunRegistryChecks(t *testing.T) {
+	c := startFakeQuay(t, map[string]http.HandlerFunc{"/v2/openshift/release-images/tags/list": http.NotFound})
+	useShortRegistryTimeout(t)
+	c.DisabledRegistryChecks = "disk-space"
+
+	results := runRegistryChecks(c)
+
+	var names []string
+	for _, result := range results {
+		names = append(names, result.Name)
+		if result.Name != "release-repositories" && !result.Passed {
+			t.Errorf("check %s failed: %s", result.Name, result.Message)
+		}
+	}
+	if want := "quay-health,v2-api,tls,release-repositories"; strings.Join(names, ",") != want {
+		t.Errorf("checks run = %v, want %s", names, want)
+	}
+	// Only a critical check makes the registry unhealthy. The release is mirrored after the registry is up.
+	if !registryHealthy(results) {
+		t.Errorf("registry unhealthy with only the release repositories failing: %+v", results)
+	}
+	results[0].Passed = false
+	if registryHealthy(results) {
+		t.Error("registry healthy with the quay-health check failing")
+	}
+}
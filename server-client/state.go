@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// What the agent remembers across restarts. It is saved in the state file after every change. The install-config holds the
+// pull-secret so the file is readable only by ec2-user.
+type AgentState struct {
+	Jobs                    []Job
+	LastAction              *DeployDestroy         `json:",omitempty"`
+	InstallConfig           map[string]interface{} `json:",omitempty"`
+	InstallConfigReceivedAt *time.Time             `json:",omitempty"`
+	ClusterFiles            []string
+	ClusterEvents           []ClusterEvent
+	SavedAt                 time.Time
+}
+
+// The parts of the state that are not kept elsewhere. The jobs and the cluster events are read from their own globals
+// when the state is saved.
+var (
+	persisted struct {
+		lastAction              *DeployDestroy
+		installConfig           map[string]interface{}
+		installConfigReceivedAt *time.Time
+		clusterFiles            []string
+		clusterFilesKnown       bool
+	}
+	stateMutex sync.Mutex
+)
+
+// Writes the state file. It is written to a temporary file first so a crash never leaves half a file behind.
+// Must not be called with the jobs or the events mutex held.
+func saveState() {
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+
+	state := AgentState{
+		Jobs:                    listJobs(),
+		LastAction:              persisted.lastAction,
+		InstallConfig:           persisted.installConfig,
+		InstallConfigReceivedAt: persisted.installConfigReceivedAt,
+		ClusterFiles:            persisted.clusterFiles,
+		ClusterEvents:           listClusterEvents(),
+		SavedAt:                 time.Now(),
+	}
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		fmt.Printf("Cannot marshal the agent state: %v\n", err)
+		return
+	}
+	temporary := config.StateFile + ".tmp"
+	if err := os.WriteFile(temporary, data, 0600); err != nil {
+		fmt.Printf("Cannot save the agent state: %v\n", err)
+		return
+	}
+	if err := os.Rename(temporary, config.StateFile); err != nil {
+		fmt.Printf("Cannot save the agent state: %v\n", err)
+	}
+}
+
+// Records the action the client requested last.
+func rememberAction(action DeployDestroy) {
+	stateMutex.Lock()
+	persisted.lastAction = &action
+	stateMutex.Unlock()
+	saveState()
+}
+
+// Records the install-config the client sent last.
+func rememberInstallConfig(installConfig map[string]interface{}) {
+	stateMutex.Lock()
+	now := time.Now()
+	persisted.installConfig = installConfig
+	persisted.installConfigReceivedAt = &now
+	stateMutex.Unlock()
+	saveState()
+}
+
+// Returns the cluster name and the base domain of the install-config the client sent last, empty if it sent none.
+func rememberedClusterDomain() (clusterName string, baseDomain string) {
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+
+	baseDomain, _ = persisted.installConfig["baseDomain"].(string)
+	if metadata, ok := persisted.installConfig["metadata"].(map[string]interface{}); ok {
+		clusterName, _ = metadata["name"].(string)
+	}
+	return clusterName, baseDomain
+}
+
+// Records the cluster files found by monitorClusterInstallation.
+func rememberClusterFiles(files []string) {
+	stateMutex.Lock()
+	persisted.clusterFiles = files
+	persisted.clusterFilesKnown = true
+	stateMutex.Unlock()
+	saveState()
+}
+
+// Returns the cluster files found before the agent restarted. known is false on the first start.
+func restoredClusterFiles() (files []string, known bool) {
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+	return persisted.clusterFiles, persisted.clusterFilesKnown
+}
+
+// Loads the state file saved before the agent restarted and reconciles it with what is on disk:
+//   - a job that was running was stopped with the agent. A job being cancelled is Cancelled. An install is Succeeded if
+//     the installer log says the install completed and a destroy if no cluster is left, otherwise the job Failed.
+//   - an install-config received after the last install started and missing from the installation directory is written again.
+//
+// Whether the cluster appeared or disappeared while the agent was down is found by monitorClusterInstallation.
+func restoreState(c *Config) error {
+	data, err := os.ReadFile(c.StateFile)
+	if os.IsNotExist(err) {
+		fmt.Println("No agent state to restore")
+		return nil
+	} else if err != nil {
+		return err
+	}
+	state := AgentState{}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("cannot decode %s: %v", c.StateFile, err)
+	}
+
+	var lastInstall *Job
+	jobsMutex.Lock()
+	for i := range state.Jobs {
+		job := state.Jobs[i]
+		if job.Phase == jobRunning {
+			reconcileInterruptedJob(c, &job)
+		}
+		if job.Action == "Install" {
+			lastInstall = &job
+		}
+		jobs[job.ID] = &job
+		jobOrder = append(jobOrder, job.ID)
+	}
+	runningJob = nil
+	jobsMutex.Unlock()
+
+	clusterEventMutex.Lock()
+	clusterEvents = state.ClusterEvents
+	clusterEventMutex.Unlock()
+
+	stateMutex.Lock()
+	persisted.lastAction = state.LastAction
+	persisted.installConfig = state.InstallConfig
+	persisted.installConfigReceivedAt = state.InstallConfigReceivedAt
+	persisted.clusterFiles = state.ClusterFiles
+	persisted.clusterFilesKnown = true
+	stateMutex.Unlock()
+
+	installConfigPath := filepath.Join(c.InstallDir, "install-config.yaml")
+	pending := state.InstallConfig != nil && state.InstallConfigReceivedAt != nil &&
+		(lastInstall == nil || state.InstallConfigReceivedAt.After(lastInstall.StartedAt))
+	if _, err := os.Stat(installConfigPath); pending && os.IsNotExist(err) {
+		fmt.Println("Restoring the install-config received before the agent restarted")
+		if err := writeInstallConfig(state.InstallConfig); err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("Restored %d jobs and %d cluster events saved at %v\n", len(state.Jobs), len(state.ClusterEvents), state.SavedAt.Format(time.RFC3339))
+	saveState()
+	return nil
+}
+
+// The exit status of a job whose script was stopped with the agent and did not finish its work. The real one was never seen.
+const interruptedExitStatus = -1
+
+func reconcileInterruptedJob(c *Config, job *Job) {
+	endedAt := time.Now()
+	exitStatus := interruptedExitStatus
+	job.EndedAt = &endedAt
+	job.ExitStatus = &exitStatus
+	job.Phase = jobFailed
+	job.Error = "the agent restarted while the job was running"
+
+	if job.CancelRequestedAt != nil {
+		job.Phase = jobCancelled
+		job.Error = errJobCancelled.Error()
+	} else if job.Action == "Install" {
+		tracker := &installLogTracker{}
+		if err := tracker.update(c.installerLog()); err == nil && tracker.snapshot().Stage == stageInstallComplete {
+			job.Phase = jobSucceeded
+			job.Error = ""
+			exitStatus = 0
+		}
+	} else if len(presentClusterFiles(c.InstallDir)) == 0 {
+		job.Phase = jobSucceeded
+		job.Error = ""
+		exitStatus = 0
+	}
+	fmt.Printf("Job %s was running when the agent stopped. Phase is: %s\n", job.ID, job.Phase)
+}
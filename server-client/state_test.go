@@ -0,0 +1,126 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReconcileInterruptedJob(t *testing.T) {
+	cancelRequestedAt := time.Now()
+	tests := []struct {
+		name           string
+		job            Job
+		installerLog   string
+		clusterFiles   []string
+		wantPhase      string
+		wantExitStatus int
+	}{
+		{
+			name:           "a cancelled job stays cancelled",
+			job:            Job{Action: "Install", CancelRequestedAt: &cancelRequestedAt},
+			installerLog:   `time="2024-05-01T10:00:00Z" level=info msg="Install complete!"`,
+			wantPhase:      jobCancelled,
+			wantExitStatus: interruptedExitStatus,
+		},
+		{
+			name:           "an install the installer completed succeeded",
+			job:            Job{Action: "Install"},
+			installerLog:   `time="2024-05-01T10:00:00Z" level=info msg="Install complete!"`,
+			wantPhase:      jobSucceeded,
+			wantExitStatus: 0,
+		},
+		{
+			name:           "an install the installer did not complete failed",
+			job:            Job{Action: "Install"},
+			installerLog:   `time="2024-05-01T10:00:00Z" level=info msg="API v1.29.5 up"`,
+			wantPhase:      jobFailed,
+			wantExitStatus: interruptedExitStatus,
+		},
+		{
+			name:           "an install without installer log failed",
+			job:            Job{Action: "Install"},
+			wantPhase:      jobFailed,
+			wantExitStatus: interruptedExitStatus,
+		},
+		{
+			name:           "a destroy that left no cluster succeeded",
+			job:            Job{Action: "Destroy"},
+			wantPhase:      jobSucceeded,
+			wantExitStatus: 0,
+		},
+		{
+			name:           "a destroy that left the cluster failed",
+			job:            Job{Action: "Destroy"},
+			clusterFiles:   []string{"metadata.json"},
+			wantPhase:      jobFailed,
+			wantExitStatus: interruptedExitStatus,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := useTestConfig(t)
+			if test.installerLog != "" {
+				writeTestFile(t, c.installerLog(), test.installerLog+"\n")
+			}
+			for _, name := range test.clusterFiles {
+				writeTestFile(t, filepath.Join(c.InstallDir, name), "{}")
+			}
+			job := test.job
+			job.ID, job.Phase, job.StartedAt = "0123456789abcdef", jobRunning, time.Now().Add(-time.Hour)
+
+			reconcileInterruptedJob(c, &job)
+
+			if job.Phase != test.wantPhase {
+				t.Errorf("phase = %s, want %s", job.Phase, test.wantPhase)
+			}
+			if job.EndedAt == nil {
+				t.Error("EndedAt is not set")
+			}
+			// The CLI reads the exit status of every job that ended.
+			if job.ExitStatus == nil {
+				t.Fatal("ExitStatus is not set")
+			}
+			if *job.ExitStatus != test.wantExitStatus {
+				t.Errorf("exit status = %d, want %d", *job.ExitStatus, test.wantExitStatus)
+			}
+		})
+	}
+}
+
+func TestRestoreState(t *testing.T) {
+	c := useTestConfig(t)
+	resetJobs(t)
+
+	if _, err := addJob("0123456789abcdef", DeployDestroy{Deploy: "Install", ClusterVersion: "4.14.10"}); err != nil {
+		t.Fatal(err)
+	}
+	installConfig := map[string]interface{}{"apiVersion": "v1", "metadata": map[string]interface{}{"name": "lab1"}, "baseDomain": "example.com"}
+	time.Sleep(10 * time.Millisecond)
+	rememberInstallConfig(installConfig)
+
+	// The agent restarts: the jobs and what it remembered are forgotten, the state file is left.
+	resetJobs(t)
+	if err := restoreState(c); err != nil {
+		t.Fatalf("restoreState() error = %v", err)
+	}
+
+	job, ok := getJob("0123456789abcdef")
+	if !ok {
+		t.Fatal("the job was not restored")
+	}
+	if job.Phase != jobFailed || job.ExitStatus == nil {
+		t.Errorf("restored job = %+v, want Failed with an exit status", job)
+	}
+	if _, err := addJob("fedcba9876543210", DeployDestroy{Deploy: "Destroy"}); err != nil {
+		t.Errorf("addJob() after the restore error = %v, want no job running", err)
+	}
+	if name, domain := rememberedClusterDomain(); name != "lab1" || domain != "example.com" {
+		t.Errorf("remembered cluster = %s.%s, want lab1.example.com", name, domain)
+	}
+	// The install-config came after the install started, so it is written again for the next one.
+	if _, err := os.Stat(filepath.Join(c.InstallDir, "install-config.yaml")); err != nil {
+		t.Errorf("the install-config was not restored: %v", err)
+	}
+}
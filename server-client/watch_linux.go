@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"syscall"
+	"unsafe"
+)
+
+// The changes of the installation directory that can change the cluster state or the installer log.
+const watchedEvents = syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO |
+	syscall.IN_MODIFY | syscall.IN_CLOSE_WRITE | syscall.IN_DELETE_SELF | syscall.IN_MOVE_SELF
+
+// Watches the directory with inotify. A value is sent on the channel when something in the directory changed, several
+// changes in a row are merged into one. The channel is closed when the directory itself is removed or moved, or reading
+// the events fails, so the caller can go back to polling until it can watch again.
+func watchDir(dir string) (<-chan struct{}, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC)
+	if err != nil {
+		return nil, fmt.Errorf("inotify is not available: %v", err)
+	}
+	if _, err := syscall.InotifyAddWatch(fd, dir, watchedEvents); err != nil {
+		syscall.Close(fd)
+		return nil, fmt.Errorf("cannot watch %s: %v", dir, err)
+	}
+
+	changes := make(chan struct{}, 1)
+	go func() {
+		defer syscall.Close(fd)
+		defer close(changes)
+
+		buffer := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+		for {
+			n, err := syscall.Read(fd, buffer)
+			if err == syscall.EINTR {
+				continue
+			} else if err != nil || n < syscall.SizeofInotifyEvent {
+				return
+			}
+
+			gone := false
+			for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+				event := (*syscall.InotifyEvent)(unsafe.Pointer(&buffer[offset]))
+				if event.Mask&(syscall.IN_DELETE_SELF|syscall.IN_MOVE_SELF|syscall.IN_IGNORED) != 0 {
+					gone = true
+				}
+				offset += syscall.SizeofInotifyEvent + int(event.Len)
+			}
+
+			select {
+			case changes <- struct{}{}:
+			default:
+			}
+			if gone {
+				return
+			}
+		}
+	}()
+	return changes, nil
+}
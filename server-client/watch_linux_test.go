@@ -0,0 +1,67 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatchDir(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "cluster")
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	changes, err := watchDir(dir)
+	if err != nil {
+		t.Fatalf("watchDir() error = %v", err)
+	}
+	waitForChange := func(what string) bool {
+		t.Helper()
+		select {
+		case _, open := <-changes:
+			return open
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no change reported after %s", what)
+			return false
+		}
+	}
+
+	writeTestFile(t, filepath.Join(dir, "metadata.json"), "{}")
+	if !waitForChange("metadata.json was created") {
+		t.Fatal("the watch stopped after metadata.json was created")
+	}
+	// The changes made while nobody reads are merged.
+	for len(changes) > 0 {
+		<-changes
+	}
+
+	if err := os.Remove(filepath.Join(dir, "metadata.json")); err != nil {
+		t.Fatal(err)
+	}
+	if !waitForChange("metadata.json was removed") {
+		t.Fatal("the watch stopped after metadata.json was removed")
+	}
+
+	// Removing the directory itself ends the watch so the monitor goes back to polling.
+	if err := os.Remove(dir); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.After(5 * time.Second)
+	for {
+		select {
+		case _, open := <-changes:
+			if !open {
+				return
+			}
+		case <-deadline:
+			t.Fatal("the watch did not end when the directory was removed")
+		}
+	}
+}
+
+func TestWatchMissingDir(t *testing.T) {
+	if _, err := watchDir(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("watchDir() of a missing directory succeeded")
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package main
+
+import "fmt"
+
+// inotify exists only on Linux. Elsewhere the installation directory is polled.
+func watchDir(dir string) (<-chan struct{}, error) {
+	return nil, fmt.Errorf("inotify is not available on this platform")
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+)
+
+// The machine-readable status of a lab as printed by "ocpd status --output json".
+type StatusReport struct {
+	Version    string           `json:"version"`
+	Infra      InfraReport      `json:"infra"`
+	Agent      AgentReport      `json:"agent"`
+	Deployment DeploymentReport `json:"deployment"`
+}
+
+// The infrastructure details terraform reports. The agent token is left out on purpose.
+type InfraReport struct {
+	Region         string   `json:"region"`
+	PublicDNS      string   `json:"publicDNS"`
+	PrivateDNS     string   `json:"privateDNS"`
+	PrivateSubnets []string `json:"privateSubnets"`
+	Error          string   `json:"error,omitempty"`
+}
+
+// The status reported by the agent. If the agent cannot be reached Reachable is false and Error holds the reason.
+type AgentReport struct {
+	Reachable       bool             `json:"reachable"`
+	RegistryHealth  string           `json:"registryHealth,omitempty"`
+	ClusterStatus   string           `json:"clusterStatus,omitempty"`
+	BootstrapStatus string           `json:"bootstrapStatus,omitempty"`
+	RegistryChecks  []RegistryCheck  `json:"registryChecks,omitempty"`
+	Progress        *InstallProgress `json:"progress,omitempty"`
+	Error           string           `json:"error,omitempty"`
+}
+
+// What the local terraform.tfstate says exists. Resources lists every resource terraform tracks for the lab.
+type DeploymentReport struct {
+	RegistryExists bool            `json:"registryExists"`
+	ClusterExists  bool            `json:"clusterExists"`
+	Resources      []InventoryItem `json:"resources"`
+	Error          string          `json:"error,omitempty"`
+}
+
+// Collects the status of the lab from terraform, the agent and the tfstate file without printing anything.
+func buildStatusReport() StatusReport {
+	infraErr := readInfraDetails()
+
+	report := StatusReport{
+		Version: releaseVersion,
+		Infra: InfraReport{
+			Region:         infraDetailsStatus.AWSRegion,
+			PublicDNS:      infraDetailsStatus.InstancePublicDNS,
+			PrivateDNS:     infraDetailsStatus.PrivateDNS,
+			PrivateSubnets: []string{},
+		},
+	}
+	for _, subnet := range []string{infraDetailsStatus.PrivateSubnet1, infraDetailsStatus.PrivateSubnet2, infraDetailsStatus.PrivateSubnet3} {
+		if len(subnet) > 0 {
+			report.Infra.PrivateSubnets = append(report.Infra.PrivateSubnets, subnet)
+		}
+	}
+
+	// Without the infrastructure details there is nothing to contact.
+	err := infraErr
+	if err != nil {
+		report.Infra.Error = err.Error()
+	} else {
+		var client *http.Client
+		client, err = createHTTPClientWithCACert(CAcert)
+		if err == nil {
+			err = fetchAgentStatus(client, infraDetailsStatus.InstancePublicDNS)
+		}
+	}
+	if err != nil {
+		report.Agent.Error = err.Error()
+	} else {
+		report.Agent.Reachable = true
+		report.Agent.RegistryHealth = agentStatus.RegistryHealth
+		report.Agent.ClusterStatus = agentStatus.ClusterStatus
+		report.Agent.BootstrapStatus = agentStatus.BootstrapStatus
+		report.Agent.RegistryChecks = agentStatus.RegistryChecks
+		report.Agent.Progress = agentStatus.Progress
+	}
+
+	report.Deployment.Resources = []InventoryItem{}
+	inventory, err := readInventory()
+	if err != nil {
+		report.Deployment.Error = err.Error()
+	} else {
+		report.Deployment.RegistryExists = inventory.RegistryExists()
+		report.Deployment.ClusterExists = inventory.ClusterExists()
+		report.Deployment.Resources = append(report.Deployment.Resources, inventory.Items...)
+	}
+
+	return report
+}
+
+// Prints the status of the lab as a single JSON document.
+func printStatusJSON() {
+	jsonData, err := json.MarshalIndent(buildStatusReport(), "", "  ")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error marshaling the status to JSON: %v\n", err)
+		os.Exit(2)
+	}
+	fmt.Println(string(jsonData))
+}
+
+// Prints the status of the lab for humans. If the agent cannot be reached the resources in the tfstate file are printed
+// instead so the user knows what is still running on AWS.
+func printStatusText() {
+	err := refreshAgentStatus()
+	if respErr, ok := err.(*agentResponseError); ok {
+		fmt.Printf("The agent responded with error code %v\n", respErr.StatusCode)
+		fmt.Println("Response code of 403 means that the request was not authorized.")
+	} else if err != nil {
+		agentIsDown(err)
+		return
+	} else {
+		printAgentStatus()
+	}
+
+	fmt.Println("")
+	checkDeploymentState()
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+)
+
+// Runs the terraform commands the program needs in the current directory.
+// The terraform CLI is used normally and the tests replace it with a fake so the flows can run without AWS.
+type TerraformExecutor interface {
+	Init() error
+	Plan(args ...string) error
+	Apply(args ...string) error
+	Destroy(args ...string) error
+	Output() ([]byte, error)
+	Show(planFile string) ([]byte, error)
+}
+
+var terraformExecutor TerraformExecutor = &terraformCLI{}
+
+// The real executor. The output of every command that changes something goes to the user.
+type terraformCLI struct{}
+
+func (t *terraformCLI) Init() error {
+	return t.run("init")
+}
+
+func (t *terraformCLI) Plan(args ...string) error {
+	return t.run(append([]string{"plan"}, args...)...)
+}
+
+func (t *terraformCLI) Apply(args ...string) error {
+	return t.run(append([]string{"apply", "-auto-approve"}, args...)...)
+}
+
+func (t *terraformCLI) Destroy(args ...string) error {
+	return t.run(append([]string{"destroy", "-auto-approve"}, args...)...)
+}
+
+// Returns every output of the state as printed by "terraform output -json".
+func (t *terraformCLI) Output() ([]byte, error) {
+	return exec.Command("terraform", "output", "-json").Output()
+}
+
+// Returns the plan saved in planFile as printed by "terraform show -json".
+func (t *terraformCLI) Show(planFile string) ([]byte, error) {
+	return exec.Command("terraform", "show", "-json", planFile).Output()
+}
+
+func (t *terraformCLI) run(args ...string) error {
+	cmd := exec.Command("terraform", args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
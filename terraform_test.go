@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// An in-memory TerraformExecutor. It records every call and returns the canned outputs instead of running terraform.
+type fakeTerraform struct {
+	calls   []string
+	outputs map[string]string
+	plan    []byte
+	errs    map[string]error
+}
+
+func newFakeTerraform(outputs map[string]string) *fakeTerraform {
+	return &fakeTerraform{outputs: outputs, errs: map[string]error{}}
+}
+
+func (f *fakeTerraform) record(command string, args []string) error {
+	f.calls = append(f.calls, strings.TrimSpace(command+" "+strings.Join(args, " ")))
+	return f.errs[command]
+}
+
+func (f *fakeTerraform) Init() error {
+	return f.record("init", nil)
+}
+
+func (f *fakeTerraform) Plan(args ...string) error {
+	return f.record("plan", args)
+}
+
+func (f *fakeTerraform) Apply(args ...string) error {
+	return f.record("apply", args)
+}
+
+func (f *fakeTerraform) Destroy(args ...string) error {
+	return f.record("destroy", args)
+}
+
+func (f *fakeTerraform) Output() ([]byte, error) {
+	if err := f.record("output", nil); err != nil {
+		return nil, err
+	}
+	outputs := map[string]TerraformOutput{}
+	for name, value := range f.outputs {
+		outputs[name] = TerraformOutput{Value: value}
+	}
+	return json.Marshal(outputs)
+}
+
+func (f *fakeTerraform) Show(planFile string) ([]byte, error) {
+	if err := f.record("show", []string{planFile}); err != nil {
+		return nil, err
+	}
+	return f.plan, nil
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+const tfstateFile = "terraform.tfstate"
+
+// The parts of terraform.tfstate (format version 4) the program reads.
+type Tfstate struct {
+	Version int `json:"version"`
+	Outputs map[string]struct {
+		Value interface{} `json:"value"`
+	} `json:"outputs"`
+	Resources []TfstateResource `json:"resources"`
+}
+
+type TfstateResource struct {
+	Mode      string `json:"mode"`
+	Type      string `json:"type"`
+	Name      string `json:"name"`
+	Module    string `json:"module"`
+	Instances []struct {
+		Attributes map[string]interface{} `json:"attributes"`
+	} `json:"instances"`
+}
+
+// A resource terraform manages for the lab.
+type InventoryItem struct {
+	Type   string            `json:"type"`
+	Name   string            `json:"name"`
+	Module string            `json:"module,omitempty"`
+	ID     string            `json:"id"`
+	Tags   map[string]string `json:"tags,omitempty"`
+	Region string            `json:"region"`
+}
+
+// Every resource of the lab as recorded in the tfstate file.
+type Inventory struct {
+	Items []InventoryItem
+}
+
+// Reads the inventory of the lab in the current directory.
+func readInventory() (*Inventory, error) {
+	return readInventoryFrom(tfstateFile)
+}
+
+// Reads the inventory from the tfstate file in the given path. Data sources are left out since they are not provisioned.
+func readInventoryFrom(path string) (*Inventory, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var state Tfstate
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("cannot parse %s: %v", path, err)
+	}
+	if state.Version != 4 {
+		return nil, fmt.Errorf("unsupported tfstate format version %d in %s", state.Version, path)
+	}
+
+	// Resources without a region of their own (IAM, random strings etc..) are reported in the region of the lab.
+	labRegion, _ := state.Outputs["region"].Value.(string)
+
+	inventory := &Inventory{}
+	for _, resource := range state.Resources {
+		if resource.Mode != "managed" {
+			continue
+		}
+		for _, instance := range resource.Instances {
+			item := InventoryItem{
+				Type:   resource.Type,
+				Name:   resource.Name,
+				Module: resource.Module,
+				ID:     stringAttribute(instance.Attributes, "id"),
+				Tags:   tagsAttribute(instance.Attributes),
+				Region: resourceRegion(instance.Attributes),
+			}
+			if len(item.Region) == 0 {
+				item.Region = labRegion
+			}
+			inventory.Items = append(inventory.Items, item)
+		}
+	}
+
+	sort.SliceStable(inventory.Items, func(i, j int) bool {
+		if inventory.Items[i].Module != inventory.Items[j].Module {
+			return inventory.Items[i].Module < inventory.Items[j].Module
+		}
+		return inventory.Items[i].Type < inventory.Items[j].Type
+	})
+	return inventory, nil
+}
+
+func stringAttribute(attributes map[string]interface{}, name string) string {
+	value, _ := attributes[name].(string)
+	return value
+}
+
+func tagsAttribute(attributes map[string]interface{}) map[string]string {
+	tags, ok := attributes["tags"].(map[string]interface{})
+	if !ok || len(tags) == 0 {
+		return nil
+	}
+	result := map[string]string{}
+	for key, value := range tags {
+		result[key] = fmt.Sprint(value)
+	}
+	return result
+}
+
+// The region of a resource from its region attribute, its ARN or its availability zone. Empty for global resources.
+func resourceRegion(attributes map[string]interface{}) string {
+	if region := stringAttribute(attributes, "region"); len(region) > 0 {
+		return region
+	}
+	if arn := strings.Split(stringAttribute(attributes, "arn"), ":"); len(arn) > 3 && len(arn[3]) > 0 {
+		return arn[3]
+	}
+	if zone := stringAttribute(attributes, "availability_zone"); len(zone) > 1 {
+		return zone[:len(zone)-1]
+	}
+	return ""
+}
+
+func (inv *Inventory) has(resourceType string) bool {
+	for _, item := range inv.Items {
+		if item.Type == resourceType {
+			return true
+		}
+	}
+	return false
+}
+
+// The mirror registry exists if its EC2 instance is in the state.
+func (inv *Inventory) RegistryExists() bool {
+	return inv.has("aws_instance")
+}
+
+// The cluster dependencies exist if the VPC endpoints created for the cluster are in the state along with the registry.
+func (inv *Inventory) ClusterExists() bool {
+	return inv.RegistryExists() && inv.has("aws_vpc_endpoint")
+}
+
+// Prints every resource of the inventory.
+func (inv *Inventory) print() {
+	if len(inv.Items) == 0 {
+		fmt.Println("There is no infrastructure provisioned")
+		return
+	}
+
+	fmt.Printf("Resources recorded in %s (%d):\n", tfstateFile, len(inv.Items))
+	fmt.Printf("  %-32s %-28s %-34s %-24s %-14s %s\n", "TYPE", "NAME", "MODULE", "ID", "REGION", "TAGS")
+	for _, item := range inv.Items {
+		module := item.Module
+		if len(module) == 0 {
+			module = "-"
+		}
+		var tags []string
+		for key, value := range item.Tags {
+			tags = append(tags, key+"="+value)
+		}
+		sort.Strings(tags)
+		fmt.Printf("  %-32s %-28s %-34s %-24s %-14s %s\n", item.Type, item.Name, module, item.ID, item.Region, strings.Join(tags, ","))
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	tfvarsFile       = "terraform.tfvars.json"
+	legacyTfvarsFile = "terraform.tfvars"
+)
+
+// The variables of inputs.tf. They are written as terraform.tfvars.json so each variable is serialized on its own
+// and changing one of them can never touch another. New variables of inputs.tf need to be added here too.
+type TerraformVars struct {
+	Region            string `json:"Region"`
+	AvailabilityZoneA string `json:"Availability_Zone_A"`
+	AvailabilityZoneB string `json:"Availability_Zone_B"`
+	AvailabilityZoneC string `json:"Availability_Zone_C"`
+	PublicKeyPath     string `json:"Public_Key_Path"`
+	AmiID             string `json:"Ami_Id"`
+	CreateCluster     bool   `json:"Create_Cluster"`
+}
+
+// Creates the variables of a new lab in the given region. The cluster is added later so it is not requested here.
+func newTerraformVars(publicKey string, region string, amiID string) *TerraformVars {
+	return &TerraformVars{
+		Region:            region,
+		AvailabilityZoneA: region + "a",
+		AvailabilityZoneB: region + "b",
+		AvailabilityZoneC: region + "c",
+		PublicKeyPath:     publicKey,
+		AmiID:             amiID,
+	}
+}
+
+// Reads the variables of the lab. Labs installed by older versions have an HCL terraform.tfvars which is read instead.
+func readTerraformVars() (*TerraformVars, error) {
+	vars := &TerraformVars{}
+
+	data, err := os.ReadFile(tfvarsFile)
+	if os.IsNotExist(err) {
+		return readLegacyTerraformVars()
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, vars); err != nil {
+		return nil, fmt.Errorf("cannot parse %s: %v", tfvarsFile, err)
+	}
+	return vars, nil
+}
+
+// Reads the terraform.tfvars created from terraform.tfvars.temp by older versions. It only has "Name = value" lines.
+func readLegacyTerraformVars() (*TerraformVars, error) {
+	data, err := os.ReadFile(legacyTfvarsFile)
+	if err != nil {
+		return nil, err
+	}
+
+	values := map[string]interface{}{}
+	for _, line := range strings.Split(string(data), "\n") {
+		name, value, found := strings.Cut(line, "=")
+		if !found {
+			continue
+		}
+		name = strings.TrimSpace(name)
+		value = strings.TrimSpace(value)
+		if unquoted, err := strconv.Unquote(value); err == nil {
+			values[name] = unquoted
+		} else if boolean, err := strconv.ParseBool(value); err == nil {
+			values[name] = boolean
+		}
+	}
+
+	converted, err := json.Marshal(values)
+	if err != nil {
+		return nil, err
+	}
+	vars := &TerraformVars{}
+	if err := json.Unmarshal(converted, vars); err != nil {
+		return nil, fmt.Errorf("cannot parse %s: %v", legacyTfvarsFile, err)
+	}
+	return vars, nil
+}
+
+// Writes the variables to terraform.tfvars.json. The HCL file of older versions is removed so terraform does not load both.
+func (v *TerraformVars) write() error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(tfvarsFile, data, 0644); err != nil {
+		return err
+	}
+	if err := os.Remove(legacyTfvarsFile); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+)
+
+// How long the program waits for the registry, the agent or the cluster before giving up. Set with --timeout.
+var readinessTimeout = 60 * time.Minute
+
+// The first and the longest delay between two checks while waiting. The delay doubles after every check.
+var (
+	pollInitialDelay = 5 * time.Second
+	pollMaxDelay     = 1 * time.Minute
+)
+
+// Registers the --timeout flag on the flag set of a subcommand that waits for the lab.
+func timeoutFlag(fs *flag.FlagSet) *time.Duration {
+	return fs.Duration("timeout", readinessTimeout, "How long to wait for the registry, the agent or the cluster before giving up (e.g 30m, 1h)")
+}
+
+// Checks the condition until it returns true, with exponential backoff between checks.
+// It gives up once the next check would be after the timeout. The last error of the condition is part of the returned error.
+func waitFor(what string, timeout time.Duration, condition func() (bool, error)) error {
+	deadline := time.Now().Add(timeout)
+	delay := pollInitialDelay
+
+	for try := 1; ; try++ {
+		done, err := condition()
+		if done {
+			return nil
+		}
+
+		if time.Now().Add(delay).After(deadline) {
+			if err != nil {
+				return fmt.Errorf("timed out after %v waiting for %s: %v", timeout, what, err)
+			}
+			return fmt.Errorf("timed out after %v waiting for %s", timeout, what)
+		}
+
+		if err != nil {
+			fmt.Printf("Try No %v ... Waiting for %s (%v). Checking again in %v\n", try, what, err, delay)
+		} else {
+			fmt.Printf("Try No %v ... Waiting for %s. Checking again in %v\n", try, what, delay)
+		}
+		time.Sleep(delay)
+
+		delay *= 2
+		if delay > pollMaxDelay {
+			delay = pollMaxDelay
+		}
+	}
+}